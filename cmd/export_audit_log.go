@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/envconf"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/spf13/cobra"
+)
+
+var exportAuditLogEnvconf = envconf.New()
+
+// exportAuditLogCmd represents the export-audit-log command.
+var exportAuditLogCmd = &cobra.Command{
+	Use:   "export-audit-log",
+	Short: "Export the administrative audit log",
+	Args:  cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		filter := &db.AuditLogFilter{}
+		filter.Action, _ = cmd.Flags().GetString("action")
+		filter.Actor, _ = cmd.Flags().GetString("actor")
+		filter.Target, _ = cmd.Flags().GetString("target")
+		format, _ := cmd.Flags().GetString("format")
+
+		if filter.Action != "" && !slices.Contains(db.AuditActions, filter.Action) {
+			fmt.Fprintf(os.Stderr, "--action must be one of %s.\n", strings.Join(db.AuditActions, ", "))
+			os.Exit(1)
+		}
+		if format != "csv" && format != "json" {
+			fmt.Fprintf(os.Stderr, "--format must be csv or json.\n")
+			os.Exit(1)
+		}
+
+		for _, flag := range []struct {
+			name  string
+			value *time.Time
+		}{{"since", &filter.Since}, {"until", &filter.Until}} {
+			s, _ := cmd.Flags().GetString(flag.name)
+			if s == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				t, err = time.Parse(time.DateOnly, s)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "--%s must be a date such as 2024-01-31 or a time such as 2024-01-31T12:00:00Z.\n", flag.name)
+				os.Exit(1)
+			}
+			*flag.value = t
+		}
+
+		// Get config from the environment.
+		databaseURL := exportAuditLogEnvconf.Value("DATABASE_URL")
+
+		logger := setupLogger("console")
+		dbpool := setupPGXConnPool(context.Background(), databaseURL, logger)
+
+		entries, err := db.SelectAuditLogEntries(context.Background(), dbpool, filter, 0, 0)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to select audit log entries")
+		}
+
+		if format == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			for _, entry := range entries {
+				err := encoder.Encode(auditLogExportRecord(entry))
+				if err != nil {
+					logger.Fatal().Err(err).Msg("Failed to write audit log entry")
+				}
+			}
+			return
+		}
+
+		csvWriter := csv.NewWriter(os.Stdout)
+		csvWriter.Write([]string{"time", "action", "actor_user_id", "actor_username", "target_id", "target_name", "request_id", "client_ip", "changes"})
+		for _, entry := range entries {
+			record := auditLogExportRecord(entry)
+			var changes string
+			if record.Changes != nil {
+				buf, err := json.Marshal(record.Changes)
+				if err != nil {
+					logger.Fatal().Err(err).Msg("Failed to write audit log entry")
+				}
+				changes = string(buf)
+			}
+			csvWriter.Write([]string{
+				record.Time.Format(time.RFC3339Nano),
+				record.Action,
+				record.ActorUserID,
+				record.ActorUsername,
+				record.TargetID,
+				record.TargetName,
+				record.RequestID,
+				record.ClientIP,
+				changes,
+			})
+		}
+		csvWriter.Flush()
+		err = csvWriter.Error()
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to write CSV")
+		}
+	},
+}
+
+// auditLogExportEntry is an audit log entry as it is exported.
+type auditLogExportEntry struct {
+	Time          time.Time                 `json:"time"`
+	Action        string                    `json:"action"`
+	ActorUserID   string                    `json:"actor_user_id"`
+	ActorUsername string                    `json:"actor_username"`
+	TargetID      string                    `json:"target_id"`
+	TargetName    string                    `json:"target_name"`
+	RequestID     string                    `json:"request_id"`
+	ClientIP      string                    `json:"client_ip"`
+	Changes       map[string]db.AuditChange `json:"changes"`
+}
+
+func auditLogExportRecord(entry *db.AuditLogEntry) *auditLogExportEntry {
+	record := &auditLogExportEntry{
+		Time:          entry.Time.UTC(),
+		Action:        entry.Action,
+		ActorUsername: entry.ActorUsername,
+		TargetName:    string(entry.TargetName),
+		RequestID:     string(entry.RequestID),
+		ClientIP:      string(entry.ClientIP),
+		Changes:       entry.Changes,
+	}
+	if entry.ActorUserID.Valid {
+		record.ActorUserID = entry.ActorUserID.UUID.String()
+	}
+	if entry.TargetID.Valid {
+		record.TargetID = entry.TargetID.UUID.String()
+	}
+
+	return record
+}
+
+func init() {
+	exportAuditLogEnvconf.Register(envconf.Item{Name: "DATABASE_URL", Default: "", Description: "The PostgreSQL connection string"})
+
+	long := &strings.Builder{}
+	long.WriteString("Export the administrative audit log to stdout, newest first.\n\n")
+	long.WriteString("The log records changes made to users and roles, password resets and impersonations. An empty actor user ID\n")
+	long.WriteString("means the change was made from the command line by the operating system user in actor_username.\n\n")
+	long.WriteString("Configure with the following environment variables:\n\n")
+	for _, item := range exportAuditLogEnvconf.Items() {
+		long.WriteString(fmt.Sprintf("  %s\n    Default: %s\n    %s\n\n", item.Name, item.Default, item.Description))
+	}
+	exportAuditLogCmd.Long = long.String()
+
+	exportAuditLogCmd.Flags().String("action", "", "Only export entries with this action")
+	exportAuditLogCmd.Flags().String("actor", "", "Only export entries made by this username")
+	exportAuditLogCmd.Flags().String("target", "", "Only export entries for this user or role name")
+	exportAuditLogCmd.Flags().String("since", "", "Only export entries at or after this date or time")
+	exportAuditLogCmd.Flags().String("until", "", "Only export entries before this date or time")
+	exportAuditLogCmd.Flags().String("format", "csv", "Output format: csv or json")
+
+	rootCmd.AddCommand(exportAuditLogCmd)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/envconf"
+	"github.com/spf13/cobra"
+)
+
+var generateKeyGenerationEnvconf = envconf.New()
+
+// keyGenerationNames are the environment variables that hold key generations.
+var keyGenerationNames = []string{"CSRF_KEY", "COOKIE_AUTHENTICATION_KEY", "COOKIE_ENCRYPTION_KEY"}
+
+// generateKeyGenerationCmd represents the generate-key-generation command.
+var generateKeyGenerationCmd = &cobra.Command{
+	Use:   "generate-key-generation",
+	Short: "Generate a new generation of cookie and CSRF keys",
+	Args:  cobra.NoArgs,
+
+	Run: func(cmd *cobra.Command, args []string) {
+		maxGenerations, _ := cmd.Flags().GetInt("max-generations")
+		if maxGenerations < 1 {
+			fmt.Fprintln(os.Stderr, "max-generations must be greater than 0")
+			os.Exit(1)
+		}
+
+		for _, name := range keyGenerationNames {
+			randBytes := make([]byte, 32)
+			_, err := rand.Read(randBytes)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to generate random bytes: %v\n", err)
+				os.Exit(1)
+			}
+
+			keys := append([]string{hex.EncodeToString(randBytes)}, strings.Fields(generateKeyGenerationEnvconf.Value(name))...)
+			keys = keys[:min(len(keys), maxGenerations)]
+
+			fmt.Printf("export %s=\"%s\"\n", name, strings.Join(keys, " "))
+		}
+	},
+}
+
+func init() {
+	for _, name := range keyGenerationNames {
+		generateKeyGenerationEnvconf.Register(envconf.Item{Name: name, Default: "", Description: "Current key generations. Newest first"})
+	}
+
+	generateKeyGenerationCmd.Flags().Int("max-generations", 2, "Maximum number of key generations to keep")
+
+	long := &strings.Builder{}
+	long.WriteString("Generate a new generation of cookie and CSRF keys.\n\n")
+	long.WriteString("Prints shell export statements that prepend a new key to each key variable in the current environment. Keys\n")
+	long.WriteString("beyond --max-generations are dropped. Deploy the new values to all servers to rotate keys without logging\n")
+	long.WriteString("users out or breaking open forms. Login session cookies are reissued with the newest key as they are used.\n")
+	long.WriteString("CSRF cookies expire after 12 hours so keep the previous generation at least that long. Login sessions that\n")
+	long.WriteString("are not used while the previous generation is still configured are logged out when it is dropped.\n\n")
+	long.WriteString("Configure with the following environment variables:\n\n")
+	for _, item := range generateKeyGenerationEnvconf.Items() {
+		long.WriteString(fmt.Sprintf("  %s\n    Default: %s\n    %s\n\n", item.Name, item.Default, item.Description))
+	}
+	generateKeyGenerationCmd.Long = long.String()
+
+	rootCmd.AddCommand(generateKeyGenerationCmd)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/envconf"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/spf13/cobra"
+)
+
+var importPasswordHashesEnvconf = envconf.New()
+
+// importPasswordHashesCmd represents the import-password-hashes command.
+var importPasswordHashesCmd = &cobra.Command{
+	Use:   "import-password-hashes file",
+	Short: "Import password hashes from another system",
+	Args:  cobra.ExactArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get config from the environment.
+		databaseURL := importPasswordHashesEnvconf.Value("DATABASE_URL")
+
+		logger := setupLogger("console")
+		dbpool := setupPGXConnPool(context.Background(), databaseURL, logger)
+
+		var in io.Reader = os.Stdin
+		if args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				logger.Fatal().Err(err).Msg("Failed to open file")
+			}
+			defer file.Close()
+			in = file
+		}
+
+		csvReader := csv.NewReader(in)
+		csvReader.FieldsPerRecord = 2
+
+		var importedCount, failedCount int
+		for {
+			record, err := csvReader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				logger.Fatal().Err(err).Msg("Failed to read CSV")
+			}
+
+			username, hash := record[0], record[1]
+
+			var userID uuid.UUID
+			err = dbpool.QueryRow(context.Background(), "select id from users where username = $1", username).Scan(&userID)
+			if err != nil {
+				logger.Error().Err(err).Str("username", username).Msg("Failed to find user")
+				failedCount++
+				continue
+			}
+
+			err = db.ImportUserPasswordHash(context.Background(), dbpool, userID, hash)
+			if err != nil {
+				logger.Error().Err(err).Str("username", username).Msg("Failed to import password hash")
+				failedCount++
+				continue
+			}
+
+			importedCount++
+		}
+
+		fmt.Printf("Imported %d password hashes. %d failed.\n", importedCount, failedCount)
+		if failedCount > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	importPasswordHashesEnvconf.Register(envconf.Item{Name: "DATABASE_URL", Default: "", Description: "The PostgreSQL connection string"})
+
+	long := &strings.Builder{}
+	long.WriteString("Import password hashes from another system.\n\n")
+	long.WriteString("The file is CSV with a username and a password hash on each line. Use - to read from stdin. bcrypt, and PHC\n")
+	long.WriteString("format scrypt and Argon2id hashes are supported. Imported hashes are upgraded to the current Argon2id\n")
+	long.WriteString("parameters when the user next logs in.\n\nConfigure with the following environment variables:\n\n")
+	for _, item := range importPasswordHashesEnvconf.Items() {
+		long.WriteString(fmt.Sprintf("  %s\n    Default: %s\n    %s\n\n", item.Name, item.Default, item.Description))
+	}
+	importPasswordHashesCmd.Long = long.String()
+
+	rootCmd.AddCommand(importPasswordHashesCmd)
+}
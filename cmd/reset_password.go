@@ -5,10 +5,13 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/envconf"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/web-starter-app/db"
 	"github.com/spf13/cobra"
 )
@@ -18,12 +21,19 @@ var resetPasswordEnvconf = envconf.New()
 // resetPasswordCmd represents the reset-password command.
 var resetPasswordCmd = &cobra.Command{
 	Use:   "reset-password username",
-	Short: "Reset a user's password",
+	Short: "Reset a user's password to a temporary password",
 	Args:  cobra.ExactArgs(1),
 
 	Run: func(cmd *cobra.Command, args []string) {
+		expires, _ := cmd.Flags().GetDuration("expires")
+		if expires <= 0 {
+			fmt.Fprintf(os.Stderr, "--expires must be a positive duration such as 24h.\n")
+			os.Exit(1)
+		}
+
 		// Get config from the environment.
 		databaseURL := resetPasswordEnvconf.Value("DATABASE_URL")
+		setupArgon2Params(resetPasswordEnvconf)
 
 		logger := setupLogger("console")
 		dbpool := setupPGXConnPool(context.Background(), databaseURL, logger)
@@ -43,25 +53,37 @@ var resetPasswordCmd = &cobra.Command{
 		}
 
 		password := hex.EncodeToString(randBytes)
+		expireTime := time.Now().Add(expires)
 
-		err = db.SetUserPassword(context.Background(), dbpool, userID, password)
+		err = pgx.BeginFunc(context.Background(), dbpool, func(tx pgx.Tx) error {
+			err := db.ResetUserPassword(context.Background(), tx, userID, password, expireTime)
+			if err != nil {
+				return err
+			}
+
+			return db.InsertAuditLogEntry(context.Background(), tx, commandLineAuditActor(), db.AuditActionUserResetPassword, userID, username, nil)
+		})
 		if err != nil {
 			logger.Fatal().Err(err).Msg("Failed to set user password")
 		}
 
-		fmt.Printf("Password reset for user %s: %s\n", username, password)
+		fmt.Printf("Temporary password for user %s: %s\n", username, password)
+		fmt.Printf("It expires at %s and must be changed after login.\n", expireTime.Format(time.RFC3339))
 	},
 }
 
 func init() {
 	resetPasswordEnvconf.Register(envconf.Item{Name: "DATABASE_URL", Default: "", Description: "The PostgreSQL connection string"})
+	registerArgon2Envconf(resetPasswordEnvconf)
 
 	long := &strings.Builder{}
-	long.WriteString("Reset a users password.\n\nConfigure with the following environment variables:\n\n")
+	long.WriteString("Reset a users password to a random temporary password. The user must choose a new password after logging in.\n\nConfigure with the following environment variables:\n\n")
 	for _, item := range serveEnvconf.Items() {
 		long.WriteString(fmt.Sprintf("  %s\n    Default: %s\n    %s\n\n", item.Name, item.Default, item.Description))
 	}
 	resetPasswordCmd.Long = long.String()
 
+	resetPasswordCmd.Flags().Duration("expires", 24*time.Hour, "How long the temporary password can be used to log in")
+
 	rootCmd.AddCommand(resetPasswordCmd)
 }
@@ -11,9 +11,13 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jackc/envconf"
 	"github.com/jackc/web-starter-app/httpz"
+	"github.com/jackc/web-starter-app/lib/breachedpasswords"
+	"github.com/jackc/web-starter-app/lib/mail"
+	"github.com/jackc/web-starter-app/lib/realip"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 )
@@ -34,20 +38,34 @@ var serveCmd = &cobra.Command{
 		listenAddress := serveEnvconf.Value("LISTEN_ADDRESS")
 		logFormat := serveEnvconf.Value("LOG_FORMAT")
 
-		digestKey := func(keyName string, minInputLen, outputLen int) []byte {
-			str := serveEnvconf.Value(keyName)
-			if len(str) < minInputLen {
-				fmt.Fprintf(os.Stderr, "%s not set or too short.\n", keyName)
+		// Each key variable holds a whitespace separated list of key generations ordered newest first.
+		digestKeys := func(keyName string, minInputLen, outputLen int) [][]byte {
+			strs := strings.Fields(serveEnvconf.Value(keyName))
+			if len(strs) == 0 {
+				fmt.Fprintf(os.Stderr, "%s not set.\n", keyName)
 				os.Exit(1)
 			}
 
-			h := sha512.Sum512([]byte(str))
-			return h[:outputLen]
+			keys := make([][]byte, len(strs))
+			for i, str := range strs {
+				if len(str) < minInputLen {
+					fmt.Fprintf(os.Stderr, "%s key generation %d is too short.\n", keyName, i+1)
+					os.Exit(1)
+				}
+
+				h := sha512.Sum512([]byte(str))
+				keys[i] = h[:outputLen]
+			}
+			return keys
 		}
 
-		csrfKey := digestKey("CSRF_KEY", 64, 64)
-		cookieAuthenticationKey := digestKey("COOKIE_AUTHENTICATION_KEY", 64, 64)
-		cookieEncryptionKey := digestKey("COOKIE_ENCRYPTION_KEY", 64, 32)
+		csrfKeys := digestKeys("CSRF_KEY", 64, 64)
+		cookieAuthenticationKeys := digestKeys("COOKIE_AUTHENTICATION_KEY", 64, 64)
+		cookieEncryptionKeys := digestKeys("COOKIE_ENCRYPTION_KEY", 64, 32)
+		if len(cookieAuthenticationKeys) != len(cookieEncryptionKeys) {
+			fmt.Fprintf(os.Stderr, "COOKIE_AUTHENTICATION_KEY and COOKIE_ENCRYPTION_KEY must have the same number of key generations.\n")
+			os.Exit(1)
+		}
 
 		cookieSecure, err := strconv.ParseBool(serveEnvconf.Value("COOKIE_SECURE"))
 		if err != nil {
@@ -55,12 +73,118 @@ var serveCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		parsePositiveDuration := func(keyName string) time.Duration {
+			d, err := time.ParseDuration(serveEnvconf.Value(keyName))
+			if err != nil || d <= 0 {
+				fmt.Fprintf(os.Stderr, "%s must be a positive duration such as 12h or 30m.\n", keyName)
+				os.Exit(1)
+			}
+			return d
+		}
+
+		setupArgon2Params(serveEnvconf)
+
+		loginSessionIdleTimeout := parsePositiveDuration("LOGIN_SESSION_IDLE_TIMEOUT")
+		loginSessionMaxLifetime := parsePositiveDuration("LOGIN_SESSION_MAX_LIFETIME")
+		persistentLoginSessionIdleTimeout := parsePositiveDuration("LOGIN_SESSION_REMEMBER_ME_IDLE_TIMEOUT")
+		persistentLoginSessionMaxLifetime := parsePositiveDuration("LOGIN_SESSION_REMEMBER_ME_MAX_LIFETIME")
+		loginSessionCacheTTL := parsePositiveDuration("LOGIN_SESSION_CACHE_TTL")
+		loginSessionCacheDisconnectedTTL := parsePositiveDuration("LOGIN_SESSION_CACHE_DISCONNECTED_TTL")
+
+		webAuthnRPID := serveEnvconf.Value("WEBAUTHN_RP_ID")
+		var webAuthnRPOrigins []string
+		for _, origin := range strings.Split(serveEnvconf.Value("WEBAUTHN_RP_ORIGINS"), ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				webAuthnRPOrigins = append(webAuthnRPOrigins, origin)
+			}
+		}
+
+		baseURL := serveEnvconf.Value("BASE_URL")
+
+		trustedProxies, err := realip.ParsePrefixes(serveEnvconf.Value("TRUSTED_PROXIES"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "TRUSTED_PROXIES must be a comma separated list of IP addresses and CIDR prefixes: %s\n", err)
+			os.Exit(1)
+		}
+
+		registrationEnabled, err := strconv.ParseBool(serveEnvconf.Value("REGISTRATION_ENABLED"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "REGISTRATION_ENABLED must be true or false.\n")
+			os.Exit(1)
+		}
+		mailFrom := serveEnvconf.Value("MAIL_FROM")
+
+		var oidcConfig *httpz.OIDCConfig
+		if issuerURL := serveEnvconf.Value("OIDC_ISSUER_URL"); issuerURL != "" {
+			oidcConfig = &httpz.OIDCConfig{
+				IssuerURL:     issuerURL,
+				ClientID:      serveEnvconf.Value("OIDC_CLIENT_ID"),
+				ClientSecret:  serveEnvconf.Value("OIDC_CLIENT_SECRET"),
+				DisplayName:   serveEnvconf.Value("OIDC_DISPLAY_NAME"),
+				Scopes:        strings.Fields(serveEnvconf.Value("OIDC_SCOPES")),
+				UsernameClaim: serveEnvconf.Value("OIDC_USERNAME_CLAIM"),
+				EmailClaim:    serveEnvconf.Value("OIDC_EMAIL_CLAIM"),
+			}
+			if oidcConfig.ClientID == "" {
+				fmt.Fprintf(os.Stderr, "OIDC_CLIENT_ID must be set when OIDC_ISSUER_URL is set.\n")
+				os.Exit(1)
+			}
+
+			oidcConfig.AutoProvision, err = strconv.ParseBool(serveEnvconf.Value("OIDC_AUTO_PROVISION"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "OIDC_AUTO_PROVISION must be true or false.\n")
+				os.Exit(1)
+			}
+			if oidcConfig.AutoProvision && !registrationEnabled {
+				fmt.Fprintf(os.Stderr, "OIDC_AUTO_PROVISION requires REGISTRATION_ENABLED.\n")
+				os.Exit(1)
+			}
+
+			oidcConfig.LinkByEmail, err = strconv.ParseBool(serveEnvconf.Value("OIDC_LINK_BY_EMAIL"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "OIDC_LINK_BY_EMAIL must be true or false.\n")
+				os.Exit(1)
+			}
+		}
+
+		passwordPolicy := &httpz.PasswordPolicy{}
+		passwordPolicy.MinLength, err = strconv.Atoi(serveEnvconf.Value("PASSWORD_MIN_LENGTH"))
+		if err != nil || passwordPolicy.MinLength < 1 {
+			fmt.Fprintf(os.Stderr, "PASSWORD_MIN_LENGTH must be a positive integer.\n")
+			os.Exit(1)
+		}
+		if breachedPasswordsFile := serveEnvconf.Value("BREACHED_PASSWORDS_FILE"); breachedPasswordsFile != "" {
+			passwordPolicy.BreachedPasswords, err = breachedpasswords.Open(breachedPasswordsFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not open breached passwords file: %s\n", err)
+				os.Exit(1)
+			}
+			defer passwordPolicy.BreachedPasswords.Close()
+		}
+
 		// processCtx and processCancel are used to signal when the process is shutting down.
 		processCtx, processCancel := context.WithCancel(context.Background())
 
 		logger := setupLogger(logFormat)
 		dbpool := setupPGXConnPool(processCtx, databaseURL, logger)
 
+		var mailer mail.Mailer
+		switch serveEnvconf.Value("MAILER") {
+		case "smtp":
+			mailer = &mail.SMTPMailer{
+				Addr:     serveEnvconf.Value("SMTP_ADDRESS"),
+				Username: serveEnvconf.Value("SMTP_USERNAME"),
+				Password: serveEnvconf.Value("SMTP_PASSWORD"),
+			}
+		case "file":
+			mailer = &mail.FileMailer{Dir: serveEnvconf.Value("MAIL_FILE_DIRECTORY")}
+		case "log":
+			mailer = &mail.LogMailer{Logger: logger}
+		default:
+			fmt.Fprintf(os.Stderr, "MAILER must be smtp, file or log.\n")
+			os.Exit(1)
+		}
+
 		loadManifest := func(path string) (map[string]string, error) {
 			manifestBytes, err := os.ReadFile(path)
 			if err != nil {
@@ -105,15 +229,36 @@ var serveCmd = &cobra.Command{
 		wg := &sync.WaitGroup{}
 
 		if startHTTPServer {
-			handler, err := httpz.NewHandler(
-				dbpool,
-				zerolog.Ctx(processCtx),
-				csrfKey,
-				cookieSecure,
-				cookieAuthenticationKey,
-				cookieEncryptionKey,
-				assetManifest,
-			)
+			loginSessionCache := httpz.NewLoginSessionCache(loginSessionCacheTTL, loginSessionCacheDisconnectedTTL)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				loginSessionCache.Listen(processCtx, dbpool)
+			}()
+
+			handler, err := httpz.NewHandler(&httpz.HandlerConfig{
+				DBPool:                            dbpool,
+				Logger:                            zerolog.Ctx(processCtx),
+				CSRFKeys:                          csrfKeys,
+				SecureCookies:                     cookieSecure,
+				CookieAuthenticationKeys:          cookieAuthenticationKeys,
+				CookieEncryptionKeys:              cookieEncryptionKeys,
+				LoginSessionIdleTimeout:           loginSessionIdleTimeout,
+				LoginSessionMaxLifetime:           loginSessionMaxLifetime,
+				PersistentLoginSessionIdleTimeout: persistentLoginSessionIdleTimeout,
+				PersistentLoginSessionMaxLifetime: persistentLoginSessionMaxLifetime,
+				WebAuthnRPID:                      webAuthnRPID,
+				WebAuthnRPOrigins:                 webAuthnRPOrigins,
+				Mailer:                            mailer,
+				MailFrom:                          mailFrom,
+				BaseURL:                           baseURL,
+				TrustedProxies:                    trustedProxies,
+				RegistrationEnabled:               registrationEnabled,
+				OIDCConfig:                        oidcConfig,
+				PasswordPolicy:                    passwordPolicy,
+				LoginSessionCache:                 loginSessionCache,
+				AssetManifest:                     assetManifest,
+			})
 			if err != nil {
 				zerolog.Ctx(processCtx).Fatal().Err(err).Msg("Could not create HTTP app handler")
 			}
@@ -155,10 +300,39 @@ func init() {
 	serveEnvconf.Register(envconf.Item{Name: "DATABASE_URL", Default: "", Description: "The PostgreSQL connection string"})
 	serveEnvconf.Register(envconf.Item{Name: "LISTEN_ADDRESS", Default: "127.0.0.1:8080", Description: "The address to listen on for HTTP requests"})
 	serveEnvconf.Register(envconf.Item{Name: "LOG_FORMAT", Default: "json", Description: "Log format (json or console)"})
-	serveEnvconf.Register(envconf.Item{Name: "CSRF_KEY", Default: "", Description: "Key for CSRF protection"})
+	serveEnvconf.Register(envconf.Item{Name: "CSRF_KEY", Default: "", Description: "Whitespace separated keys for CSRF protection. Newest first"})
 	serveEnvconf.Register(envconf.Item{Name: "COOKIE_SECURE", Default: "true", Description: "Set the Secure flag on cookies"})
-	serveEnvconf.Register(envconf.Item{Name: "COOKIE_AUTHENTICATION_KEY", Default: "", Description: "Key to protect cookies from tampering"})
-	serveEnvconf.Register(envconf.Item{Name: "COOKIE_ENCRYPTION_KEY", Default: "", Description: "Key to protect cookies from being readable by the client"})
+	serveEnvconf.Register(envconf.Item{Name: "COOKIE_AUTHENTICATION_KEY", Default: "", Description: "Whitespace separated keys to protect cookies from tampering. Newest first"})
+	serveEnvconf.Register(envconf.Item{Name: "COOKIE_ENCRYPTION_KEY", Default: "", Description: "Whitespace separated keys to protect cookies from being readable by the client. Newest first"})
+	serveEnvconf.Register(envconf.Item{Name: "LOGIN_SESSION_IDLE_TIMEOUT", Default: "24h", Description: "Log out sessions that have not made a request for this long"})
+	serveEnvconf.Register(envconf.Item{Name: "LOGIN_SESSION_MAX_LIFETIME", Default: "720h", Description: "Log out sessions this long after login regardless of activity"})
+	serveEnvconf.Register(envconf.Item{Name: "LOGIN_SESSION_REMEMBER_ME_IDLE_TIMEOUT", Default: "720h", Description: "Log out \"remember me\" sessions that have not made a request for this long"})
+	serveEnvconf.Register(envconf.Item{Name: "LOGIN_SESSION_REMEMBER_ME_MAX_LIFETIME", Default: "2160h", Description: "Log out \"remember me\" sessions this long after login regardless of activity"})
+	serveEnvconf.Register(envconf.Item{Name: "LOGIN_SESSION_CACHE_TTL", Default: "5m", Description: "How long login sessions are cached in memory. Changes are normally seen immediately through PostgreSQL notifications"})
+	serveEnvconf.Register(envconf.Item{Name: "LOGIN_SESSION_CACHE_DISCONNECTED_TTL", Default: "5s", Description: "How long login sessions are cached in memory while not receiving PostgreSQL notifications"})
+	serveEnvconf.Register(envconf.Item{Name: "WEBAUTHN_RP_ID", Default: "localhost", Description: "WebAuthn relying party ID. The domain passkeys are bound to"})
+	serveEnvconf.Register(envconf.Item{Name: "WEBAUTHN_RP_ORIGINS", Default: "http://localhost:8080", Description: "Comma separated list of origins passkey ceremonies are allowed from"})
+	registerArgon2Envconf(serveEnvconf)
+	serveEnvconf.Register(envconf.Item{Name: "BASE_URL", Default: "http://localhost:8080", Description: "The URL the application is reached at. Used to build links in emails"})
+	serveEnvconf.Register(envconf.Item{Name: "TRUSTED_PROXIES", Default: "", Description: "Comma separated IP addresses and CIDR prefixes of reverse proxies trusted to set X-Forwarded-For. The header is ignored if empty"})
+	serveEnvconf.Register(envconf.Item{Name: "REGISTRATION_ENABLED", Default: "false", Description: "Allow anyone to create an account"})
+	serveEnvconf.Register(envconf.Item{Name: "MAILER", Default: "log", Description: "How to send email (smtp, file or log)"})
+	serveEnvconf.Register(envconf.Item{Name: "MAIL_FROM", Default: "web-starter-app <noreply@localhost>", Description: "The From address of email sent by the application"})
+	serveEnvconf.Register(envconf.Item{Name: "SMTP_ADDRESS", Default: "localhost:25", Description: "The host:port of the SMTP server when MAILER is smtp"})
+	serveEnvconf.Register(envconf.Item{Name: "SMTP_USERNAME", Default: "", Description: "The SMTP username when MAILER is smtp. Authentication is not used if empty"})
+	serveEnvconf.Register(envconf.Item{Name: "SMTP_PASSWORD", Default: "", Description: "The SMTP password when MAILER is smtp"})
+	serveEnvconf.Register(envconf.Item{Name: "MAIL_FILE_DIRECTORY", Default: "tmp/mail", Description: "The directory email is written to when MAILER is file"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_ISSUER_URL", Default: "", Description: "The OpenID Connect issuer URL for single sign-on. Single sign-on is disabled if empty"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_CLIENT_ID", Default: "", Description: "The OpenID Connect client ID"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_CLIENT_SECRET", Default: "", Description: "The OpenID Connect client secret. The redirect URL is BASE_URL/login/oidc/callback"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_DISPLAY_NAME", Default: "single sign-on", Description: "The name of the identity provider shown on the login page"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_SCOPES", Default: "openid profile email", Description: "Space separated OpenID Connect scopes to request"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_USERNAME_CLAIM", Default: "preferred_username", Description: "The ID token claim used as the username of new users"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_EMAIL_CLAIM", Default: "email", Description: "The ID token claim that holds the user's email address"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_AUTO_PROVISION", Default: "false", Description: "Create a user the first time an unknown identity signs in. Requires REGISTRATION_ENABLED"})
+	serveEnvconf.Register(envconf.Item{Name: "OIDC_LINK_BY_EMAIL", Default: "false", Description: "Link an unknown identity to the user with the same verified email address. Only enable for an identity provider trusted to verify email addresses"})
+	serveEnvconf.Register(envconf.Item{Name: "PASSWORD_MIN_LENGTH", Default: "8", Description: "The minimum length of passwords chosen by users"})
+	serveEnvconf.Register(envconf.Item{Name: "BREACHED_PASSWORDS_FILE", Default: "", Description: "Path to a sorted file of SHA-1 hashes of breached passwords users may not choose. Not checked if empty"})
 	serveEnvconf.Register(envconf.Item{Name: "ASSET_MANIFEST", Default: "", Description: "Path to the asset manifest file"})
 
 	long := &strings.Builder{}
@@ -2,13 +2,18 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"os/user"
+	"strconv"
 
+	"github.com/jackc/envconf"
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/web-starter-app/db"
 	"github.com/rs/zerolog"
 )
 
@@ -61,3 +66,46 @@ func setupLogger(logFormat string) *zerolog.Logger {
 
 	return &logger
 }
+
+// registerArgon2Envconf registers the environment variables read by setupArgon2Params.
+func registerArgon2Envconf(config *envconf.Config) {
+	config.Register(envconf.Item{Name: "ARGON2_MEMORY_KIB", Default: strconv.FormatUint(uint64(db.DefaultArgon2Params.MemoryKiB), 10), Description: "Argon2id memory cost in KiB for new password digests"})
+	config.Register(envconf.Item{Name: "ARGON2_ITERATIONS", Default: strconv.FormatUint(uint64(db.DefaultArgon2Params.Iterations), 10), Description: "Argon2id iterations for new password digests"})
+	config.Register(envconf.Item{Name: "ARGON2_PARALLELISM", Default: strconv.FormatUint(uint64(db.DefaultArgon2Params.Parallelism), 10), Description: "Argon2id parallelism for new password digests"})
+}
+
+// setupArgon2Params configures the cost of new password digests. Existing digests with weaker parameters are upgraded
+// when the user next logs in.
+func setupArgon2Params(config *envconf.Config) {
+	parseUint := func(keyName string, bitSize int) uint64 {
+		n, err := strconv.ParseUint(config.Value(keyName), 10, bitSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s must be a positive integer.\n", keyName)
+			os.Exit(1)
+		}
+		return n
+	}
+
+	err := db.SetArgon2Params(db.Argon2Params{
+		MemoryKiB:   uint32(parseUint("ARGON2_MEMORY_KIB", 32)),
+		Iterations:  uint32(parseUint("ARGON2_ITERATIONS", 32)),
+		Parallelism: uint8(parseUint("ARGON2_PARALLELISM", 8)),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid Argon2 configuration: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// commandLineAuditActor returns the operating system user running the command as the actor of an audited change.
+func commandLineAuditActor() *db.AuditActor {
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	if username == "" {
+		username = "unknown"
+	}
+
+	return &db.AuditActor{Username: username}
+}
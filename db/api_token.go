@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+)
+
+// API token scopes. A walks:write token can also read walks.
+const (
+	APITokenScopeWalksRead  = "walks:read"
+	APITokenScopeWalksWrite = "walks:write"
+)
+
+// apiTokenPrefix identifies API tokens so they are recognizable in scripts and by secret scanners.
+const apiTokenPrefix = "wsa_"
+
+// apiTokenLastUsedTimeUpdateInterval is the minimum time between updates of an API token's last_used_time. This avoids
+// a database write on every request.
+const apiTokenLastUsedTimeUpdateInterval = time.Minute
+
+var ErrAPITokenInvalid = errors.New("API token is invalid")
+
+// CreateAPIToken creates a personal API token for userID and returns the token. Only a digest of the token is stored so
+// the token cannot be retrieved later.
+func CreateAPIToken(ctx context.Context, db pgxutil.DB, userID uuid.UUID, name, scope string) (string, error) {
+	token, _, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	token = apiTokenPrefix + token
+
+	err = pgxutil.InsertRow(ctx, db, "api_tokens", map[string]any{
+		"id":           uuid.Must(uuid.NewV7()),
+		"user_id":      userID,
+		"name":         name,
+		"scope":        scope,
+		"token_digest": digestSecretToken(token),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// APIToken is an API token that has been used to authenticate a request.
+type APIToken struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	Scope  string
+}
+
+// AuthenticateAPIToken returns the API token for token and records that it was used. It returns ErrAPITokenInvalid if
+// token does not exist or belongs to a suspended user.
+func AuthenticateAPIToken(ctx context.Context, db pgxutil.DB, token string) (*APIToken, error) {
+	var apiToken APIToken
+	var lastUsedTime *time.Time
+	err := db.QueryRow(
+		ctx,
+		`select api_tokens.id, api_tokens.user_id, api_tokens.scope, api_tokens.last_used_time
+from api_tokens
+	join users on api_tokens.user_id = users.id
+where api_tokens.token_digest = $1
+	and users.disabled_at is null`,
+		digestSecretToken(token),
+	).Scan(&apiToken.ID, &apiToken.UserID, &apiToken.Scope, &lastUsedTime)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPITokenInvalid
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if lastUsedTime == nil || now.Sub(*lastUsedTime) > apiTokenLastUsedTimeUpdateInterval {
+		_, err = db.Exec(ctx, "update api_tokens set last_used_time = $1 where id = $2", now, apiToken.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &apiToken, nil
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgxutil"
+)
+
+// Audit log actions.
+const (
+	AuditActionUserCreate        = "user.create"
+	AuditActionUserUpdate        = "user.update"
+	AuditActionUserDelete        = "user.delete"
+	AuditActionUserUnlock        = "user.unlock"
+	AuditActionUserSuspend       = "user.suspend"
+	AuditActionUserReactivate    = "user.reactivate"
+	AuditActionUserResetPassword = "user.reset_password"
+	AuditActionUserImpersonate   = "user.impersonate"
+	AuditActionRoleCreate        = "role.create"
+	AuditActionRoleUpdate        = "role.update"
+	AuditActionRoleDelete        = "role.delete"
+)
+
+// AuditActions are all audit log actions in the order they are shown to users.
+var AuditActions = []string{
+	AuditActionUserCreate,
+	AuditActionUserUpdate,
+	AuditActionUserDelete,
+	AuditActionUserUnlock,
+	AuditActionUserSuspend,
+	AuditActionUserReactivate,
+	AuditActionUserResetPassword,
+	AuditActionUserImpersonate,
+	AuditActionRoleCreate,
+	AuditActionRoleUpdate,
+	AuditActionRoleDelete,
+}
+
+// AuditActor is who made an audited change.
+type AuditActor struct {
+	// UserID is the acting user. It is uuid.Nil when the change was made from the command line.
+	UserID uuid.UUID
+
+	// Username is the acting user's username or the operating system user when the change was made from the command
+	// line.
+	Username string
+
+	RequestID string
+	ClientIP  string
+}
+
+// AuditChange is the value of a field before and after a change. Before is nil for a created record and After is nil
+// for a deleted record.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditChanges returns the fields that differ between before and after. Either may be nil.
+func AuditChanges(before, after map[string]any) map[string]AuditChange {
+	changes := make(map[string]AuditChange)
+	for field, beforeValue := range before {
+		afterValue := after[field]
+		if !reflect.DeepEqual(beforeValue, afterValue) {
+			changes[field] = AuditChange{Before: beforeValue, After: afterValue}
+		}
+	}
+	for field, afterValue := range after {
+		if _, ok := before[field]; !ok {
+			changes[field] = AuditChange{After: afterValue}
+		}
+	}
+
+	return changes
+}
+
+// InsertAuditLogEntry records that actor performed action on the user or role targetID named targetName. changes may be
+// nil.
+func InsertAuditLogEntry(ctx context.Context, db pgxutil.DB, actor *AuditActor, action string, targetID uuid.UUID, targetName string, changes map[string]AuditChange) error {
+	var actorUserID uuid.NullUUID
+	if actor.UserID != uuid.Nil {
+		actorUserID = uuid.NullUUID{UUID: actor.UserID, Valid: true}
+	}
+	var targetIDValue uuid.NullUUID
+	if targetID != uuid.Nil {
+		targetIDValue = uuid.NullUUID{UUID: targetID, Valid: true}
+	}
+	var changesValue any
+	if len(changes) > 0 {
+		changesValue = changes
+	}
+
+	return pgxutil.InsertRow(ctx, db, "audit_log_entries", map[string]any{
+		"id":             uuid.Must(uuid.NewV7()),
+		"action":         action,
+		"actor_user_id":  actorUserID,
+		"actor_username": actor.Username,
+		"target_id":      targetIDValue,
+		"target_name":    zeronull.Text(targetName),
+		"request_id":     zeronull.Text(actor.RequestID),
+		"client_ip":      zeronull.Text(actor.ClientIP),
+		"changes":        changesValue,
+	})
+}
+
+// AuditUserFields returns the audited fields of userID. It is used to compute the changes made by an update.
+func AuditUserFields(ctx context.Context, db pgxutil.DB, userID uuid.UUID) (map[string]any, error) {
+	var username, email string
+	var twoFactorRequired bool
+	var roles []string
+	err := db.QueryRow(
+		ctx,
+		`select username, coalesce(email, ''), two_factor_required,
+	array(
+		select roles.name
+		from user_roles
+			join roles on user_roles.role_id = roles.id
+		where user_roles.user_id = users.id
+		order by roles.name
+	)
+from users
+where id = $1`,
+		userID,
+	).Scan(&username, &email, &twoFactorRequired, &roles)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"username":            username,
+		"email":               email,
+		"two_factor_required": twoFactorRequired,
+		"roles":               roles,
+	}, nil
+}
+
+// AuditRoleFields returns the audited fields of roleID. It is used to compute the changes made by an update.
+func AuditRoleFields(ctx context.Context, db pgxutil.DB, roleID uuid.UUID) (map[string]any, error) {
+	var name string
+	var permissions []string
+	err := db.QueryRow(
+		ctx,
+		"select name, array(select permission from role_permissions where role_id = roles.id order by permission) from roles where id = $1",
+		roleID,
+	).Scan(&name, &permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"name":        name,
+		"permissions": permissions,
+	}, nil
+}
+
+// AuditLogEntry is a recorded administrative change.
+type AuditLogEntry struct {
+	ID            uuid.UUID
+	Time          time.Time
+	Action        string
+	ActorUserID   uuid.NullUUID
+	ActorUsername string
+	TargetID      uuid.NullUUID
+	TargetName    zeronull.Text
+	RequestID     zeronull.Text
+	ClientIP      zeronull.Text
+	Changes       map[string]AuditChange
+}
+
+// AuditLogFilter selects audit log entries. Zero fields do not filter.
+type AuditLogFilter struct {
+	Action string
+
+	// Actor and Target match the actor's username and the target's name case-insensitively.
+	Actor  string
+	Target string
+
+	// Since is inclusive and Until is exclusive.
+	Since time.Time
+	Until time.Time
+}
+
+// SelectAuditLogEntries returns the audit log entries that match filter, newest first. At most limit entries are returned
+// starting at offset. There is no limit if limit is 0.
+func SelectAuditLogEntries(ctx context.Context, db pgxutil.DB, filter *AuditLogFilter, limit, offset int) ([]*AuditLogEntry, error) {
+	var conditions []string
+	var args []any
+	addCondition := func(format string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.Actor != "" {
+		addCondition("lower(actor_username) = lower($%d)", filter.Actor)
+	}
+	if filter.Target != "" {
+		addCondition("lower(target_name) = lower($%d)", filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("time >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("time < $%d", filter.Until)
+	}
+
+	sql := &strings.Builder{}
+	sql.WriteString("select id, time, action, actor_user_id, actor_username, target_id, target_name, request_id, client_ip, changes\nfrom audit_log_entries\n")
+	if len(conditions) > 0 {
+		sql.WriteString("where ")
+		sql.WriteString(strings.Join(conditions, "\n\tand "))
+		sql.WriteString("\n")
+	}
+	sql.WriteString("order by time desc, id desc")
+	if limit > 0 {
+		args = append(args, limit, offset)
+		fmt.Fprintf(sql, "\nlimit $%d offset $%d", len(args)-1, len(args))
+	}
+
+	return pgxutil.Select(ctx, db, sql.String(), args, pgx.RowToAddrOfStructByPos[AuditLogEntry])
+}
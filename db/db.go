@@ -6,6 +6,7 @@ import (
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
 	"github.com/jackc/pgxutil"
 )
 
@@ -23,10 +24,40 @@ func GetCurrentTime(ctx context.Context, db pgxutil.DB) (time.Time, error) {
 type User struct {
 	ID       uuid.UUID
 	Username string
+	Email    zeronull.Text
+
+	// Disabled is true if the user has been suspended.
+	Disabled bool
 }
 
 func GetUserByUsername(ctx context.Context, db pgxutil.DB, username string) (*User, error) {
-	user, err := pgxutil.SelectRow(ctx, db, "select id, username from users where username = $1", []any{username}, pgx.RowToAddrOfStructByPos[User])
+	user, err := pgxutil.SelectRow(ctx, db, "select id, username, email, disabled_at is not null from users where username = $1", []any{username}, pgx.RowToAddrOfStructByPos[User])
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func GetUserByID(ctx context.Context, db pgxutil.DB, userID uuid.UUID) (*User, error) {
+	user, err := pgxutil.SelectRow(ctx, db, "select id, username, email, disabled_at is not null from users where id = $1", []any{userID}, pgx.RowToAddrOfStructByPos[User])
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUserByVerifiedEmail returns the user with email. Email addresses are compared case-insensitively. Users that have
+// not verified email are not found.
+func GetUserByVerifiedEmail(ctx context.Context, db pgxutil.DB, email string) (*User, error) {
+	user, err := pgxutil.SelectRow(
+		ctx,
+		db,
+		"select id, username, email, disabled_at is not null from users where lower(email) = lower($1) and email_verified_time is not null",
+		[]any{email},
+		pgx.RowToAddrOfStructByPos[User],
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+)
+
+const emailVerificationTokenLifetime = 24 * time.Hour
+
+var ErrEmailVerificationTokenInvalid = errors.New("email verification token is invalid or expired")
+
+// CreateEmailVerificationToken returns a new single use token that verifies the user owns email. Only a digest of the
+// token is stored.
+func CreateEmailVerificationToken(ctx context.Context, db pgxutil.DB, userID uuid.UUID, email string) (string, error) {
+	token, tokenDigest, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = pgxutil.InsertRow(ctx, db, "email_verification_tokens", map[string]any{
+		"id":              uuid.Must(uuid.NewV7()),
+		"user_id":         userID,
+		"email":           email,
+		"token_digest":    tokenDigest,
+		"expiration_time": time.Now().Add(emailVerificationTokenLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyUserEmailWithToken marks the email address token was created for as verified and consumes token. It returns
+// ErrEmailVerificationTokenInvalid if token does not exist, has been used, has expired, the user's email address has
+// changed since token was created, or another user has verified the email address.
+func VerifyUserEmailWithToken(ctx context.Context, db pgxutil.DB, token string) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var tokenID, userID uuid.UUID
+		var email string
+		err := tx.QueryRow(
+			ctx,
+			`select id, user_id, email
+from email_verification_tokens
+where token_digest = $1
+	and used_time is null
+	and expiration_time > now()
+for update`,
+			digestSecretToken(token),
+		).Scan(&tokenID, &userID, &email)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrEmailVerificationTokenInvalid
+			}
+			return err
+		}
+
+		// Another user may have verified the address since token was created.
+		commandTag, err := tx.Exec(
+			ctx,
+			`update users
+set email_verified_time = now()
+where id = $1
+	and email = $2
+	and not exists (
+		select 1 from users others where lower(others.email) = lower($2) and others.email_verified_time is not null
+	)`,
+			userID, email,
+		)
+		if err != nil {
+			return err
+		}
+		if commandTag.RowsAffected() == 0 {
+			return ErrEmailVerificationTokenInvalid
+		}
+
+		_, err = tx.Exec(ctx, "update email_verification_tokens set used_time = now() where id = $1", tokenID)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgxutil"
+)
+
+var ErrImpersonationNotAllowed = errors.New("user cannot be impersonated")
+
+// StartImpersonationArgs are the arguments to StartImpersonation.
+type StartImpersonationArgs struct {
+	// ImpersonatorLoginSessionID is the impersonator's own login session.
+	ImpersonatorLoginSessionID uuid.UUID
+	ImpersonatedUserID         uuid.UUID
+	UserAgent                  string
+	LoginRequestID             string
+	ClientIP                   string
+}
+
+// StartImpersonation creates a login session for the impersonated user that is linked to the impersonator's login
+// session and records the impersonation in the audit trail and the audit log. It returns the new login session's ID.
+// The impersonator must have PermissionUsersImpersonate. Users with any role cannot be impersonated as that would let
+// the impersonator use permissions they were not granted. Suspended users cannot be impersonated and users cannot
+// impersonate themselves. ErrImpersonationNotAllowed is returned if the impersonation is not allowed.
+func StartImpersonation(ctx context.Context, db pgxutil.DB, args StartImpersonationArgs) (uuid.UUID, error) {
+	var loginSessionID uuid.UUID
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var impersonatorUserID uuid.UUID
+		var impersonatorUsername string
+		err := tx.QueryRow(
+			ctx,
+			`select users.id, users.username
+from login_sessions
+	join users on login_sessions.user_id = users.id
+where login_sessions.id = $1
+	and login_sessions.impersonator_login_session_id is null`,
+			args.ImpersonatorLoginSessionID,
+		).Scan(&impersonatorUserID, &impersonatorUsername)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrImpersonationNotAllowed
+			}
+			return err
+		}
+
+		var canImpersonate bool
+		err = tx.QueryRow(ctx, "select "+userHasPermissionSQL, impersonatorUserID, PermissionUsersImpersonate).Scan(&canImpersonate)
+		if err != nil {
+			return err
+		}
+		if !canImpersonate {
+			return ErrImpersonationNotAllowed
+		}
+
+		var impersonatedUsername string
+		var impersonatedHasRoles, impersonatedDisabled bool
+		err = tx.QueryRow(
+			ctx,
+			"select username, exists (select 1 from user_roles where user_id = users.id), disabled_at is not null from users where id = $1",
+			args.ImpersonatedUserID,
+		).Scan(&impersonatedUsername, &impersonatedHasRoles, &impersonatedDisabled)
+		if err != nil {
+			return err
+		}
+		if impersonatedHasRoles || impersonatedDisabled || args.ImpersonatedUserID == impersonatorUserID {
+			return ErrImpersonationNotAllowed
+		}
+
+		now := time.Now()
+		loginSessionID = uuid.Must(uuid.NewV7())
+		err = pgxutil.InsertRow(ctx, tx, "login_sessions", map[string]any{
+			"id":                            loginSessionID,
+			"user_id":                       args.ImpersonatedUserID,
+			"user_agent":                    zeronull.Text(args.UserAgent),
+			"login_time":                    now,
+			"login_request_id":              args.LoginRequestID,
+			"approximate_last_request_time": now,
+			"impersonator_login_session_id": args.ImpersonatorLoginSessionID,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = pgxutil.InsertRow(ctx, tx, "impersonations", map[string]any{
+			"id":                    uuid.Must(uuid.NewV7()),
+			"impersonator_user_id":  impersonatorUserID,
+			"impersonator_username": impersonatorUsername,
+			"impersonated_user_id":  args.ImpersonatedUserID,
+			"impersonated_username": impersonatedUsername,
+			"login_session_id":      loginSessionID,
+			"client_ip":             args.ClientIP,
+			"start_time":            now,
+		})
+		if err != nil {
+			return err
+		}
+
+		actor := &AuditActor{
+			UserID:    impersonatorUserID,
+			Username:  impersonatorUsername,
+			RequestID: args.LoginRequestID,
+			ClientIP:  args.ClientIP,
+		}
+		return InsertAuditLogEntry(ctx, tx, actor, AuditActionUserImpersonate, args.ImpersonatedUserID, impersonatedUsername, nil)
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return loginSessionID, nil
+}
+
+// StopImpersonation deletes the impersonation login session loginSessionID and records the end of the impersonation in
+// the audit trail. It returns the ID of the impersonator's own login session.
+func StopImpersonation(ctx context.Context, db pgxutil.DB, loginSessionID uuid.UUID) (uuid.UUID, error) {
+	var impersonatorLoginSessionID uuid.UUID
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(
+			ctx,
+			"delete from login_sessions where id = $1 and impersonator_login_session_id is not null returning impersonator_login_session_id",
+			loginSessionID,
+		).Scan(&impersonatorLoginSessionID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "update impersonations set end_time = now() where login_session_id = $1 and end_time is null", loginSessionID)
+		return err
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return impersonatorLoginSessionID, nil
+}
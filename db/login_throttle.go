@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+)
+
+const (
+	loginThrottleKindUsername = "username"
+	loginThrottleKindIP       = "ip"
+
+	// loginThrottleResetAfter is how long after the last failure the failure count starts over.
+	loginThrottleResetAfter = 24 * time.Hour
+
+	// loginThrottleMaxLockout caps the exponential backoff.
+	loginThrottleMaxLockout = 15 * time.Minute
+)
+
+// loginThrottleFreeFailures is the number of failures allowed before login attempts are delayed. Clients behind a
+// shared IP address such as an office NAT may legitimately produce more failures so IP addresses are allowed more.
+var loginThrottleFreeFailures = map[string]int32{
+	loginThrottleKindUsername: 5,
+	loginThrottleKindIP:       20,
+}
+
+// loginThrottleLockout returns how long login attempts are refused after failureCount consecutive failures. Each
+// failure past the free failures doubles the lockout up to loginThrottleMaxLockout.
+func loginThrottleLockout(kind string, failureCount int32) time.Duration {
+	excess := failureCount - loginThrottleFreeFailures[kind]
+	if excess <= 0 {
+		return 0
+	}
+
+	// Avoid overflow. 2^10 seconds already exceeds loginThrottleMaxLockout.
+	if excess > 10 {
+		return loginThrottleMaxLockout
+	}
+
+	return min(time.Second<<(excess-1), loginThrottleMaxLockout)
+}
+
+// GetLoginLockedUntil returns the time until which login attempts for username or from clientIP are refused. It
+// returns the zero time if login attempts are allowed.
+func GetLoginLockedUntil(ctx context.Context, db pgxutil.DB, username, clientIP string) (time.Time, error) {
+	var lockedUntil *time.Time
+	err := db.QueryRow(
+		ctx,
+		`select max(locked_until)
+from login_throttles
+where ((kind = $1 and key = $2) or (kind = $3 and key = $4))
+	and locked_until > now()`,
+		loginThrottleKindUsername, username, loginThrottleKindIP, clientIP,
+	).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+
+	return *lockedUntil, nil
+}
+
+// GetClientIPLoginLockedUntil returns the time until which login attempts from clientIP are refused. It returns the zero
+// time if login attempts are allowed. Locks on usernames are not considered.
+func GetClientIPLoginLockedUntil(ctx context.Context, db pgxutil.DB, clientIP string) (time.Time, error) {
+	var lockedUntil *time.Time
+	err := db.QueryRow(
+		ctx,
+		"select max(locked_until) from login_throttles where kind = $1 and key = $2 and locked_until > now()",
+		loginThrottleKindIP, clientIP,
+	).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+
+	return *lockedUntil, nil
+}
+
+// GetUserLoginLockedUntil returns the time until which login attempts for the user are refused. It returns the zero time
+// if the user is not locked. Locks on IP addresses are not considered.
+func GetUserLoginLockedUntil(ctx context.Context, db pgxutil.DB, userID uuid.UUID) (time.Time, error) {
+	var lockedUntil *time.Time
+	err := db.QueryRow(
+		ctx,
+		`select max(locked_until)
+from login_throttles
+where kind = $1
+	and key = (select username from users where id = $2)
+	and locked_until > now()`,
+		loginThrottleKindUsername, userID,
+	).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+
+	return *lockedUntil, nil
+}
+
+// RecordFailedLogin records a failed login attempt for username from clientIP.
+func RecordFailedLogin(ctx context.Context, db pgxutil.DB, username, clientIP string) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		for _, kk := range [][2]string{{loginThrottleKindUsername, username}, {loginThrottleKindIP, clientIP}} {
+			kind, key := kk[0], kk[1]
+
+			var failureCount int32
+			err := tx.QueryRow(
+				ctx,
+				`insert into login_throttles (kind, key, failure_count, last_failure_time)
+values ($1, $2, 1, now())
+on conflict (kind, key) do update
+set failure_count = case when login_throttles.last_failure_time < now() - $3::interval then 1 else login_throttles.failure_count + 1 end,
+	last_failure_time = excluded.last_failure_time
+returning failure_count`,
+				kind, key, loginThrottleResetAfter,
+			).Scan(&failureCount)
+			if err != nil {
+				return err
+			}
+
+			if lockout := loginThrottleLockout(kind, failureCount); lockout > 0 {
+				_, err = tx.Exec(ctx, "update login_throttles set locked_until = now() + $1::interval where kind = $2 and key = $3", lockout, kind, key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// ResetUserLoginThrottle clears the failed login attempts for the user. It is called after a successful login and when
+// an administrator unlocks an account. Failures from IP addresses are not reset as an attacker could otherwise reset
+// their own IP address's failures by logging in to an account they control.
+func ResetUserLoginThrottle(ctx context.Context, db pgxutil.DB, userID uuid.UUID) error {
+	_, err := db.Exec(
+		ctx,
+		"delete from login_throttles where kind = $1 and key = (select username from users where id = $2)",
+		loginThrottleKindUsername, userID,
+	)
+	return err
+}
+
+// Password reset requests are counted in login_throttles with their own kinds. Each request sends an email so the
+// email address and the IP address are limited to prevent flooding a mailbox.
+const (
+	passwordResetThrottleKindEmail = "password_reset_email"
+	passwordResetThrottleKindIP    = "password_reset_ip"
+
+	// passwordResetThrottleWindow is how long after the last request the request count starts over.
+	passwordResetThrottleWindow = time.Hour
+)
+
+var passwordResetThrottleLimits = map[string]int32{
+	passwordResetThrottleKindEmail: 3,
+	passwordResetThrottleKindIP:    20,
+}
+
+// RecordPasswordResetRequest records a password reset request for email from clientIP. It returns false if too many
+// requests have been made for email or from clientIP. Requests are counted whether or not email belongs to a user so
+// the result does not reveal which email addresses have accounts.
+func RecordPasswordResetRequest(ctx context.Context, db pgxutil.DB, email, clientIP string) (bool, error) {
+	allowed := true
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		for _, kk := range [][2]string{{passwordResetThrottleKindEmail, strings.ToLower(email)}, {passwordResetThrottleKindIP, clientIP}} {
+			kind, key := kk[0], kk[1]
+
+			var requestCount int32
+			err := tx.QueryRow(
+				ctx,
+				`insert into login_throttles (kind, key, failure_count, last_failure_time)
+values ($1, $2, 1, now())
+on conflict (kind, key) do update
+set failure_count = case when login_throttles.last_failure_time < now() - $3::interval then 1 else login_throttles.failure_count + 1 end,
+	last_failure_time = excluded.last_failure_time
+returning failure_count`,
+				kind, key, passwordResetThrottleWindow,
+			).Scan(&requestCount)
+			if err != nil {
+				return err
+			}
+
+			if requestCount > passwordResetThrottleLimits[kind] {
+				allowed = false
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return allowed, nil
+}
@@ -0,0 +1,302 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+)
+
+// Organization membership roles. Owners can invite and remove members.
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleMember = "member"
+)
+
+const organizationInvitationLifetime = 7 * 24 * time.Hour
+
+var ErrOrganizationInvitationInvalid = errors.New("organization invitation is invalid or expired")
+
+// ErrLastOrganizationOwner is returned when a change would leave an organization without an owner.
+var ErrLastOrganizationOwner = errors.New("an organization must have an owner")
+
+// CreateOrganization creates an organization owned by ownerID and makes it ownerID's current organization.
+func CreateOrganization(ctx context.Context, db pgxutil.DB, name string, ownerID uuid.UUID) (uuid.UUID, error) {
+	organizationID := uuid.Must(uuid.NewV7())
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := pgxutil.InsertRow(ctx, tx, "organizations", map[string]any{
+			"id":   organizationID,
+			"name": name,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = pgxutil.InsertRow(ctx, tx, "organization_memberships", map[string]any{
+			"organization_id": organizationID,
+			"user_id":         ownerID,
+			"role":            OrganizationRoleOwner,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = pgxutil.ExecRow(ctx, tx, "update users set current_organization_id = $1 where id = $2", organizationID, ownerID)
+		return err
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return organizationID, nil
+}
+
+// GetOrganizationRole returns userID's role in organizationID. It returns pgx.ErrNoRows if userID is not a member.
+func GetOrganizationRole(ctx context.Context, db pgxutil.DB, organizationID, userID uuid.UUID) (string, error) {
+	return pgxutil.SelectRow(ctx, db,
+		"select role from organization_memberships where organization_id = $1 and user_id = $2",
+		[]any{organizationID, userID},
+		pgx.RowTo[string],
+	)
+}
+
+// SetCurrentOrganization sets the organization userID is working with. organizationID is invalid for a personal walk
+// log. It returns pgx.ErrNoRows if userID is not a member of organizationID.
+func SetCurrentOrganization(ctx context.Context, db pgxutil.DB, userID uuid.UUID, organizationID uuid.NullUUID) error {
+	_, err := pgxutil.ExecRow(ctx, db,
+		`update users
+set current_organization_id = $2
+where id = $1
+	and ($2::uuid is null or exists (select 1 from organization_memberships where organization_id = $2 and user_id = $1))`,
+		userID, organizationID,
+	)
+	return err
+}
+
+// CreateOrganizationInvitation returns a new token that allows any user to join organizationID until it expires. Only a
+// digest of the token is stored so the plaintext token cannot be retrieved later.
+func CreateOrganizationInvitation(ctx context.Context, db pgxutil.DB, organizationID uuid.UUID) (string, error) {
+	token, tokenDigest, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = pgxutil.InsertRow(ctx, db, "organization_invitations", map[string]any{
+		"id":              uuid.Must(uuid.NewV7()),
+		"organization_id": organizationID,
+		"token_digest":    tokenDigest,
+		"expiration_time": time.Now().Add(organizationInvitationLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// OrganizationInvitation is an invitation to join an organization.
+type OrganizationInvitation struct {
+	OrganizationID   uuid.UUID
+	OrganizationName string
+}
+
+// GetOrganizationInvitation returns the invitation for token. It returns ErrOrganizationInvitationInvalid if token does
+// not exist, has been revoked or has expired.
+func GetOrganizationInvitation(ctx context.Context, db pgxutil.DB, token string) (*OrganizationInvitation, error) {
+	invitation, err := pgxutil.SelectRow(
+		ctx,
+		db,
+		`select organizations.id, organizations.name
+from organization_invitations
+	join organizations on organization_invitations.organization_id = organizations.id
+where organization_invitations.token_digest = $1
+	and organization_invitations.expiration_time > now()`,
+		[]any{digestSecretToken(token)},
+		pgx.RowToAddrOfStructByPos[OrganizationInvitation],
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOrganizationInvitationInvalid
+		}
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// AcceptOrganizationInvitation makes userID a member of the organization token invites to and makes it userID's current
+// organization. Accepting an invitation to an organization userID is already a member of does not change their role.
+// It returns ErrOrganizationInvitationInvalid if token does not exist, has been revoked or has expired.
+func AcceptOrganizationInvitation(ctx context.Context, db pgxutil.DB, token string, userID uuid.UUID) (uuid.UUID, error) {
+	var organizationID uuid.UUID
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		invitation, err := GetOrganizationInvitation(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+		organizationID = invitation.OrganizationID
+
+		_, err = tx.Exec(
+			ctx,
+			`insert into organization_memberships (organization_id, user_id, role)
+values ($1, $2, $3)
+on conflict do nothing`,
+			organizationID, userID, OrganizationRoleMember,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = pgxutil.ExecRow(ctx, tx, "update users set current_organization_id = $1 where id = $2", organizationID, userID)
+		return err
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return organizationID, nil
+}
+
+// lockOrganizationMember locks organizationID so concurrent changes cannot each see another owner. It returns userID's
+// role and whether organizationID has an owner other than userID. It returns pgx.ErrNoRows if userID is not a member.
+func lockOrganizationMember(ctx context.Context, tx pgx.Tx, organizationID, userID uuid.UUID) (role string, otherOwnerExists bool, err error) {
+	_, err = pgxutil.ExecRow(ctx, tx, "select 1 from organizations where id = $1 for update", organizationID)
+	if err != nil {
+		return "", false, err
+	}
+
+	err = tx.QueryRow(
+		ctx,
+		`select role, exists (
+	select 1
+	from organization_memberships
+	where organization_id = $1
+		and user_id <> $2
+		and role = $3
+)
+from organization_memberships
+where organization_id = $1
+	and user_id = $2`,
+		organizationID, userID, OrganizationRoleOwner,
+	).Scan(&role, &otherOwnerExists)
+	if err != nil {
+		return "", false, err
+	}
+
+	return role, otherOwnerExists, nil
+}
+
+// SetOrganizationMemberRole changes userID's role in organizationID. Ownership is transferred by making another member
+// an owner and then changing the previous owner's role. It returns ErrLastOrganizationOwner if userID is the only owner
+// of organizationID and role is not OrganizationRoleOwner. It returns pgx.ErrNoRows if userID is not a member.
+func SetOrganizationMemberRole(ctx context.Context, db pgxutil.DB, organizationID, userID uuid.UUID, role string) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		currentRole, otherOwnerExists, err := lockOrganizationMember(ctx, tx, organizationID, userID)
+		if err != nil {
+			return err
+		}
+		if currentRole == OrganizationRoleOwner && role != OrganizationRoleOwner && !otherOwnerExists {
+			return ErrLastOrganizationOwner
+		}
+
+		_, err = pgxutil.ExecRow(ctx, tx,
+			"update organization_memberships set role = $3 where organization_id = $1 and user_id = $2",
+			organizationID, userID, role,
+		)
+		return err
+	})
+}
+
+// RemoveOrganizationMember removes userID from organizationID. userID's walks are no longer shared with the
+// organization. It returns ErrLastOrganizationOwner if userID is the only owner of organizationID.
+func RemoveOrganizationMember(ctx context.Context, db pgxutil.DB, organizationID, userID uuid.UUID) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		role, otherOwnerExists, err := lockOrganizationMember(ctx, tx, organizationID, userID)
+		if err != nil {
+			return err
+		}
+		if role == OrganizationRoleOwner && !otherOwnerExists {
+			return ErrLastOrganizationOwner
+		}
+
+		_, err = tx.Exec(ctx, "delete from organization_memberships where organization_id = $1 and user_id = $2", organizationID, userID)
+		if err != nil {
+			return err
+		}
+
+		// An owner may remove another member so their walks are not otherwise visible.
+		err = BypassRowSecurity(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "update walks set organization_id = null where organization_id = $1 and user_id = $2", organizationID, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "update users set current_organization_id = null where id = $1 and current_organization_id = $2", userID, organizationID)
+		return err
+	})
+}
+
+// DeleteOrganization deletes organizationID along with its memberships and invitations. Walks that were shared with
+// the organization become personal walks of the members that logged them.
+func DeleteOrganization(ctx context.Context, db pgxutil.DB, organizationID uuid.UUID) error {
+	// The foreign keys from walks and users set organization_id and current_organization_id to null. Referential
+	// actions are not subject to row-level security.
+	_, err := pgxutil.ExecRow(ctx, db, "delete from organizations where id = $1", organizationID)
+	return err
+}
+
+// leaveAllOrganizations removes userID from every organization before userID is deleted. The longest standing member
+// of an organization that would be left without an owner becomes its owner. Organizations left without any members are
+// deleted.
+func leaveAllOrganizations(ctx context.Context, tx pgx.Tx, userID uuid.UUID) error {
+	_, err := tx.Exec(
+		ctx,
+		`update organization_memberships
+set role = $2
+where (organization_id, user_id) in (
+	select distinct on (candidates.organization_id) candidates.organization_id, candidates.user_id
+	from organization_memberships candidates
+	where candidates.user_id <> $1
+		and candidates.organization_id in (
+			select organization_id from organization_memberships where user_id = $1 and role = $2
+		)
+		and not exists (
+			select 1
+			from organization_memberships owners
+			where owners.organization_id = candidates.organization_id
+				and owners.user_id <> $1
+				and owners.role = $2
+		)
+	order by candidates.organization_id, candidates.insert_time
+)`,
+		userID, OrganizationRoleOwner,
+	)
+	if err != nil {
+		return err
+	}
+
+	organizationIDs, err := pgxutil.Select(ctx, tx,
+		"delete from organization_memberships where user_id = $1 returning organization_id",
+		[]any{userID},
+		pgx.RowTo[uuid.UUID],
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`delete from organizations
+where id = any($1)
+	and not exists (select 1 from organization_memberships where organization_id = organizations.id)`,
+		organizationIDs,
+	)
+	return err
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgxutil"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrPasswordHashUnsupported = errors.New("password hash format is not supported")
+
+// Imported hashes are verified on login with the cost parameters stored in the hash. A corrupt or malicious hash with
+// an extreme cost could use enough memory or time to take down the server so hashes above these limits are rejected.
+// They are well above what any reasonable system uses.
+const (
+	maxImportedBcryptCost       = 16
+	maxImportedScryptLogN       = 20
+	maxImportedScryptR          = 32
+	maxImportedParallelism      = 16
+	maxImportedMemoryKiB        = 1024 * 1024
+	maxImportedArgon2Iterations = 10
+)
+
+// ImportUserPasswordHash sets the password for a user from a hash exported by another system. The user can log in with
+// the original password and the hash is upgraded to the current Argon2id parameters on their first successful login.
+//
+// The following formats are supported:
+//
+//	bcrypt:   $2a$, $2b$ or $2y$ modular crypt strings
+//	scrypt:   $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>
+//	Argon2id: $argon2id$v=19$m=<memory KiB>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+//
+// The scrypt and Argon2id formats are the PHC string format with unpadded base64 salt and hash.
+func ImportUserPasswordHash(ctx context.Context, db pgxutil.DB, userID uuid.UUID, hash string) error {
+	pd, err := parsePasswordHash(hash)
+	if err != nil {
+		return err
+	}
+
+	return setUserPasswordDigest(ctx, db, userID, pd, zeronull.Timestamptz{})
+}
+
+func parsePasswordHash(hash string) (*passwordDigest, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPasswordHashUnsupported, err)
+		}
+		if cost > maxImportedBcryptCost {
+			return nil, ErrPasswordHashUnsupported
+		}
+
+		return &passwordDigest{
+			Algorithm:  passwordAlgorithmBcrypt,
+			Salt:       []byte{},
+			Iterations: uint32(cost),
+			Digest:     []byte(hash),
+		}, nil
+
+	case strings.HasPrefix(hash, "$scrypt$"):
+		// "", "scrypt", params, salt, hash
+		parts := strings.Split(hash, "$")
+		if len(parts) != 5 {
+			return nil, ErrPasswordHashUnsupported
+		}
+
+		params, err := parsePHCParams(parts[2], "ln", "r", "p")
+		if err != nil {
+			return nil, err
+		}
+		if params["ln"] > maxImportedScryptLogN || params["r"] > maxImportedScryptR || params["p"] > maxImportedParallelism {
+			return nil, ErrPasswordHashUnsupported
+		}
+		// scrypt uses 128 * N * r bytes of memory.
+		if (int64(1)<<params["ln"])*params["r"]/8 > maxImportedMemoryKiB {
+			return nil, ErrPasswordHashUnsupported
+		}
+
+		salt, digest, err := decodePHCSaltAndHash(parts[3], parts[4])
+		if err != nil {
+			return nil, err
+		}
+
+		return &passwordDigest{
+			Algorithm:   passwordAlgorithmScrypt,
+			Salt:        salt,
+			MinMemory:   uint32(params["r"]),
+			Iterations:  uint32(params["ln"]),
+			Parallelism: uint8(params["p"]),
+			Digest:      digest,
+		}, nil
+
+	case strings.HasPrefix(hash, "$argon2id$"):
+		// "", "argon2id", version, params, salt, hash
+		parts := strings.Split(hash, "$")
+		if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+			return nil, ErrPasswordHashUnsupported
+		}
+
+		params, err := parsePHCParams(parts[3], "m", "t", "p")
+		if err != nil {
+			return nil, err
+		}
+		if params["m"] > maxImportedMemoryKiB || params["t"] > maxImportedArgon2Iterations || params["p"] > maxImportedParallelism {
+			return nil, ErrPasswordHashUnsupported
+		}
+
+		salt, digest, err := decodePHCSaltAndHash(parts[4], parts[5])
+		if err != nil {
+			return nil, err
+		}
+
+		return &passwordDigest{
+			Algorithm:   passwordAlgorithmArgon2id,
+			Salt:        salt,
+			MinMemory:   uint32(params["m"]),
+			Iterations:  uint32(params["t"]),
+			Parallelism: uint8(params["p"]),
+			Digest:      digest,
+		}, nil
+
+	default:
+		return nil, ErrPasswordHashUnsupported
+	}
+}
+
+// parsePHCParams parses a comma separated list of name=value parameters. Every name in names must be present with a
+// positive integer value.
+func parsePHCParams(s string, names ...string) (map[string]int64, error) {
+	params := make(map[string]int64, len(names))
+	for _, param := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, ErrPasswordHashUnsupported
+		}
+
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || n < 1 {
+			return nil, ErrPasswordHashUnsupported
+		}
+		params[name] = n
+	}
+
+	for _, name := range names {
+		if _, ok := params[name]; !ok {
+			return nil, ErrPasswordHashUnsupported
+		}
+	}
+
+	return params, nil
+}
+
+// decodePHCSaltAndHash decodes the salt and hash fields of a PHC string. Some implementations such as passlib use '.'
+// instead of '+' so both are accepted.
+func decodePHCSaltAndHash(encodedSalt, encodedHash string) ([]byte, []byte, error) {
+	decode := func(s string) ([]byte, error) {
+		return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(strings.TrimRight(s, "="), ".", "+"))
+	}
+
+	salt, err := decode(encodedSalt)
+	if err != nil {
+		return nil, nil, ErrPasswordHashUnsupported
+	}
+
+	digest, err := decode(encodedHash)
+	if err != nil || len(digest) == 0 {
+		return nil, nil, ErrPasswordHashUnsupported
+	}
+
+	return salt, digest, nil
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+)
+
+const passwordResetTokenLifetime = time.Hour
+
+var ErrPasswordResetTokenInvalid = errors.New("password reset token is invalid or expired")
+
+// CreatePasswordResetToken returns a new single use token that allows setting the user's password without knowing the
+// current password. Only a digest of the token is stored so the plaintext token cannot be retrieved later.
+func CreatePasswordResetToken(ctx context.Context, db pgxutil.DB, userID uuid.UUID) (string, error) {
+	token, tokenDigest, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = pgxutil.InsertRow(ctx, db, "password_reset_tokens", map[string]any{
+		"id":              uuid.Must(uuid.NewV7()),
+		"user_id":         userID,
+		"token_digest":    tokenDigest,
+		"expiration_time": time.Now().Add(passwordResetTokenLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetPasswordResetTokenUser returns the user that token resets the password for. It returns
+// ErrPasswordResetTokenInvalid if token does not exist, has been used or has expired.
+func GetPasswordResetTokenUser(ctx context.Context, db pgxutil.DB, token string) (*User, error) {
+	user, err := pgxutil.SelectRow(
+		ctx,
+		db,
+		`select users.id, users.username, users.email, users.disabled_at is not null
+from password_reset_tokens
+	join users on password_reset_tokens.user_id = users.id
+where password_reset_tokens.token_digest = $1
+	and password_reset_tokens.used_time is null
+	and password_reset_tokens.expiration_time > now()`,
+		[]any{digestSecretToken(token)},
+		pgx.RowToAddrOfStructByPos[User],
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPasswordResetTokenInvalid
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ResetUserPasswordWithToken sets the password for the user token was created for and consumes token. Any other
+// outstanding tokens for the user, all of the user's login sessions and any login lockout are deleted in the same
+// transaction. It returns ErrPasswordResetTokenInvalid if token does not exist, has been used or has expired.
+func ResetUserPasswordWithToken(ctx context.Context, db pgxutil.DB, token string, password string) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var tokenID, userID uuid.UUID
+		err := tx.QueryRow(
+			ctx,
+			`select id, user_id
+from password_reset_tokens
+where token_digest = $1
+	and used_time is null
+	and expiration_time > now()
+for update`,
+			digestSecretToken(token),
+		).Scan(&tokenID, &userID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrPasswordResetTokenInvalid
+			}
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "update password_reset_tokens set used_time = now() where id = $1", tokenID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "delete from password_reset_tokens where user_id = $1 and used_time is null", userID)
+		if err != nil {
+			return err
+		}
+
+		err = ChangeUserPassword(ctx, tx, userID, password, uuid.Nil)
+		if err != nil {
+			return err
+		}
+
+		return ResetUserLoginThrottle(ctx, tx, userID)
+	})
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+)
+
+// Permissions that can be granted to a role.
+const (
+	PermissionUsersManage      = "users.manage"
+	PermissionUsersImpersonate = "users.impersonate"
+	PermissionRolesManage      = "roles.manage"
+	PermissionAuditRead        = "audit.read"
+)
+
+// Permissions are all permissions in the order they are shown to users.
+var Permissions = []string{
+	PermissionUsersManage,
+	PermissionUsersImpersonate,
+	PermissionRolesManage,
+	PermissionAuditRead,
+}
+
+// userHasPermissionSQL is a SQL expression that is true when the user with ID $1 has the permission $2.
+const userHasPermissionSQL = `exists (
+	select 1
+	from user_roles
+		join role_permissions on user_roles.role_id = role_permissions.role_id
+	where user_roles.user_id = $1
+		and role_permissions.permission = $2
+)`
+
+// ErrNoRoleManager is returned when a change would leave no active user with PermissionRolesManage. Nobody would be
+// able to undo the change.
+var ErrNoRoleManager = errors.New("at least one user must be able to manage roles")
+
+// CreateRole creates a role with permissions and returns its ID.
+func CreateRole(ctx context.Context, db pgxutil.DB, name string, permissions []string) (uuid.UUID, error) {
+	roleID := uuid.Must(uuid.NewV7())
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := pgxutil.InsertRow(ctx, tx, "roles", map[string]any{"id": roleID, "name": name})
+		if err != nil {
+			return err
+		}
+
+		return setRolePermissions(ctx, tx, roleID, permissions)
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return roleID, nil
+}
+
+// UpdateRole changes the name and permissions of roleID.
+func UpdateRole(ctx context.Context, db pgxutil.DB, roleID uuid.UUID, name string, permissions []string) error {
+	return preservingRoleManager(ctx, db, func(tx pgx.Tx) error {
+		_, err := pgxutil.ExecRow(ctx, tx, "update roles set name = $1 where id = $2", name, roleID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "delete from role_permissions where role_id = $1", roleID)
+		if err != nil {
+			return err
+		}
+
+		return setRolePermissions(ctx, tx, roleID, permissions)
+	})
+}
+
+// DeleteRole deletes roleID and removes it from all users.
+func DeleteRole(ctx context.Context, db pgxutil.DB, roleID uuid.UUID) error {
+	return preservingRoleManager(ctx, db, func(tx pgx.Tx) error {
+		_, err := pgxutil.ExecRow(ctx, tx, "delete from roles where id = $1", roleID)
+		return err
+	})
+}
+
+// SetUserRoles replaces the roles of userID with roleIDs.
+func SetUserRoles(ctx context.Context, db pgxutil.DB, userID uuid.UUID, roleIDs []uuid.UUID) error {
+	return preservingRoleManager(ctx, db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "delete from user_roles where user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		for _, roleID := range roleIDs {
+			err = pgxutil.InsertRow(ctx, tx, "user_roles", map[string]any{"user_id": userID, "role_id": roleID})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func setRolePermissions(ctx context.Context, tx pgx.Tx, roleID uuid.UUID, permissions []string) error {
+	for _, permission := range permissions {
+		err := pgxutil.InsertRow(ctx, tx, "role_permissions", map[string]any{"role_id": roleID, "permission": permission})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// preservingRoleManager calls fn in a transaction. It returns ErrNoRoleManager and rolls back if an active user could
+// manage roles before fn and none can after. Every change that could remove the last role manager must be made through
+// preservingRoleManager.
+func preservingRoleManager(ctx context.Context, db pgxutil.DB, fn func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		// The lock conflicts with itself so concurrent calls run one at a time. Otherwise two transactions could each
+		// remove a different role manager and each see the other remaining.
+		_, err := tx.Exec(ctx, "lock table user_roles, role_permissions in share row exclusive mode")
+		if err != nil {
+			return err
+		}
+
+		existedBefore, err := roleManagerExists(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		err = fn(tx)
+		if err != nil {
+			return err
+		}
+
+		if existedBefore {
+			existsAfter, err := roleManagerExists(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if !existsAfter {
+				return ErrNoRoleManager
+			}
+		}
+
+		return nil
+	})
+}
+
+func roleManagerExists(ctx context.Context, tx pgx.Tx) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(
+		ctx,
+		`select exists (
+	select 1
+	from user_roles
+		join role_permissions on user_roles.role_id = role_permissions.role_id
+		join users on user_roles.user_id = users.id
+	where role_permissions.permission = $1
+		and users.disabled_at is null
+)`,
+		PermissionRolesManage,
+	).Scan(&exists)
+	return exists, err
+}
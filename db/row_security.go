@@ -0,0 +1,22 @@
+package db
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// SetCurrentUserID limits tx to the rows that belong to userID in tables protected by row-level security. It lasts until
+// tx ends.
+func SetCurrentUserID(ctx context.Context, tx pgx.Tx, userID uuid.UUID) error {
+	_, err := tx.Exec(ctx, "select set_config('app.current_user_id', $1, true)", userID.String())
+	return err
+}
+
+// BypassRowSecurity allows tx to read and write the rows of every user in tables protected by row-level security. It is
+// only for system operations such as permanently deleting a user. It lasts until tx ends.
+func BypassRowSecurity(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, "select set_config('app.bypass_row_security', 'on', true)")
+	return err
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+)
+
+var ErrShareLinkInvalid = errors.New("share link is invalid or expired")
+
+// CreateShareLink returns a new token that allows anyone to view walkID until it expires after lifetime. If walkID is
+// not valid the token allows viewing userID's whole personal walk log. Only a digest of the token is stored so the
+// plaintext token cannot be retrieved later.
+func CreateShareLink(ctx context.Context, db pgxutil.DB, userID uuid.UUID, walkID uuid.NullUUID, lifetime time.Duration) (string, error) {
+	token, tokenDigest, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = pgxutil.InsertRow(ctx, db, "share_links", map[string]any{
+		"id":              uuid.Must(uuid.NewV7()),
+		"user_id":         userID,
+		"walk_id":         walkID,
+		"token_digest":    tokenDigest,
+		"expiration_time": time.Now().Add(lifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ShareLink is a share link that is being viewed.
+type ShareLink struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	WalkID uuid.NullUUID
+}
+
+// ViewShareLink returns the share link for token and records that it was viewed. It returns ErrShareLinkInvalid if token
+// does not exist, has been revoked or has expired.
+//
+// The viewer is not a user so row-level security is bypassed for the transaction. db must not be a transaction that is
+// used for anything else.
+func ViewShareLink(ctx context.Context, db pgxutil.DB, token string) (*ShareLink, error) {
+	var shareLink *ShareLink
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := BypassRowSecurity(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		shareLink, err = pgxutil.SelectRow(
+			ctx,
+			tx,
+			`update share_links
+set view_count = view_count + 1
+where token_digest = $1
+	and expiration_time > now()
+returning id, user_id, walk_id`,
+			[]any{digestSecretToken(token)},
+			pgx.RowToAddrOfStructByPos[ShareLink],
+		)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrShareLinkInvalid
+		}
+		return nil, err
+	}
+
+	return shareLink, nil
+}
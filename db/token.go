@@ -0,0 +1,28 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+const secretTokenLen = 32
+
+// newSecretToken returns a random URL safe token and its digest. Only the digest should be stored.
+func newSecretToken() (string, []byte, error) {
+	randBytes := make([]byte, secretTokenLen)
+	_, err := rand.Read(randBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(randBytes)
+
+	return token, digestSecretToken(token), nil
+}
+
+// digestSecretToken returns the digest of token that is stored in the database. A fast hash is sufficient as the token
+// is random and too long to guess, unlike a password.
+func digestSecretToken(token string) []byte {
+	digest := sha256.Sum256([]byte(token))
+	return digest[:]
+}
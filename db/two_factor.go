@@ -0,0 +1,268 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/lib/totp"
+)
+
+const (
+	// totpSkew is the number of time steps before or after the current time step that a TOTP code is accepted.
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	// recoveryCodeLen characters from recoveryCodeAlphabet are over 128 bits of entropy. That is too many to guess even
+	// with a copy of the database so a fast digest is sufficient like other secret tokens.
+	recoveryCodeLen       = 28
+	recoveryCodeGroupSize = 4
+
+	// recoveryCodeAlphabet omits characters that are easily confused such as 0 and o or 1 and l.
+	recoveryCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+)
+
+var ErrTwoFactorCodeIncorrect = errors.New("two-factor code is incorrect")
+var ErrTwoFactorNotPending = errors.New("two-factor authentication setup has not been started")
+
+// UserTwoFactorEnabled returns true if the user has enabled two-factor authentication.
+func UserTwoFactorEnabled(ctx context.Context, db pgxutil.DB, userID uuid.UUID) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(ctx, "select exists(select 1 from user_totp_secrets where user_id = $1 and enabled_time is not null)", userID).Scan(&enabled)
+	if err != nil {
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// UserTwoFactorRequired returns true if an administrator has required the user to use two-factor authentication.
+func UserTwoFactorRequired(ctx context.Context, db pgxutil.DB, userID uuid.UUID) (bool, error) {
+	var required bool
+	err := db.QueryRow(ctx, "select two_factor_required from users where id = $1", userID).Scan(&required)
+	if err != nil {
+		return false, err
+	}
+
+	return required, nil
+}
+
+// StartUserTwoFactorSetup generates a new TOTP secret for the user. The secret is not used for authentication until it
+// is confirmed with EnableUserTwoFactor. Any previous pending secret is replaced. If two-factor authentication is
+// already enabled the existing secret is returned unchanged.
+func StartUserTwoFactorSetup(ctx context.Context, db pgxutil.DB, userID uuid.UUID) ([]byte, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.QueryRow(
+		ctx,
+		`insert into user_totp_secrets (user_id, secret)
+values ($1, $2)
+on conflict (user_id) do update
+set secret = case when user_totp_secrets.enabled_time is null then excluded.secret else user_totp_secrets.secret end
+returning secret`,
+		userID, secret,
+	).Scan(&secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// GetPendingUserTwoFactorSecret returns the TOTP secret created by StartUserTwoFactorSetup that has not yet been
+// confirmed. It returns ErrTwoFactorNotPending if there is no such secret.
+func GetPendingUserTwoFactorSecret(ctx context.Context, db pgxutil.DB, userID uuid.UUID) ([]byte, error) {
+	var secret []byte
+	err := db.QueryRow(ctx, "select secret from user_totp_secrets where user_id = $1 and enabled_time is null", userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTwoFactorNotPending
+		}
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EnableUserTwoFactor confirms the pending TOTP secret with code and enables two-factor authentication for the user. It
+// returns a new set of recovery codes. The plaintext recovery codes are not stored and cannot be retrieved later.
+func EnableUserTwoFactor(ctx context.Context, db pgxutil.DB, userID uuid.UUID, code string) ([]string, error) {
+	var recoveryCodes []string
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var secret []byte
+		err := tx.QueryRow(ctx, "select secret from user_totp_secrets where user_id = $1 and enabled_time is null for update", userID).Scan(&secret)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrTwoFactorNotPending
+			}
+			return err
+		}
+
+		now := time.Now()
+		step, ok := totp.Validate(secret, normalizeTwoFactorCode(code), now, totpSkew)
+		if !ok {
+			return ErrTwoFactorCodeIncorrect
+		}
+
+		_, err = tx.Exec(ctx, "update user_totp_secrets set enabled_time = $1, last_used_step = $2 where user_id = $3", now, step, userID)
+		if err != nil {
+			return err
+		}
+
+		recoveryCodes, err = ReplaceUserRecoveryCodes(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// DisableUserTwoFactor disables two-factor authentication for the user and deletes their recovery codes.
+func DisableUserTwoFactor(ctx context.Context, db pgxutil.DB, userID uuid.UUID) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "delete from user_recovery_codes where user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "delete from user_totp_secrets where user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// ValidateUserTwoFactorCode checks code against the user's TOTP secret. A code is only accepted once. If code is not a
+// valid TOTP code it is checked against the user's unused recovery codes. A matching recovery code is consumed.
+func ValidateUserTwoFactorCode(ctx context.Context, db pgxutil.DB, userID uuid.UUID, code string) error {
+	code = normalizeTwoFactorCode(code)
+
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var secret []byte
+		var lastUsedStep int64
+		err := tx.QueryRow(
+			ctx,
+			"select secret, last_used_step from user_totp_secrets where user_id = $1 and enabled_time is not null for update",
+			userID,
+		).Scan(&secret, &lastUsedStep)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrTwoFactorCodeIncorrect
+			}
+			return err
+		}
+
+		if len(code) == totp.Digits {
+			step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+			if !ok || step <= lastUsedStep {
+				return ErrTwoFactorCodeIncorrect
+			}
+
+			_, err = tx.Exec(ctx, "update user_totp_secrets set last_used_step = $1 where user_id = $2", step, userID)
+			return err
+		}
+
+		commandTag, err := tx.Exec(
+			ctx,
+			"update user_recovery_codes set used_time = now() where user_id = $1 and digest = $2 and used_time is null",
+			userID, digestSecretToken(code),
+		)
+		if err != nil {
+			return err
+		}
+		if commandTag.RowsAffected() == 0 {
+			return ErrTwoFactorCodeIncorrect
+		}
+
+		return nil
+	})
+}
+
+// ReplaceUserRecoveryCodes deletes the user's existing recovery codes and returns a new set.
+func ReplaceUserRecoveryCodes(ctx context.Context, db pgxutil.DB, userID uuid.UUID) ([]string, error) {
+	recoveryCodes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		recoveryCodes[i] = code
+	}
+
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "delete from user_recovery_codes where user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		for _, code := range recoveryCodes {
+			err = pgxutil.InsertRow(ctx, tx, "user_recovery_codes", map[string]any{
+				"id":      uuid.Must(uuid.NewV7()),
+				"user_id": userID,
+				"digest":  digestSecretToken(normalizeTwoFactorCode(code)),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// generateRecoveryCode returns a random recovery code formatted as groups of characters separated by dashes for
+// readability.
+func generateRecoveryCode() (string, error) {
+	// Bytes at or above the largest multiple of the alphabet size are discarded so every character is equally likely.
+	maxByte := 256 - 256%len(recoveryCodeAlphabet)
+
+	sb := &strings.Builder{}
+	randBytes := make([]byte, recoveryCodeLen)
+	for n := 0; n < recoveryCodeLen; {
+		_, err := rand.Read(randBytes)
+		if err != nil {
+			return "", err
+		}
+
+		for _, b := range randBytes {
+			if int(b) >= maxByte {
+				continue
+			}
+			if n > 0 && n%recoveryCodeGroupSize == 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)])
+			n++
+			if n == recoveryCodeLen {
+				break
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// normalizeTwoFactorCode removes the formatting users are likely to type or paste along with a code.
+func normalizeTwoFactorCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	return code
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/lib/distance"
+)
+
+// SuspendUser prevents userID from logging in and ends all of their login sessions. Their API tokens are refused while
+// they are suspended. It returns ErrNoRoleManager if userID is the last active user that can manage roles.
+func SuspendUser(ctx context.Context, db pgxutil.DB, userID uuid.UUID) error {
+	return preservingRoleManager(ctx, db, func(tx pgx.Tx) error {
+		_, err := pgxutil.ExecRow(ctx, tx, "update users set disabled_at = coalesce(disabled_at, now()) where id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		// Deleting the user's own login sessions also ends any impersonation they started.
+		_, err = tx.Exec(ctx, "delete from login_sessions where user_id = $1", userID)
+		return err
+	})
+}
+
+// ReactivateUser allows a suspended userID to log in again.
+func ReactivateUser(ctx context.Context, db pgxutil.DB, userID uuid.UUID) error {
+	_, err := pgxutil.ExecRow(ctx, db, "update users set disabled_at = null where id = $1", userID)
+	return err
+}
+
+// SetUserDistanceUnit sets the unit userID enters and sees distances in. Walks are always stored in miles.
+func SetUserDistanceUnit(ctx context.Context, db pgxutil.DB, userID uuid.UUID, unit distance.Unit) error {
+	_, err := pgxutil.ExecRow(ctx, db, "update users set distance_unit = $2 where id = $1", userID, string(unit))
+	return err
+}
+
+// DeleteUser permanently deletes userID and everything that belongs to them. The audit log and the impersonation audit
+// trail are kept. Organizations userID owned are handed to their longest standing member. It returns ErrNoRoleManager
+// if userID is the last active user that can manage roles.
+func DeleteUser(ctx context.Context, db pgxutil.DB, userID uuid.UUID) error {
+	return preservingRoleManager(ctx, db, func(tx pgx.Tx) error {
+		err := BypassRowSecurity(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		var username string
+		err = tx.QueryRow(ctx, "select username from users where id = $1 for update", userID).Scan(&username)
+		if err != nil {
+			return err
+		}
+
+		err = leaveAllOrganizations(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range []string{
+			"login_sessions",
+			"share_links",
+			"walks",
+			"user_passwords",
+			"user_totp_secrets",
+			"user_recovery_codes",
+			"webauthn_credentials",
+			"password_reset_tokens",
+			"email_verification_tokens",
+			"user_identities",
+			"api_tokens",
+			"user_roles",
+		} {
+			_, err := tx.Exec(ctx, "delete from "+table+" where user_id = $1", userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, "delete from login_throttles where kind = $1 and key = $2", loginThrottleKindUsername, username)
+		if err != nil {
+			return err
+		}
+
+		_, err = pgxutil.ExecRow(ctx, tx, "delete from users where id = $1", userID)
+		return err
+	})
+}
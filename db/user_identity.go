@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgxutil"
+)
+
+// RecordUserIdentityLogin records a login with the external identity identified by issuer and subject and returns the
+// user it is linked to. email is the email address the identity provider currently reports for the identity. It returns
+// pgx.ErrNoRows if the identity is not linked to a user.
+func RecordUserIdentityLogin(ctx context.Context, db pgxutil.DB, issuer, subject, email string) (*User, error) {
+	return pgxutil.SelectRow(
+		ctx,
+		db,
+		`with identity as (
+	update user_identities
+	set email = $3, last_login_time = now()
+	where issuer = $1 and subject = $2
+	returning user_id
+)
+select users.id, users.username, users.email, users.disabled_at is not null
+from identity
+	join users on identity.user_id = users.id`,
+		[]any{issuer, subject, zeronull.Text(email)},
+		pgx.RowToAddrOfStructByPos[User],
+	)
+}
+
+// LinkUserIdentity links the external identity identified by issuer and subject to userID.
+func LinkUserIdentity(ctx context.Context, db pgxutil.DB, userID uuid.UUID, issuer, subject, email string) error {
+	return pgxutil.InsertRow(ctx, db, "user_identities", map[string]any{
+		"id":              uuid.Must(uuid.NewV7()),
+		"user_id":         userID,
+		"issuer":          issuer,
+		"subject":         subject,
+		"email":           zeronull.Text(email),
+		"last_login_time": time.Now(),
+	})
+}
+
+// CreateUserWithIdentity creates a user without a password that logs in with the external identity identified by
+// issuer and subject. email is optional. If email is not empty it must have been verified by the identity provider.
+func CreateUserWithIdentity(ctx context.Context, db pgxutil.DB, userID uuid.UUID, username, email, issuer, subject string) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var emailVerifiedTime zeronull.Timestamptz
+		if email != "" {
+			emailVerifiedTime = zeronull.Timestamptz(time.Now())
+		}
+
+		err := pgxutil.InsertRow(ctx, tx, "users", map[string]any{
+			"id":                  userID,
+			"username":            username,
+			"email":               zeronull.Text(email),
+			"email_verified_time": emailVerifiedTime,
+		})
+		if err != nil {
+			return err
+		}
+
+		return LinkUserIdentity(ctx, tx, userID, issuer, subject, email)
+	})
+}
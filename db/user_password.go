@@ -5,43 +5,152 @@ import (
 	"crypto/rand"
 	"crypto/subtle"
 	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
 	"github.com/jackc/pgxutil"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
 )
 
 const (
-	passwordSaltLen          = 16
-	argon2DefaultIterations  = 1
-	argon2DefaultMemoryKiB   = 64 * 1024
-	argon2DefaultParallelism = 1
-	argon2DefaultKeyLen      = 32
+	passwordSaltLen = 16
+	argon2KeyLen    = 32
+
+	passwordAlgorithmArgon2id = "Argon2id"
+	passwordAlgorithmBcrypt   = "bcrypt"
+	passwordAlgorithmScrypt   = "scrypt"
 )
 
+// Argon2Params are the cost parameters used to compute new Argon2id password digests.
+type Argon2Params struct {
+	MemoryKiB   uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultArgon2Params are the Argon2id parameters used unless SetArgon2Params is called.
+var DefaultArgon2Params = Argon2Params{
+	MemoryKiB:   64 * 1024,
+	Iterations:  1,
+	Parallelism: 1,
+}
+
+var argon2Params = DefaultArgon2Params
+
+// SetArgon2Params sets the cost parameters used to compute new Argon2id password digests. It must be called before any
+// passwords are set or validated. Existing digests computed with weaker parameters are upgraded on the next successful
+// login.
+func SetArgon2Params(params Argon2Params) error {
+	if params.Iterations < 1 || params.Iterations > math.MaxInt16 {
+		return fmt.Errorf("argon2 iterations must be between 1 and %d", math.MaxInt16)
+	}
+	if params.Parallelism < 1 || params.Parallelism > math.MaxInt8 {
+		return fmt.Errorf("argon2 parallelism must be between 1 and %d", math.MaxInt8)
+	}
+	if params.MemoryKiB < 8*uint32(params.Parallelism) || params.MemoryKiB > math.MaxInt32 {
+		return fmt.Errorf("argon2 memory must be at least 8 KiB per degree of parallelism")
+	}
+
+	argon2Params = params
+	return nil
+}
+
+// passwordDigest is a salted digest of a secret such as a password or recovery code and the parameters used to compute
+// it.
+//
+// Digests imported from other systems may use a different algorithm. For bcrypt, Digest is the entire bcrypt hash
+// string, which includes the salt and cost, and Iterations is the cost. For scrypt, Iterations is log2 of the CPU/memory
+// cost N, MinMemory is the block size r, and Parallelism is p.
+type passwordDigest struct {
+	Algorithm   string
+	Salt        []byte
+	MinMemory   uint32
+	Iterations  uint32
+	Parallelism uint8
+	Digest      []byte
+}
+
+// newPasswordDigest computes a passwordDigest for password with a new random salt.
+func newPasswordDigest(password string) (*passwordDigest, error) {
+	salt := make([]byte, passwordSaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	pd := &passwordDigest{
+		Algorithm:   passwordAlgorithmArgon2id,
+		Salt:        salt,
+		MinMemory:   argon2Params.MemoryKiB,
+		Iterations:  argon2Params.Iterations,
+		Parallelism: argon2Params.Parallelism,
+	}
+	pd.Digest = argon2.IDKey([]byte(password), pd.Salt, pd.Iterations, pd.MinMemory, pd.Parallelism, argon2KeyLen)
+
+	return pd, nil
+}
+
+// matches returns true if password matches pd.
+func (pd *passwordDigest) matches(password string) bool {
+	switch pd.Algorithm {
+	case passwordAlgorithmArgon2id:
+		passwordDigest := argon2.IDKey([]byte(password), pd.Salt, pd.Iterations, pd.MinMemory, pd.Parallelism, uint32(len(pd.Digest)))
+		return subtle.ConstantTimeCompare(pd.Digest, passwordDigest) == 1
+	case passwordAlgorithmBcrypt:
+		return bcrypt.CompareHashAndPassword(pd.Digest, []byte(password)) == nil
+	case passwordAlgorithmScrypt:
+		passwordDigest, err := scrypt.Key([]byte(password), pd.Salt, 1<<pd.Iterations, int(pd.MinMemory), int(pd.Parallelism), len(pd.Digest))
+		if err != nil {
+			return false
+		}
+		return subtle.ConstantTimeCompare(pd.Digest, passwordDigest) == 1
+	default:
+		return false
+	}
+}
+
+// weakerThanCurrentPolicy returns true if pd was computed with a different algorithm or with lower cost parameters than
+// newPasswordDigest currently uses.
+func (pd *passwordDigest) weakerThanCurrentPolicy() bool {
+	return pd.Algorithm != passwordAlgorithmArgon2id ||
+		pd.MinMemory < argon2Params.MemoryKiB ||
+		pd.Iterations < argon2Params.Iterations ||
+		len(pd.Digest) < argon2KeyLen
+}
+
 // SetUserPassword sets the password for a user.
 func SetUserPassword(ctx context.Context, db pgxutil.DB, userID uuid.UUID, password string) error {
-	randBytes := make([]byte, passwordSaltLen)
-	_, err := rand.Read(randBytes)
+	pd, err := newPasswordDigest(password)
 	if err != nil {
 		return err
 	}
 
-	digest := argon2.IDKey([]byte(password), randBytes, argon2DefaultIterations, argon2DefaultMemoryKiB, argon2DefaultParallelism, argon2DefaultKeyLen)
+	return setUserPasswordDigest(ctx, db, userID, pd, zeronull.Timestamptz{})
+}
 
-	_, err = db.Exec(
+// setUserPasswordDigest sets the password digest for a user. The password is temporary if temporaryExpireTime is not
+// zero.
+func setUserPasswordDigest(ctx context.Context, db pgxutil.DB, userID uuid.UUID, pd *passwordDigest, temporaryExpireTime zeronull.Timestamptz) error {
+	_, err := db.Exec(
 		ctx,
-		`insert into user_passwords (user_id, algorithm, salt, min_memory, iterations, parallelism, digest)
-values ($1, $2, $3, $4, $5, $6, $7)
+		`insert into user_passwords (user_id, algorithm, salt, min_memory, iterations, parallelism, digest, temporary_expire_time)
+values ($1, $2, $3, $4, $5, $6, $7, $8)
 on conflict (user_id) do update
 set algorithm = excluded.algorithm,
 	salt = excluded.salt,
 	min_memory = excluded.min_memory,
 	iterations = excluded.iterations,
 	parallelism = excluded.parallelism,
-	digest = excluded.digest`,
-		userID, "Argon2id", randBytes, argon2DefaultMemoryKiB, argon2DefaultIterations, argon2DefaultParallelism, digest)
+	digest = excluded.digest,
+	temporary_expire_time = excluded.temporary_expire_time`,
+		userID, pd.Algorithm, pd.Salt, pd.MinMemory, pd.Iterations, pd.Parallelism, pd.Digest, temporaryExpireTime)
 	if err != nil {
 		return err
 	}
@@ -49,35 +158,103 @@ set algorithm = excluded.algorithm,
 	return nil
 }
 
+// ChangeUserPassword sets the password for a user and deletes all of the user's login sessions other than
+// keepLoginSessionID in a single transaction. Pass uuid.Nil as keepLoginSessionID to delete all login sessions.
+func ChangeUserPassword(ctx context.Context, db pgxutil.DB, userID uuid.UUID, password string, keepLoginSessionID uuid.UUID) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := SetUserPassword(ctx, tx, userID, password)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "delete from login_sessions where user_id = $1 and id <> $2", userID, keepLoginSessionID)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// ResetUserPassword sets a temporary password for a user and deletes all of the user's login sessions. The user must
+// choose a new password after logging in with the temporary password. The temporary password cannot be used after
+// expireTime.
+func ResetUserPassword(ctx context.Context, db pgxutil.DB, userID uuid.UUID, password string, expireTime time.Time) error {
+	pd, err := newPasswordDigest(password)
+	if err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		err := setUserPasswordDigest(ctx, tx, userID, pd, zeronull.Timestamptz(expireTime))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "delete from login_sessions where user_id = $1", userID)
+		return err
+	})
+}
+
+// dummyPasswordDigest is computed on first use so commands that never validate a password do not pay for it.
+var dummyPasswordDigest = sync.OnceValue(func() *passwordDigest {
+	pd, err := newPasswordDigest("dummy password")
+	if err != nil {
+		panic(err)
+	}
+	return pd
+})
+
+// ValidatePasswordForMissingUser does the same work as validating a real password and always returns
+// ErrPasswordIncorrect. It is used when a user or password does not exist so the response time does not reveal that.
+func ValidatePasswordForMissingUser(password string) error {
+	dummyPasswordDigest().matches(password)
+	return ErrPasswordIncorrect
+}
+
 var ErrPasswordIncorrect = errors.New("password is incorrect")
 var ErrPasswordMissing = errors.New("password is missing")
+var ErrPasswordExpired = errors.New("temporary password has expired")
 
+// ValidateUserPassword returns nil if password is the user's password. A temporary password that has expired returns
+// ErrPasswordExpired.
 func ValidateUserPassword(ctx context.Context, db pgxutil.DB, userID uuid.UUID, password string) error {
-	var algorithm string
-	var salt []byte
-	var minMemory uint32
-	var iterations uint32
-	var parallelism uint8
-	var digest []byte
-
+	var pd passwordDigest
+	var temporaryExpireTime zeronull.Timestamptz
 	err := db.QueryRow(
 		ctx,
-		`select algorithm, salt, min_memory, iterations, parallelism, digest
+		`select algorithm, salt, min_memory, iterations, parallelism, digest, temporary_expire_time
 from user_passwords
 where user_id = $1`,
-		userID).Scan(&algorithm, &salt, &minMemory, &iterations, &parallelism, &digest)
+		userID).Scan(&pd.Algorithm, &pd.Salt, &pd.MinMemory, &pd.Iterations, &pd.Parallelism, &pd.Digest, &temporaryExpireTime)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
+			ValidatePasswordForMissingUser(password)
 			return ErrPasswordMissing
 		}
 		return err
 	}
 
-	passwordDigest := argon2.IDKey([]byte(password), salt, iterations, minMemory, parallelism, argon2DefaultKeyLen)
-
-	if subtle.ConstantTimeCompare(digest, passwordDigest) != 1 {
+	if !pd.matches(password) {
 		return ErrPasswordIncorrect
 	}
 
+	if temporaryExpireTime != (zeronull.Timestamptz{}) && time.Now().After(time.Time(temporaryExpireTime)) {
+		return ErrPasswordExpired
+	}
+
+	// The plaintext password is only available at login so this is the only chance to upgrade the digest.
+	if pd.weakerThanCurrentPolicy() {
+		newPD, err := newPasswordDigest(password)
+		if err != nil {
+			return err
+		}
+
+		err = setUserPasswordDigest(ctx, db, userID, newPD, temporaryExpireTime)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
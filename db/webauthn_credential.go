@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+)
+
+// InsertWebAuthnCredential stores a newly registered WebAuthn credential (passkey) for a user.
+func InsertWebAuthnCredential(ctx context.Context, db pgxutil.DB, userID uuid.UUID, name string, credential *webauthn.Credential) error {
+	return pgxutil.InsertRow(ctx, db, "webauthn_credentials", map[string]any{
+		"id":            uuid.Must(uuid.NewV7()),
+		"user_id":       userID,
+		"name":          name,
+		"credential_id": credential.ID,
+		"credential":    credential,
+	})
+}
+
+// GetWebAuthnCredentials returns all WebAuthn credentials for a user.
+func GetWebAuthnCredentials(ctx context.Context, db pgxutil.DB, userID uuid.UUID) ([]webauthn.Credential, error) {
+	return pgxutil.Select(ctx, db,
+		"select credential from webauthn_credentials where user_id = $1 order by insert_time",
+		[]any{userID},
+		pgx.RowTo[webauthn.Credential],
+	)
+}
+
+// UpdateWebAuthnCredentialAfterLogin stores the credential's updated signature counter and flags after it was used to
+// log in.
+func UpdateWebAuthnCredentialAfterLogin(ctx context.Context, db pgxutil.DB, credential *webauthn.Credential) error {
+	_, err := pgxutil.ExecRow(ctx, db,
+		"update webauthn_credentials set credential = $1, last_used_time = $2 where credential_id = $3",
+		credential, time.Now(), credential.ID,
+	)
+	return err
+}
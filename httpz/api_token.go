@@ -0,0 +1,191 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/csrf"
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+)
+
+// apiTokenHandler returns a middleware handler that authenticates requests with an API token in the Authorization
+// header. It builds the same login session as loginSessionHandler so handlers do not need to distinguish the two.
+//
+// The CSRF check is skipped for token authenticated requests. A browser cannot be made to send the Authorization header
+// to another site so such a request cannot be forged. The login session cookie is ignored when a token is present so the
+// skipped check never applies to a cookie authenticated request. It must be used before the CSRF middleware.
+func apiTokenHandler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			env := ctx.Value(ctxKeyEnvironment).(*environment)
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			apiToken, err := db.AuthenticateAPIToken(ctx, env.dbpool, token)
+			if err != nil {
+				if errors.Is(err, db.ErrAPITokenInvalid) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			user := &RequestUser{}
+			err = env.dbpool.QueryRow(ctx,
+				`select users.id, users.username, `+userPermissionsSQL+`, users.email is null or users.email_verified_time is not null,
+	user_passwords.temporary_expire_time is not null
+from users
+	left join user_passwords on users.id=user_passwords.user_id
+where users.id=$1`,
+				apiToken.UserID,
+			).Scan(&user.ID, &user.Username, &user.Permissions, &user.EmailVerified, &user.PasswordChangeRequired)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			loginSession := &RequestLoginSession{User: user, APITokenScope: apiToken.Scope}
+			ctx = context.WithValue(ctx, ctxKeySession, loginSession)
+			r = csrf.UnsafeSkipCheck(r.WithContext(ctx))
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// requireAPITokenScopeHandler returns a middleware handler that only allows token authenticated requests with a scope
+// that permits the request. Safe methods require read access to walks and all other methods require write access.
+// Requests authenticated by a login session cookie are not affected.
+func requireAPITokenScopeHandler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			loginSession := getLoginSession(r.Context())
+
+			switch loginSession.APITokenScope {
+			case "", db.APITokenScopeWalksWrite:
+			case db.APITokenScopeWalksRead:
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					http.Error(w, "API token scope does not allow this request", http.StatusForbidden)
+					return
+				}
+			default:
+				http.Error(w, "API token scope does not allow this request", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// forbidAPITokenHandler returns a middleware handler that refuses token authenticated requests. It is used for
+// everything other than walks so a leaked token cannot change the account.
+func forbidAPITokenHandler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			loginSession := getLoginSession(r.Context())
+			if loginSession.APITokenScope != "" {
+				http.Error(w, "API tokens cannot be used for this request", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// selectAPITokens returns the API tokens of userID for the API tokens page.
+func selectAPITokens(ctx context.Context, dbpool *pgxpool.Pool, userID uuid.UUID) ([]*view.APITokensPageToken, error) {
+	return pgxutil.Select(ctx, dbpool,
+		"select id, name, scope, insert_time, last_used_time from api_tokens where user_id = $1 order by insert_time",
+		[]any{userID},
+		pgx.RowToAddrOfStructByPos[view.APITokensPageToken],
+	)
+}
+
+// handleAPITokens lists the current user's API tokens.
+func handleAPITokens(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	tokens, err := selectAPITokens(ctx, env.dbpool, getLoginSession(ctx).User.ID)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.APITokensPage(tokens, "", &view.APITokenFormFields{}, nil)).Render(ctx, w)
+}
+
+// handleAPITokensCreate creates an API token and shows it once.
+func handleAPITokensCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	formData := view.APITokenFormFields{}
+	err := structify.Parse(params, &formData)
+	if err != nil {
+		return err
+	}
+	formData.Name = strings.TrimSpace(formData.Name)
+
+	validationErrors := &errortree.Node{}
+	if formData.Name == "" {
+		validationErrors.Add([]any{"name"}, errors.New("Name is required"))
+	}
+	if formData.Scope != db.APITokenScopeWalksRead && formData.Scope != db.APITokenScopeWalksWrite {
+		validationErrors.Add([]any{"scope"}, errors.New("Invalid scope"))
+	}
+
+	var newToken string
+	if validationErrors.AllErrors() == nil {
+		newToken, err = db.CreateAPIToken(ctx, env.dbpool, loginSession.User.ID, formData.Name, formData.Scope)
+		if err != nil {
+			return err
+		}
+		formData = view.APITokenFormFields{}
+		validationErrors = nil
+	}
+
+	tokens, err := selectAPITokens(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.APITokensPage(tokens, newToken, &formData, validationErrors)).Render(ctx, w)
+}
+
+// handleAPITokensDelete revokes one of the current user's API tokens.
+func handleAPITokensDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	apiTokenID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	_, err = pgxutil.ExecRow(ctx, env.dbpool, "delete from api_tokens where id = $1 and user_id = $2", apiTokenID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/api_tokens", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,175 @@
+package httpz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/errortree"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+	"github.com/rs/zerolog/hlog"
+)
+
+// auditLogPageSize is the number of entries shown on each page of the audit log.
+const auditLogPageSize = 50
+
+// requestID returns the ID of the request that is sent to the client in the x-request-id header.
+func requestID(ctx context.Context) string {
+	id, ok := hlog.IDFromCtx(ctx)
+	if !ok {
+		return ""
+	}
+	return id.String()
+}
+
+// auditActor returns the current user as the actor of an audited change.
+func auditActor(r *http.Request) *db.AuditActor {
+	ctx := r.Context()
+	loginSession := getLoginSession(ctx)
+	return &db.AuditActor{
+		UserID:    loginSession.User.ID,
+		Username:  loginSession.User.Username,
+		RequestID: requestID(ctx),
+		ClientIP:  clientIP(r),
+	}
+}
+
+// parseAuditLogFilter parses the audit log filter form. Dates are interpreted as UTC days and until is inclusive.
+func parseAuditLogFilter(formData *view.SystemAuditFilterFields) (*db.AuditLogFilter, *errortree.Node) {
+	validationErrors := &errortree.Node{}
+	filter := &db.AuditLogFilter{
+		Actor:  formData.Actor,
+		Target: formData.Target,
+	}
+
+	if formData.Action != "" {
+		if slices.Contains(db.AuditActions, formData.Action) {
+			filter.Action = formData.Action
+		} else {
+			validationErrors.Add([]any{"action"}, errors.New("Action does not exist"))
+		}
+	}
+
+	if formData.Since != "" {
+		since, err := time.Parse(time.DateOnly, formData.Since)
+		if err != nil {
+			validationErrors.Add([]any{"since"}, errors.New("Date is invalid"))
+		}
+		filter.Since = since
+	}
+
+	if formData.Until != "" {
+		until, err := time.Parse(time.DateOnly, formData.Until)
+		if err != nil {
+			validationErrors.Add([]any{"until"}, errors.New("Date is invalid"))
+		} else {
+			filter.Until = until.AddDate(0, 0, 1)
+		}
+	}
+
+	if len(validationErrors.AllErrors()) > 0 {
+		return nil, validationErrors
+	}
+
+	return filter, nil
+}
+
+// auditLogPageEntries converts audit log entries for display.
+func auditLogPageEntries(entries []*db.AuditLogEntry) []*view.SystemAuditPageEntry {
+	pageEntries := make([]*view.SystemAuditPageEntry, 0, len(entries))
+	for _, entry := range entries {
+		pageEntry := &view.SystemAuditPageEntry{
+			Time:          entry.Time,
+			Action:        entry.Action,
+			ActorUsername: entry.ActorUsername,
+			TargetName:    string(entry.TargetName),
+			RequestID:     string(entry.RequestID),
+			ClientIP:      string(entry.ClientIP),
+		}
+		if !entry.ActorUserID.Valid {
+			pageEntry.ActorUsername += " (command line)"
+		}
+
+		fields := make([]string, 0, len(entry.Changes))
+		for field := range entry.Changes {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+		for _, field := range fields {
+			change := entry.Changes[field]
+			pageEntry.Changes = append(pageEntry.Changes, &view.SystemAuditPageChange{
+				Field:  field,
+				Before: formatAuditValue(change.Before),
+				After:  formatAuditValue(change.After),
+			})
+		}
+
+		pageEntries = append(pageEntries, pageEntry)
+	}
+
+	return pageEntries
+}
+
+// formatAuditValue formats a changed value for display. Missing values are shown as empty.
+func formatAuditValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strconv.Quote(value)
+	default:
+		buf, err := json.Marshal(value)
+		if err != nil {
+			return "?"
+		}
+		return string(buf)
+	}
+}
+
+// handleSystemAudit lists audit log entries matching the filter form.
+func handleSystemAudit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := view.SystemAuditFilterFields{}
+	err := structify.Parse(params, &formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.SystemAuditPage(nil, db.AuditActions, &formData, validationErrors, "", "")).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	filter, validationErrors := parseAuditLogFilter(&formData)
+	if validationErrors != nil {
+		return view.ApplicationLayout(view.SystemAuditPage(nil, db.AuditActions, &formData, validationErrors, "", "")).Render(r.Context(), w)
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 1)
+
+	// One extra entry is selected to determine whether there is an older page.
+	entries, err := db.SelectAuditLogEntries(ctx, env.dbpool, filter, auditLogPageSize+1, (page-1)*auditLogPageSize)
+	if err != nil {
+		return err
+	}
+
+	pageURL := func(page int) string {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page))
+		return "/system/audit?" + query.Encode()
+	}
+	var newerURL, olderURL string
+	if page > 1 {
+		newerURL = pageURL(page - 1)
+	}
+	if len(entries) > auditLogPageSize {
+		entries = entries[:auditLogPageSize]
+		olderURL = pageURL(page + 1)
+	}
+
+	return view.ApplicationLayout(view.SystemAuditPage(auditLogPageEntries(entries), db.AuditActions, &formData, nil, newerURL, olderURL)).Render(r.Context(), w)
+}
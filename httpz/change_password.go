@@ -0,0 +1,60 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/errortree"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+)
+
+// handleChangePassword renders the change password form.
+func handleChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := view.ChangePasswordFormFields{}
+
+	return view.ApplicationLayout(view.ChangePassword(&formData, nil, getLoginSession(ctx).User.PasswordChangeRequired)).Render(r.Context(), w)
+}
+
+// handleChangePasswordSubmit changes the current user's password.
+func handleChangePasswordSubmit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	formData := view.ChangePasswordFormFields{}
+	err := structify.Parse(params, &formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.ChangePassword(&formData, validationErrors, loginSession.User.PasswordChangeRequired)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	// An expired temporary password is still accepted here. The user may have logged in with a passkey after it
+	// expired and must be able to replace it.
+	err = db.ValidateUserPassword(ctx, env.dbpool, loginSession.User.ID, formData.CurrentPassword)
+	if err != nil && !errors.Is(err, db.ErrPasswordExpired) {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"currentPassword"}, errors.New("Invalid password"))
+		return view.ApplicationLayout(view.ChangePassword(&formData, validationErrors, loginSession.User.PasswordChangeRequired)).Render(r.Context(), w)
+	}
+
+	policyErr, err := env.passwordPolicy.validate(loginSession.User.Username, formData.NewPassword)
+	if err != nil {
+		return err
+	}
+	if policyErr != nil {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"newPassword"}, policyErr)
+		return view.ApplicationLayout(view.ChangePassword(&formData, validationErrors, loginSession.User.PasswordChangeRequired)).Render(r.Context(), w)
+	}
+
+	err = db.ChangeUserPassword(ctx, env.dbpool, loginSession.User.ID, formData.NewPassword, loginSession.ID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
@@ -6,9 +6,11 @@ package httpz
 import (
 	"context"
 	"net/http"
+	"time"
 
-	"github.com/gorilla/securecookie"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/web-starter-app/lib/mail"
 	"github.com/rs/zerolog"
 )
 
@@ -19,14 +21,40 @@ const (
 	_ ctxRequestKey = iota
 	ctxKeyEnvironment
 	ctxKeySession
+	ctxKeyCurrentUserTx
 )
 
 type environment struct {
 	dbpool *pgxpool.Pool
 	logger *zerolog.Logger
 
-	secureCookie          *securecookie.SecureCookie
+	secureCookie          secureCookieCodecs
 	sessionCookieTemplate *http.Cookie
+
+	loginSessionIdleTimeout time.Duration
+	loginSessionMaxLifetime time.Duration
+
+	// Persistent login sessions are created when the user chooses "remember me". Their cookie outlives the browser
+	// session and they have their own, typically longer, lifetimes.
+	persistentLoginSessionIdleTimeout time.Duration
+	persistentLoginSessionMaxLifetime time.Duration
+
+	webAuthn *webauthn.WebAuthn
+
+	mailer   mail.Mailer
+	mailFrom string
+
+	// baseURL is used to build absolute URLs such as links in emails. The request's Host header is not used as it is
+	// controlled by the client.
+	baseURL string
+
+	// oidc is nil if single sign-on is not configured.
+	oidc *oidcClient
+
+	passwordPolicy *PasswordPolicy
+
+	// loginSessionCache is nil if login sessions are not cached.
+	loginSessionCache *LoginSessionCache
 }
 
 // setContextValue returns a middleware handler that sets a value in the request context.
@@ -0,0 +1,107 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+	"github.com/rs/zerolog"
+)
+
+// forbidImpersonationHandler returns a middleware handler that refuses requests made while impersonating a user. It is
+// used for actions that change the user's credentials or security settings. It must be used after
+// requireCurrentUserHandler.
+func forbidImpersonationHandler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			loginSession := getLoginSession(ctx)
+			if loginSession.Impersonator != nil {
+				w.WriteHeader(http.StatusForbidden)
+				err := view.ApplicationLayout(view.ImpersonationForbiddenPage()).Render(ctx, w)
+				if err != nil {
+					env := ctx.Value(ctxKeyEnvironment).(*environment)
+					env.logger.Error().Err(err).Msg("error rendering impersonation forbidden page")
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// handleImpersonationStop ends an impersonation and returns to the impersonator's own login session.
+func handleImpersonationStop(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+	if loginSession.Impersonator == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return nil
+	}
+
+	impersonatorLoginSessionID, err := db.StopImpersonation(ctx, env.dbpool, loginSession.ID)
+	if err != nil {
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Str("impersonator_user_id", loginSession.Impersonator.ID.String()).Str("impersonated_user_id", loginSession.User.ID.String()).Msg("stopped impersonating user")
+
+	// Return to the impersonator's own login session.
+	var persistent bool
+	var loginTime time.Time
+	err = env.dbpool.QueryRow(ctx, "select persistent, login_time from login_sessions where id = $1", impersonatorLoginSessionID).Scan(&persistent, &loginTime)
+	if err != nil {
+		return err
+	}
+
+	var expires time.Time
+	if persistent {
+		expires = loginTime.Add(env.persistentLoginSessionMaxLifetime)
+	}
+	err = setLoginSessionCookie(w, r, impersonatorLoginSessionID, expires)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/system/users/"+loginSession.User.ID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleSystemUsersImpersonate starts impersonating a user.
+func handleSystemUsersImpersonate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	userID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	impersonationLoginSessionID, err := db.StartImpersonation(ctx, env.dbpool, db.StartImpersonationArgs{
+		ImpersonatorLoginSessionID: loginSession.ID,
+		ImpersonatedUserID:         userID,
+		UserAgent:                  r.UserAgent(),
+		LoginRequestID:             requestID(ctx),
+		ClientIP:                   clientIP(r),
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrImpersonationNotAllowed) {
+			http.Error(w, "This user cannot be impersonated", http.StatusForbidden)
+			return nil
+		}
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Str("impersonator_user_id", loginSession.User.ID.String()).Str("impersonated_user_id", userID.String()).Msg("started impersonating user")
+
+	err = setLoginSessionCookie(w, r, impersonationLoginSessionID, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,117 @@
+package httpz
+
+import (
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+)
+
+// secureCookieCodecs encodes cookies with the newest key generation and decodes cookies encoded with any key generation.
+// This allows keys to be rotated without invalidating existing cookies. The newest generation is first.
+type secureCookieCodecs []securecookie.Codec
+
+// newSecureCookieCodecs returns secureCookieCodecs for pairs of authentication and encryption keys. maxAge is the
+// maximum age in seconds of a cookie value.
+func newSecureCookieCodecs(authenticationKeys, encryptionKeys [][]byte, maxAge int) secureCookieCodecs {
+	codecs := make(secureCookieCodecs, len(authenticationKeys))
+	for i := range authenticationKeys {
+		codecs[i] = securecookie.New(authenticationKeys[i], encryptionKeys[i]).MaxAge(maxAge)
+	}
+	return codecs
+}
+
+func (codecs secureCookieCodecs) Encode(name string, value any) (string, error) {
+	return securecookie.EncodeMulti(name, value, codecs...)
+}
+
+func (codecs secureCookieCodecs) Decode(name, value string, dst any) error {
+	_, err := codecs.decode(name, value, dst)
+	return err
+}
+
+// decode decodes value like Decode and also returns the key generation that decoded it. The newest generation is 0. A
+// cookie decoded with an older generation should be reissued so it survives the older key being retired.
+func (codecs secureCookieCodecs) decode(name, value string, dst any) (generation int, err error) {
+	if len(codecs) == 0 {
+		return -1, securecookie.DecodeMulti(name, value, dst)
+	}
+
+	var errs securecookie.MultiError
+	for i, codec := range codecs {
+		err := codec.Decode(name, value, dst)
+		if err == nil {
+			return i, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return -1, errs
+}
+
+const (
+	// csrfCookieName is the name of the cookie gorilla/csrf stores the real CSRF token in.
+	csrfCookieName = "_gorilla_csrf"
+
+	// csrfMaxAge is the lifetime in seconds of the CSRF cookie.
+	csrfMaxAge = 12 * 60 * 60
+)
+
+// csrfKeyRotationHandler returns a middleware handler that allows the CSRF key to be rotated without breaking forms
+// that are already open. gorilla/csrf only accepts a single key so a CSRF cookie signed with an older key would be
+// replaced and the token in open forms would no longer match. Instead, such a cookie is re-signed with the newest key
+// before the request reaches gorilla/csrf. csrfKeys must be ordered newest first.
+func csrfKeyRotationHandler(csrfKeys [][]byte) func(http.Handler) http.Handler {
+	codecs := make([]*securecookie.SecureCookie, len(csrfKeys))
+	for i, key := range csrfKeys {
+		// Match the configuration gorilla/csrf uses for its own securecookie.
+		codecs[i] = securecookie.New(key, nil)
+		codecs[i].SetSerializer(securecookie.JSONEncoder{})
+		codecs[i].MaxAge(csrfMaxAge)
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(codecs) < 2 {
+			return next
+		}
+
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie(csrfCookieName)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var token []byte
+			if codecs[0].Decode(csrfCookieName, cookie.Value, &token) == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, codec := range codecs[1:] {
+				if codec.Decode(csrfCookieName, cookie.Value, &token) != nil {
+					continue
+				}
+
+				encoded, err := codecs[0].Encode(csrfCookieName, token)
+				if err != nil {
+					break
+				}
+
+				cookies := r.Cookies()
+				r = r.Clone(r.Context())
+				r.Header.Del("Cookie")
+				for _, c := range cookies {
+					if c.Name == csrfCookieName {
+						c.Value = encoded
+					}
+					r.AddCookie(c)
+				}
+				break
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
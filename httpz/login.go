@@ -0,0 +1,131 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+	"github.com/rs/zerolog"
+)
+
+// handleLogin renders the login form.
+func handleLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	return view.ApplicationLayout(view.LoginPage(nil)).Render(ctx, w)
+}
+
+// handleLoginSubmit checks a username and password. It logs the user in or continues to the two-factor step.
+func handleLoginSubmit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	rememberMe := r.FormValue("rememberMe") == "1"
+	ip := clientIP(r)
+
+	lockedUntil, err := loginLockedUntil(ctx, env, r, username)
+	if err != nil {
+		return err
+	}
+	if !lockedUntil.IsZero() {
+		loginErrors := &errortree.Node{}
+		loginErrors.Add(nil, errLoginLocked)
+		return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+	}
+
+	user, err := db.GetUserByUsername(ctx, env.dbpool, username)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			db.ValidatePasswordForMissingUser(password)
+			err = db.RecordFailedLogin(ctx, env.dbpool, username, ip)
+			if err != nil {
+				return err
+			}
+
+			loginErrors := &errortree.Node{}
+			loginErrors.Add(nil, errors.New("Invalid username or password"))
+			return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+		}
+		return err
+	}
+
+	err = db.ValidateUserPassword(ctx, env.dbpool, user.ID, password)
+	if errors.Is(err, db.ErrPasswordExpired) {
+		loginErrors := &errortree.Node{}
+		loginErrors.Add(nil, errors.New("Your temporary password has expired. Ask an administrator to reset it."))
+		return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+	}
+	if err != nil {
+		err = db.RecordFailedLogin(ctx, env.dbpool, username, ip)
+		if err != nil {
+			return err
+		}
+
+		loginErrors := &errortree.Node{}
+		loginErrors.Add(nil, errors.New("Invalid username or password"))
+		return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+	}
+
+	if user.Disabled {
+		loginErrors := &errortree.Node{}
+		loginErrors.Add(nil, errUserSuspended)
+		return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+	}
+
+	twoFactorEnabled, err := db.UserTwoFactorEnabled(ctx, env.dbpool, user.ID)
+	if err != nil {
+		return err
+	}
+	twoFactorRequired, err := db.UserTwoFactorRequired(ctx, env.dbpool, user.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactorEnabled || twoFactorRequired {
+		err = setPendingTwoFactorLoginCookie(w, r, user.ID, rememberMe)
+		if err != nil {
+			return err
+		}
+
+		http.Redirect(w, r, "/login/two_factor", http.StatusSeeOther)
+		return nil
+	}
+
+	err = createLoginSession(ctx, w, r, user.ID, rememberMe)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
+
+// handleLogout ends the current login session. Logging out while impersonating ends the impersonator's login session as well.
+func handleLogout(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+	if loginSession != nil {
+		if loginSession.Impersonator != nil {
+			// Logging out while impersonating logs out the impersonator as well.
+			impersonatorLoginSessionID, err := db.StopImpersonation(ctx, env.dbpool, loginSession.ID)
+			if err != nil {
+				return err
+			}
+			zerolog.Ctx(ctx).Info().Str("impersonator_user_id", loginSession.Impersonator.ID.String()).Str("impersonated_user_id", loginSession.User.ID.String()).Msg("stopped impersonating user")
+
+			_, err = env.dbpool.Exec(ctx, "delete from login_sessions where id=$1", impersonatorLoginSessionID)
+			if err != nil {
+				return err
+			}
+			evictLoginSessions(env, impersonatorLoginSessionID)
+		}
+
+		_, err := env.dbpool.Exec(ctx, "delete from login_sessions where id=$1", loginSession.ID)
+		if err != nil {
+			return err
+		}
+		evictLoginSessions(env, loginSession.ID)
+	}
+	clearLoginSessionCookie(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	return nil
+}
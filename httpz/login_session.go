@@ -4,30 +4,101 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/gorilla/securecookie"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/lib/distance"
+	"github.com/jackc/web-starter-app/lib/useragent"
+	"github.com/jackc/web-starter-app/view"
 )
 
 type RequestUser struct {
 	ID       uuid.UUID
 	Username string
-	System   bool
+
+	// Permissions are the permissions granted to the user by their roles.
+	Permissions []string
+
+	// EmailVerified is false for a self-registered user that has not yet confirmed their email address.
+	EmailVerified bool
+
+	// PasswordChangeRequired is true when the user's password is a temporary password set by an operator.
+	PasswordChangeRequired bool
+
+	// Organizations are the organizations the user is a member of ordered by name.
+	Organizations []RequestOrganization
+
+	// CurrentOrganizationID is the organization chosen with the organization switcher. It is not valid when the user is
+	// working with their personal walk log.
+	CurrentOrganizationID uuid.NullUUID
+
+	// DistanceUnit is the unit the user enters and sees distances in. It is empty for a request authenticated by an API
+	// token so API clients are not affected by the preference. An empty unit is miles.
+	DistanceUnit distance.Unit
+}
+
+type RequestOrganization struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// CurrentOrganization returns the organization chosen with the organization switcher or nil when the user is working
+// with their personal walk log.
+func (u *RequestUser) CurrentOrganization() *RequestOrganization {
+	if !u.CurrentOrganizationID.Valid {
+		return nil
+	}
+	for i := range u.Organizations {
+		if u.Organizations[i].ID == u.CurrentOrganizationID.UUID {
+			return &u.Organizations[i]
+		}
+	}
+	return nil
+}
+
+// HasPermission returns true if the user has been granted permission.
+func (u *RequestUser) HasPermission(permission string) bool {
+	return slices.Contains(u.Permissions, permission)
 }
 
 type RequestLoginSession struct {
 	ID   uuid.UUID
 	User *RequestUser
+
+	// Persistent is true if the user chose "remember me" when logging in.
+	Persistent bool
+
+	// Impersonator is the user that is impersonating User. It is nil when not impersonating.
+	Impersonator *RequestUser
+
+	// APITokenScope is the scope of the API token that authenticated the request. It is empty when the request was
+	// authenticated with a login session cookie.
+	APITokenScope string
 }
 
-// loginSessionHandler returns a middleware handler that loads the login session from the request cookie.
+// loginSessionLastRequestTimeUpdateInterval is the minimum time between updates of a login session's
+// approximate_last_request_time. This avoids a database write on every request at the cost of the idle timeout being
+// enforced with up to this much imprecision.
+const loginSessionLastRequestTimeUpdateInterval = time.Minute
+
+// loginSessionHandler returns a middleware handler that loads the login session from the request cookie. A request that
+// was already authenticated by apiTokenHandler is left unchanged.
 func loginSessionHandler() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
 			env := ctx.Value(ctxKeyEnvironment).(*environment)
+			if _, ok := ctx.Value(ctxKeySession).(*RequestLoginSession); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			loginSession := &RequestLoginSession{}
 			ctx = context.WithValue(ctx, ctxKeySession, loginSession)
 
@@ -42,7 +113,7 @@ func loginSessionHandler() func(http.Handler) http.Handler {
 			}
 
 			var loginSessionID uuid.UUID
-			err = env.secureCookie.Decode(env.sessionCookieTemplate.Name, cookie.Value, &loginSessionID)
+			keyGeneration, err := env.secureCookie.decode(env.sessionCookieTemplate.Name, cookie.Value, &loginSessionID)
 			if err != nil {
 				var secureCookieError securecookie.Error
 				if errors.As(err, &secureCookieError) && secureCookieError.IsDecode() {
@@ -52,25 +123,104 @@ func loginSessionHandler() func(http.Handler) http.Handler {
 				return
 			}
 
-			user := &RequestUser{}
-			err = env.dbpool.QueryRow(ctx,
-				`select login_sessions.id, users.id, users.username, users.system
-from login_sessions
-	join users on login_sessions.user_id=users.id
-where login_sessions.id=$1`,
-				loginSessionID,
-			).Scan(&loginSession.ID, &user.ID, &user.Username, &user.System)
-			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					// invalid session ID
-					next.ServeHTTP(w, r.WithContext(ctx))
+			// A cached login session that appears to have expired is reloaded as another instance may have seen more
+			// recent requests.
+			now := time.Now()
+			record, generation := env.loginSessionCache.get(loginSessionID)
+			cached := record != nil && !record.expired(env, now)
+			if !cached {
+				record, err = loadLoginSessionRecord(ctx, env.dbpool, loginSessionID)
+				if err != nil {
+					if errors.Is(err, pgx.ErrNoRows) {
+						// invalid session ID
+						next.ServeHTTP(w, r.WithContext(ctx))
+						return
+					} else {
+						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+						return
+					}
+				}
+			}
+
+			// An impersonation ends if the impersonator is no longer allowed to impersonate users.
+			impersonationRevoked := record.impersonatorID.Valid && !record.impersonatorCanImpersonate
+
+			if record.expired(env, now) || impersonationRevoked {
+				_, err := env.dbpool.Exec(ctx, "delete from login_sessions where id=$1", record.id)
+				if err != nil {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 					return
-				} else {
+				}
+				env.loginSessionCache.remove(record.id)
+				clearLoginSessionCookie(w, r)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			if now.Sub(record.approximateLastRequestTime) > loginSessionLastRequestTimeUpdateInterval {
+				err := touchLoginSession(ctx, env.dbpool, record.id, now)
+				if err != nil {
+					if errors.Is(err, errLoginSessionNotFound) {
+						// The login session was deleted after it was cached.
+						env.loginSessionCache.remove(record.id)
+						next.ServeHTTP(w, r.WithContext(ctx))
+						return
+					}
 					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 					return
 				}
+
+				touchedRecord := *record
+				touchedRecord.approximateLastRequestTime = now
+				record = &touchedRecord
+				cached = false
+			}
+
+			if !cached {
+				env.loginSessionCache.put(record, generation)
+			}
+
+			loginSession.ID = record.id
+			loginSession.Persistent = record.persistent
+			user := record.user
+
+			if keyGeneration > 0 {
+				var expires time.Time
+				if loginSession.Persistent {
+					expires = record.loginTime.Add(env.persistentLoginSessionMaxLifetime)
+				}
+				err := setLoginSessionCookie(w, r, loginSession.ID, expires)
+				if err != nil {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			loginSession.User = &user
+			ctx = context.WithValue(ctx, view.PermissionsCtxKey, user.Permissions)
+			ctx = context.WithValue(ctx, view.DistanceUnitCtxKey, user.DistanceUnit)
+			if len(user.Organizations) > 0 {
+				switcher := &view.OrganizationSwitcher{}
+				if currentOrganization := user.CurrentOrganization(); currentOrganization != nil {
+					switcher.CurrentOrganizationID = currentOrganization.ID.String()
+				}
+				for _, organization := range user.Organizations {
+					switcher.Organizations = append(switcher.Organizations, view.SwitcherOrganization{ID: organization.ID.String(), Name: organization.Name})
+				}
+				ctx = context.WithValue(ctx, view.OrganizationSwitcherCtxKey, switcher)
+			}
+
+			if record.impersonatorID.Valid {
+				loginSession.Impersonator = &RequestUser{
+					ID:            record.impersonatorID.UUID,
+					Username:      record.impersonatorUsername,
+					EmailVerified: true,
+				}
+				ctx = context.WithValue(ctx, view.ImpersonationCtxKey, &view.Impersonation{
+					ImpersonatorUsername: loginSession.Impersonator.Username,
+					ImpersonatedUsername: user.Username,
+				})
 			}
-			loginSession.User = user
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		}
@@ -79,11 +229,75 @@ where login_sessions.id=$1`,
 	}
 }
 
-// setLoginSessionCookie sets the login session cookie in the response.
-func setLoginSessionCookie(w http.ResponseWriter, r *http.Request, loginSessionID uuid.UUID) error {
+// expired returns true if the login session has exceeded its idle timeout or maximum lifetime at now.
+func (record *loginSessionRecord) expired(env *environment, now time.Time) bool {
+	idleTimeout, maxLifetime := env.loginSessionIdleTimeout, env.loginSessionMaxLifetime
+	if record.persistent {
+		idleTimeout, maxLifetime = env.persistentLoginSessionIdleTimeout, env.persistentLoginSessionMaxLifetime
+	}
+
+	return now.Sub(record.loginTime) > maxLifetime || now.Sub(record.approximateLastRequestTime) > idleTimeout
+}
+
+// errUserSuspended is returned by createLoginSession and shown when a suspended user attempts to log in.
+var errUserSuspended = errors.New("This account has been suspended.")
+
+// createLoginSession creates a login session for userID and sets the login session cookie in the response. If
+// persistent is true the cookie outlives the browser session. The user's failed login attempts are reset. It returns
+// errUserSuspended if the user has been suspended.
+func createLoginSession(ctx context.Context, w http.ResponseWriter, r *http.Request, userID uuid.UUID, persistent bool) error {
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+
+	user, err := db.GetUserByID(ctx, env.dbpool, userID)
+	if err != nil {
+		return err
+	}
+	if user.Disabled {
+		return errUserSuspended
+	}
+
+	now := time.Now()
+	loginSessionID, err := pgxutil.InsertRowReturning(ctx, env.dbpool, "login_sessions", map[string]any{
+		"id":                            uuid.Must(uuid.NewV7()),
+		"user_id":                       userID,
+		"user_agent":                    zeronull.Text(r.UserAgent()),
+		"login_time":                    now,
+		"login_request_id":              requestID(ctx),
+		"approximate_last_request_time": now,
+		"persistent":                    persistent,
+	},
+		"id",
+		pgx.RowTo[uuid.UUID],
+	)
+	if err != nil {
+		return err
+	}
+
+	err = db.ResetUserLoginThrottle(ctx, env.dbpool, userID)
+	if err != nil {
+		return err
+	}
+
+	err = setKnownDeviceCookie(w, r, userID)
+	if err != nil {
+		return err
+	}
+
+	var expires time.Time
+	if persistent {
+		expires = now.Add(env.persistentLoginSessionMaxLifetime)
+	}
+
+	return setLoginSessionCookie(w, r, loginSessionID, expires)
+}
+
+// setLoginSessionCookie sets the login session cookie in the response. If expires is the zero time the cookie is
+// deleted when the browser session ends.
+func setLoginSessionCookie(w http.ResponseWriter, r *http.Request, loginSessionID uuid.UUID, expires time.Time) error {
 	ctx := r.Context()
 	env := ctx.Value(ctxKeyEnvironment).(*environment)
-	cookie := &(*env.sessionCookieTemplate)
+	cookie := *env.sessionCookieTemplate
+	cookie.Expires = expires
 
 	var err error
 	cookie.Value, err = env.secureCookie.Encode(cookie.Name, loginSessionID)
@@ -91,7 +305,7 @@ func setLoginSessionCookie(w http.ResponseWriter, r *http.Request, loginSessionI
 		return err
 	}
 
-	http.SetCookie(w, cookie)
+	http.SetCookie(w, &cookie)
 
 	return nil
 }
@@ -100,9 +314,9 @@ func setLoginSessionCookie(w http.ResponseWriter, r *http.Request, loginSessionI
 func clearLoginSessionCookie(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	env := ctx.Value(ctxKeyEnvironment).(*environment)
-	cookie := &(*env.sessionCookieTemplate)
+	cookie := *env.sessionCookieTemplate
 	cookie.Expires = time.Unix(0, 0)
-	http.SetCookie(w, cookie)
+	http.SetCookie(w, &cookie)
 }
 
 // getLoginSession returns the login session from the request context.
@@ -128,14 +342,14 @@ func requireCurrentUserHandler(redirectURL string) func(http.Handler) http.Handl
 	}
 }
 
-// requireSystemUserHandler returns a middleware handler that redirects to redirectURL if there is no current user or the
-// user is not a system user.
-func requireSystemUserHandler(redirectURL string) func(http.Handler) http.Handler {
+// requireVerifiedEmailHandler returns a middleware handler that redirects to redirectURL if the current user has not
+// verified their email address. It must be used after requireCurrentUserHandler.
+func requireVerifiedEmailHandler(redirectURL string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
 			loginSession := getLoginSession(ctx)
-			if loginSession.User == nil || !loginSession.User.System {
+			if !loginSession.User.EmailVerified {
 				http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 				return
 			}
@@ -146,3 +360,149 @@ func requireSystemUserHandler(redirectURL string) func(http.Handler) http.Handle
 		return http.HandlerFunc(fn)
 	}
 }
+
+// requirePasswordChangeHandler returns a middleware handler that redirects a user that must change their password to
+// changePasswordURL. Only changePasswordURL and allowedURLs are reachable until the password is changed. It does not
+// apply while impersonating as the impersonator cannot change the user's password. It does not apply to API token
+// requests either. An API client cannot follow the redirect to a form and the token is a separate credential the user
+// created.
+func requirePasswordChangeHandler(changePasswordURL string, allowedURLs ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			loginSession := getLoginSession(ctx)
+			if loginSession.User != nil && loginSession.User.PasswordChangeRequired && loginSession.Impersonator == nil &&
+				loginSession.APITokenScope == "" && r.URL.Path != changePasswordURL && !slices.Contains(allowedURLs, r.URL.Path) {
+				http.Redirect(w, r, changePasswordURL, http.StatusSeeOther)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// requirePermissionHandler returns a middleware handler that responds with 403 Forbidden if the current user does not
+// have permission. It must be used after requireCurrentUserHandler.
+func requirePermissionHandler(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			loginSession := getLoginSession(ctx)
+			if !loginSession.User.HasPermission(permission) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// handleLoginSessions lists the current user's active login sessions.
+func handleLoginSessions(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	pageSessions, err := pgxutil.Select(ctx, env.dbpool,
+		`select id, user_agent, login_time, approximate_last_request_time, login_request_id, persistent
+from login_sessions
+where user_id = $1
+order by approximate_last_request_time desc`,
+		[]any{loginSession.User.ID},
+		func(row pgx.CollectableRow) (*view.LoginSessionsPageSession, error) {
+			pageSession := &view.LoginSessionsPageSession{}
+			var userAgent zeronull.Text
+			err := row.Scan(&pageSession.ID, &userAgent, &pageSession.LoginTime, &pageSession.ApproximateLastRequestTime, &pageSession.LoginRequestID, &pageSession.Persistent)
+			if err != nil {
+				return nil, err
+			}
+
+			pageSession.Device = useragent.Parse(string(userAgent)).String()
+			pageSession.Current = pageSession.ID == loginSession.ID
+
+			return pageSession, nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Expired login sessions are not deleted until they are used again. Hide them so the user only sees sessions that
+	// can still be used.
+	now := time.Now()
+	pageSessions = slices.DeleteFunc(pageSessions, func(pageSession *view.LoginSessionsPageSession) bool {
+		record := &loginSessionRecord{
+			persistent:                 pageSession.Persistent,
+			loginTime:                  pageSession.LoginTime,
+			approximateLastRequestTime: pageSession.ApproximateLastRequestTime,
+		}
+		return record.expired(env, now)
+	})
+
+	return view.ApplicationLayout(view.LoginSessionsPage(pageSessions)).Render(r.Context(), w)
+}
+
+// handleLoginSessionsDelete revokes one of the current user's login sessions.
+func handleLoginSessionsDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	loginSessionID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	_, err = pgxutil.ExecRow(ctx, env.dbpool, "delete from login_sessions where id = $1 and user_id = $2", loginSessionID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	evictLoginSessions(env, loginSessionID)
+
+	if loginSessionID == loginSession.ID {
+		clearLoginSessionCookie(w, r)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return nil
+	}
+
+	http.Redirect(w, r, "/login_sessions", http.StatusSeeOther)
+	return nil
+}
+
+// handleLoginSessionsDeleteOthers revokes all of the current user's login sessions except the current one.
+func handleLoginSessionsDeleteOthers(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	deletedLoginSessionIDs, err := pgxutil.Select(ctx, env.dbpool,
+		"delete from login_sessions where user_id = $1 and id <> $2 returning id",
+		[]any{loginSession.User.ID, loginSession.ID},
+		pgx.RowTo[uuid.UUID],
+	)
+	if err != nil {
+		return err
+	}
+	evictLoginSessions(env, deletedLoginSessionIDs...)
+
+	http.Redirect(w, r, "/login_sessions", http.StatusSeeOther)
+	return nil
+}
+
+// handleLoginSessionsDeleteRemembered revokes the current user's other persistent login sessions.
+func handleLoginSessionsDeleteRemembered(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	deletedLoginSessionIDs, err := pgxutil.Select(ctx, env.dbpool,
+		"delete from login_sessions where user_id = $1 and persistent and id <> $2 returning id",
+		[]any{loginSession.User.ID, loginSession.ID},
+		pgx.RowTo[uuid.UUID],
+	)
+	if err != nil {
+		return err
+	}
+	evictLoginSessions(env, deletedLoginSessionIDs...)
+
+	http.Redirect(w, r, "/login_sessions", http.StatusSeeOther)
+	return nil
+}
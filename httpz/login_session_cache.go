@@ -0,0 +1,362 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/rs/zerolog"
+)
+
+// loginSessionCacheChannel is the PostgreSQL notification channel the database triggers notify when a login session or
+// the user it belongs to changes. The payload is "login_session:<id>", "user:<id>" or "all".
+const loginSessionCacheChannel = "login_session_cache"
+
+// loginSessionCacheReconnectDelay is how long Listen waits before reconnecting after the notification connection fails.
+const loginSessionCacheReconnectDelay = time.Second
+
+// loginSessionRecord is a login session as loaded from the database. Records are not modified after they are cached.
+type loginSessionRecord struct {
+	id                         uuid.UUID
+	persistent                 bool
+	loginTime                  time.Time
+	approximateLastRequestTime time.Time
+	user                       RequestUser
+	impersonatorID             uuid.NullUUID
+	impersonatorUsername       string
+	impersonatorCanImpersonate bool
+}
+
+// loadLoginSessionRecord loads the login session loginSessionID, the user it belongs to and their organizations. It
+// returns pgx.ErrNoRows if the login session does not exist or the user has been suspended.
+func loadLoginSessionRecord(ctx context.Context, dbpool *pgxpool.Pool, loginSessionID uuid.UUID) (*loginSessionRecord, error) {
+	record := &loginSessionRecord{}
+	var impersonatorUsername zeronull.Text
+	err := dbpool.QueryRow(ctx,
+		`select login_sessions.id, login_sessions.persistent, login_sessions.login_time, login_sessions.approximate_last_request_time, users.id, users.username,
+	`+userPermissionsSQL+`,
+	users.email is null or users.email_verified_time is not null, user_passwords.temporary_expire_time is not null,
+	users.current_organization_id, users.distance_unit,
+	impersonators.id, impersonators.username,
+	exists (
+		select 1
+		from user_roles
+			join role_permissions on user_roles.role_id = role_permissions.role_id
+		where user_roles.user_id = impersonators.id
+			and role_permissions.permission = $2
+	)
+from login_sessions
+	join users on login_sessions.user_id=users.id
+	left join user_passwords on users.id=user_passwords.user_id
+	left join login_sessions impersonator_login_sessions on login_sessions.impersonator_login_session_id=impersonator_login_sessions.id
+	left join users impersonators on impersonator_login_sessions.user_id=impersonators.id
+where login_sessions.id=$1
+	and users.disabled_at is null`,
+		loginSessionID, db.PermissionUsersImpersonate,
+	).Scan(&record.id, &record.persistent, &record.loginTime, &record.approximateLastRequestTime,
+		&record.user.ID, &record.user.Username, &record.user.Permissions, &record.user.EmailVerified, &record.user.PasswordChangeRequired,
+		&record.user.CurrentOrganizationID, &record.user.DistanceUnit, &record.impersonatorID, &impersonatorUsername, &record.impersonatorCanImpersonate)
+	if err != nil {
+		return nil, err
+	}
+	record.impersonatorUsername = string(impersonatorUsername)
+
+	record.user.Organizations, err = pgxutil.Select(ctx, dbpool,
+		`select organizations.id, organizations.name
+from organization_memberships
+	join organizations on organization_memberships.organization_id = organizations.id
+where organization_memberships.user_id = $1
+order by organizations.name`,
+		[]any{record.user.ID},
+		pgx.RowToStructByPos[RequestOrganization],
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+type loginSessionCacheEntry struct {
+	record     *loginSessionRecord
+	expireTime time.Time
+}
+
+// LoginSessionCache caches login sessions in memory so loginSessionHandler does not query the database on every
+// request. Database triggers send a notification whenever a login session or its user changes and Listen
+// removes the affected entries, so every instance of the application sees the change.
+//
+// Notifications sent while Listen is not connected are lost. Entries are only kept for the short disconnectedTTL until
+// Listen is connected, and the whole cache is cleared whenever Listen connects.
+//
+// A nil *LoginSessionCache is valid and caches nothing.
+type LoginSessionCache struct {
+	ttl             time.Duration
+	disconnectedTTL time.Duration
+
+	listening atomic.Bool
+
+	mutex   sync.Mutex
+	entries map[uuid.UUID]*loginSessionCacheEntry
+
+	// generation is incremented whenever entries are invalidated. A record loaded before an invalidation that affects it
+	// is not cached as it may have been loaded before the change the invalidation was for. Invalidations are tracked
+	// per login session and per user so a load is not discarded because of an unrelated change.
+	generation uint64
+
+	// loginSessionInvalidations and userInvalidations are the generations at which login sessions and users were last
+	// invalidated. clearGeneration is the generation at which all entries were last invalidated.
+	loginSessionInvalidations map[uuid.UUID]uint64
+	userInvalidations         map[uuid.UUID]uint64
+	clearGeneration           uint64
+
+	// Invalidations are forgotten one sweep after they are made. prunedGeneration is the newest forgotten invalidation.
+	// A load started before it is not cached as it cannot be known whether the invalidation affected it.
+	prunedGeneration    uint64
+	lastSweepGeneration uint64
+
+	lastSweepTime time.Time
+}
+
+// NewLoginSessionCache returns a cache that keeps entries for ttl while Listen is connected and disconnectedTTL
+// otherwise.
+func NewLoginSessionCache(ttl, disconnectedTTL time.Duration) *LoginSessionCache {
+	return &LoginSessionCache{
+		ttl:             ttl,
+		disconnectedTTL: disconnectedTTL,
+		entries:         make(map[uuid.UUID]*loginSessionCacheEntry),
+
+		loginSessionInvalidations: make(map[uuid.UUID]uint64),
+		userInvalidations:         make(map[uuid.UUID]uint64),
+	}
+}
+
+// get returns the cached record for loginSessionID. It also returns the current generation to pass to put when the
+// record is not cached.
+func (c *LoginSessionCache) get(loginSessionID uuid.UUID) (*loginSessionRecord, uint64) {
+	if c == nil {
+		return nil, 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := c.entries[loginSessionID]
+	if entry == nil {
+		return nil, c.generation
+	}
+	if time.Now().After(entry.expireTime) {
+		delete(c.entries, loginSessionID)
+		return nil, c.generation
+	}
+
+	return entry.record, c.generation
+}
+
+// put caches record unless record was invalidated since generation was returned by get.
+func (c *LoginSessionCache) put(record *loginSessionRecord, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	ttl := c.disconnectedTTL
+	if c.listening.Load() {
+		ttl = c.ttl
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.invalidatedSince(record, generation) {
+		return
+	}
+
+	now := time.Now()
+	c.entries[record.id] = &loginSessionCacheEntry{record: record, expireTime: now.Add(ttl)}
+
+	// Entries for login sessions that stop making requests are never read again. Periodically remove them and forget
+	// the invalidations made before the previous sweep.
+	if now.Sub(c.lastSweepTime) > c.ttl {
+		for id, entry := range c.entries {
+			if now.After(entry.expireTime) {
+				delete(c.entries, id)
+			}
+		}
+		for id, invalidation := range c.loginSessionInvalidations {
+			if invalidation <= c.lastSweepGeneration {
+				delete(c.loginSessionInvalidations, id)
+			}
+		}
+		for id, invalidation := range c.userInvalidations {
+			if invalidation <= c.lastSweepGeneration {
+				delete(c.userInvalidations, id)
+			}
+		}
+		c.prunedGeneration = c.lastSweepGeneration
+		c.lastSweepGeneration = c.generation
+		c.lastSweepTime = now
+	}
+}
+
+// invalidatedSince returns true if record may have been invalidated since generation. c.mutex must be held.
+func (c *LoginSessionCache) invalidatedSince(record *loginSessionRecord, generation uint64) bool {
+	if c.clearGeneration > generation || c.prunedGeneration > generation {
+		return true
+	}
+	if c.loginSessionInvalidations[record.id] > generation || c.userInvalidations[record.user.ID] > generation {
+		return true
+	}
+	return record.impersonatorID.Valid && c.userInvalidations[record.impersonatorID.UUID] > generation
+}
+
+// remove removes loginSessionID from the cache.
+func (c *LoginSessionCache) remove(loginSessionID uuid.UUID) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, loginSessionID)
+	c.generation++
+	c.loginSessionInvalidations[loginSessionID] = c.generation
+}
+
+// evictLoginSessions removes loginSessionIDs from this instance's login session cache. Handlers call it after changing
+// a login session or the data loaded with it. The database notifies every instance of the change but the notification
+// is asynchronous and is missed while the listener is disconnected. Evicting the entries here ensures the next request
+// handled by this instance sees the change.
+func evictLoginSessions(env *environment, loginSessionIDs ...uuid.UUID) {
+	for _, loginSessionID := range loginSessionIDs {
+		env.loginSessionCache.remove(loginSessionID)
+	}
+}
+
+// removeUser removes all login sessions of userID and all login sessions where userID is the impersonator.
+func (c *LoginSessionCache) removeUser(userID uuid.UUID) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for id, entry := range c.entries {
+		if entry.record.user.ID == userID || (entry.record.impersonatorID.Valid && entry.record.impersonatorID.UUID == userID) {
+			delete(c.entries, id)
+		}
+	}
+	c.generation++
+	c.userInvalidations[userID] = c.generation
+}
+
+// clear removes all entries.
+func (c *LoginSessionCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	clear(c.entries)
+	c.generation++
+	c.clearGeneration = c.generation
+}
+
+// Listen listens for notifications that invalidate cached login sessions until ctx is canceled. It uses a dedicated
+// connection from dbpool and reconnects if the connection fails.
+func (c *LoginSessionCache) Listen(ctx context.Context, dbpool *pgxpool.Pool) {
+	logger := zerolog.Ctx(ctx)
+
+	for {
+		err := c.listen(ctx, dbpool)
+		c.listening.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn().Err(err).Msg("login session cache notification connection failed; using short cache TTL until reconnected")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(loginSessionCacheReconnectDelay):
+		}
+	}
+}
+
+func (c *LoginSessionCache) listen(ctx context.Context, dbpool *pgxpool.Pool) error {
+	poolConn, err := dbpool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The connection is removed from the pool as it is left listening on the channel.
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "listen "+loginSessionCacheChannel)
+	if err != nil {
+		return err
+	}
+
+	// Notifications may have been missed while not listening.
+	c.clear()
+	c.listening.Store(true)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		c.handleNotification(notification)
+	}
+}
+
+func (c *LoginSessionCache) handleNotification(notification *pgconn.Notification) {
+	kind, idStr, _ := strings.Cut(notification.Payload, ":")
+	id, err := uuid.FromString(idStr)
+	if err != nil {
+		// "all" is sent when a change may affect any user such as a change to a role's permissions. Clearing the cache
+		// is also the safe response to an unknown payload.
+		c.clear()
+		return
+	}
+
+	switch kind {
+	case "login_session":
+		c.remove(id)
+	case "user":
+		c.removeUser(id)
+	default:
+		c.clear()
+	}
+}
+
+// errLoginSessionNotFound is returned by touchLoginSession when the login session no longer exists.
+var errLoginSessionNotFound = errors.New("login session not found")
+
+// touchLoginSession updates the approximate last request time of the login session loginSessionID.
+func touchLoginSession(ctx context.Context, dbpool *pgxpool.Pool, loginSessionID uuid.UUID, now time.Time) error {
+	commandTag, err := dbpool.Exec(ctx, "update login_sessions set approximate_last_request_time=$1 where id=$2", now, loginSessionID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() == 0 {
+		return errLoginSessionNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/web-starter-app/db"
+)
+
+// errLoginLocked is shown instead of checking credentials while login attempts are throttled. It is shown whether or not
+// the username exists so it does not reveal which usernames exist.
+var errLoginLocked = errors.New("Too many failed login attempts. Try again later.")
+
+// errPasswordResetThrottled is shown when too many password reset links have been requested for an email address or from
+// an IP address. It is shown whether or not the email address belongs to a user.
+var errPasswordResetThrottled = errors.New("Too many password reset requests. Try again later.")
+
+// clientIP returns the IP address of the client making r. realip.Handler has already replaced r.RemoteAddr with the
+// address from the X-Forwarded-For or X-Real-IP header when the request came from a trusted proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// knownDeviceCookieName is the name of the cookie that remembers the last user that logged in with a browser. A locked
+// username is still allowed to log in from a browser it has logged in with before. Someone guessing a user's password
+// can then lock the user out of new browsers but not the ones they already use. Failures from the browser's IP address
+// are still throttled.
+const knownDeviceCookieName = "known_device"
+
+// setKnownDeviceCookie remembers that userID logged in with the browser making r.
+func setKnownDeviceCookie(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	ctx := r.Context()
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+	cookie := *env.sessionCookieTemplate
+	cookie.Name = knownDeviceCookieName
+	cookie.Expires = time.Now().Add(env.persistentLoginSessionMaxLifetime)
+
+	var err error
+	cookie.Value, err = env.secureCookie.Encode(cookie.Name, userID)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &cookie)
+
+	return nil
+}
+
+// knownDeviceUserID returns the user that last logged in with the browser making r. It returns uuid.Nil if there is no
+// such user.
+func knownDeviceUserID(r *http.Request) uuid.UUID {
+	env := r.Context().Value(ctxKeyEnvironment).(*environment)
+
+	cookie, err := r.Cookie(knownDeviceCookieName)
+	if err != nil {
+		return uuid.Nil
+	}
+
+	var userID uuid.UUID
+	err = env.secureCookie.Decode(knownDeviceCookieName, cookie.Value, &userID)
+	if err != nil {
+		return uuid.Nil
+	}
+
+	return userID
+}
+
+// loginLockedUntil returns the time until which login attempts for username from r are refused. It returns the zero
+// time if login attempts are allowed. A lock on username does not apply to a browser username has logged in with
+// before.
+func loginLockedUntil(ctx context.Context, env *environment, r *http.Request, username string) (time.Time, error) {
+	ip := clientIP(r)
+
+	if knownUserID := knownDeviceUserID(r); knownUserID != uuid.Nil {
+		user, err := db.GetUserByUsername(ctx, env.dbpool, username)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, err
+		}
+		if err == nil && user.ID == knownUserID {
+			return db.GetClientIPLoginLockedUntil(ctx, env.dbpool, ip)
+		}
+	}
+
+	return db.GetLoginLockedUntil(ctx, env.dbpool, username, ip)
+}
@@ -0,0 +1,100 @@
+package httpz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/a-h/templ"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/lib/mail"
+	"github.com/jackc/web-starter-app/view"
+)
+
+// sendEmail renders textBody and htmlBody and sends them to to.
+func sendEmail(ctx context.Context, env *environment, to, subject string, textBody, htmlBody templ.Component) error {
+	textBuf := &bytes.Buffer{}
+	err := textBody.Render(ctx, textBuf)
+	if err != nil {
+		return err
+	}
+
+	htmlBuf := &bytes.Buffer{}
+	err = htmlBody.Render(ctx, htmlBuf)
+	if err != nil {
+		return err
+	}
+
+	return env.mailer.Send(ctx, &mail.Message{
+		From:     env.mailFrom,
+		To:       to,
+		Subject:  subject,
+		TextBody: textBuf.String(),
+		HTMLBody: htmlBuf.String(),
+	})
+}
+
+// sendEmailVerification sends a link to email that verifies that the user owns it. If email has already been verified by
+// another user, its owner is told that someone tried to use it instead so the response to the request is the same.
+func sendEmailVerification(ctx context.Context, env *environment, userID uuid.UUID, username, email string) error {
+	owner, err := db.GetUserByVerifiedEmail(ctx, env.dbpool, email)
+	if err == nil && owner.ID != userID {
+		loginURL := env.baseURL + "/login"
+		return sendEmail(
+			ctx,
+			env,
+			string(owner.Email),
+			"Your email address is already registered",
+			view.EmailAlreadyRegisteredEmailText(owner.Username, loginURL),
+			view.EmailAlreadyRegisteredEmailHTML(owner.Username, loginURL),
+		)
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	token, err := db.CreateEmailVerificationToken(ctx, env.dbpool, userID, email)
+	if err != nil {
+		return err
+	}
+
+	verifyURL := env.baseURL + "/verify_email/confirm?" + url.Values{"token": {token}}.Encode()
+	return sendEmail(
+		ctx,
+		env,
+		email,
+		"Confirm your email address",
+		view.EmailVerificationEmailText(username, verifyURL),
+		view.EmailVerificationEmailHTML(username, verifyURL),
+	)
+}
+
+// sendPasswordResetEmail sends a password reset link to the user with the verified email address. Nothing is sent if
+// there is no such user.
+func sendPasswordResetEmail(ctx context.Context, env *environment, email string) error {
+	user, err := db.GetUserByVerifiedEmail(ctx, env.dbpool, email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := db.CreatePasswordResetToken(ctx, env.dbpool, user.ID)
+	if err != nil {
+		return err
+	}
+
+	resetURL := env.baseURL + "/reset_password?" + url.Values{"token": {token}}.Encode()
+	return sendEmail(
+		ctx,
+		env,
+		string(user.Email),
+		"Reset your password",
+		view.PasswordResetEmailText(user.Username, resetURL),
+		view.PasswordResetEmailHTML(user.Username, resetURL),
+	)
+}
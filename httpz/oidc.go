@@ -0,0 +1,360 @@
+package httpz
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig configures single sign-on with an OpenID Connect identity provider.
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+
+	// DisplayName is the name of the identity provider shown on the login page.
+	DisplayName string
+
+	Scopes []string
+
+	// UsernameClaim and EmailClaim are the ID token claims that hold the username and email address of a user.
+	UsernameClaim string
+	EmailClaim    string
+
+	// AutoProvision creates a user the first time an identity that is not linked to a user logs in.
+	AutoProvision bool
+
+	// LinkByEmail links an identity that is not linked to a user to the user with the same verified email address. The
+	// identity provider must also report the email address as verified.
+	LinkByEmail bool
+}
+
+const (
+	oidcLoginCookieName = "web-starter-app-oidc-login"
+	oidcLoginLifetime   = 10 * time.Minute
+)
+
+// oidcLogin is the state of a login with the identity provider between redirecting to the identity provider and the
+// identity provider redirecting back.
+type oidcLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	RememberMe   bool
+	ExpiresAt    time.Time
+}
+
+// oidcClient is a client of the OpenID Connect identity provider. The identity provider's configuration is discovered
+// on first use so the application can start while the identity provider is unavailable.
+type oidcClient struct {
+	config      *OIDCConfig
+	redirectURL string
+
+	mutex        sync.Mutex
+	oauth2Config *oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+}
+
+func newOIDCClient(config *OIDCConfig, redirectURL string) *oidcClient {
+	return &oidcClient{config: config, redirectURL: redirectURL}
+}
+
+// discover returns the OAuth2 configuration and ID token verifier for the identity provider.
+func (c *oidcClient) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.oauth2Config == nil {
+		provider, err := oidc.NewProvider(ctx, c.config.IssuerURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("discover OpenID Connect provider: %w", err)
+		}
+
+		scopes := c.config.Scopes
+		if !slices.Contains(scopes, oidc.ScopeOpenID) {
+			scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+		}
+
+		c.oauth2Config = &oauth2.Config{
+			ClientID:     c.config.ClientID,
+			ClientSecret: c.config.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  c.redirectURL,
+			Scopes:       scopes,
+		}
+		c.verifier = provider.Verifier(&oidc.Config{ClientID: c.config.ClientID})
+	}
+
+	return c.oauth2Config, c.verifier, nil
+}
+
+// oidcIdentity is an identity asserted by the identity provider.
+type oidcIdentity struct {
+	Issuer        string
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified bool
+}
+
+// authCodeURL starts a login and returns the URL to redirect the user to. The login state is stored in a cookie.
+func (c *oidcClient) authCodeURL(w http.ResponseWriter, r *http.Request, rememberMe bool) (string, error) {
+	ctx := r.Context()
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+
+	oauth2Config, _, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	login := &oidcLogin{
+		CodeVerifier: oauth2.GenerateVerifier(),
+		RememberMe:   rememberMe,
+		ExpiresAt:    time.Now().Add(oidcLoginLifetime),
+	}
+	login.State, err = randomURLSafeString()
+	if err != nil {
+		return "", err
+	}
+	login.Nonce, err = randomURLSafeString()
+	if err != nil {
+		return "", err
+	}
+
+	cookie := *env.sessionCookieTemplate
+	cookie.Name = oidcLoginCookieName
+	cookie.Value, err = env.secureCookie.Encode(cookie.Name, login)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &cookie)
+
+	return oauth2Config.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.CodeVerifier)), nil
+}
+
+// errOIDCLoginFailed is returned when the identity provider's response to a login is invalid. The user can start over.
+var errOIDCLoginFailed = errors.New("Single sign-on failed. Please try again.")
+
+// finishLogin completes a login started by authCodeURL when the identity provider redirects back. The login state cookie
+// is cleared so it cannot be reused.
+func (c *oidcClient) finishLogin(w http.ResponseWriter, r *http.Request) (*oidcIdentity, *oidcLogin, error) {
+	ctx := r.Context()
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+
+	clearCookie := *env.sessionCookieTemplate
+	clearCookie.Name = oidcLoginCookieName
+	clearCookie.Expires = time.Unix(0, 0)
+	http.SetCookie(w, &clearCookie)
+
+	cookie, err := r.Cookie(oidcLoginCookieName)
+	if err != nil {
+		return nil, nil, errOIDCLoginFailed
+	}
+
+	login := &oidcLogin{}
+	err = env.secureCookie.Decode(oidcLoginCookieName, cookie.Value, login)
+	if err != nil {
+		env.logger.Warn().Err(err).Msg("error decoding OpenID Connect login cookie")
+		return nil, nil, errOIDCLoginFailed
+	}
+
+	query := r.URL.Query()
+	if time.Now().After(login.ExpiresAt) || query.Get("state") != login.State {
+		return nil, nil, errOIDCLoginFailed
+	}
+	if errorCode := query.Get("error"); errorCode != "" {
+		env.logger.Info().Str("error", errorCode).Str("error_description", query.Get("error_description")).Msg("OpenID Connect provider returned error")
+		return nil, nil, errOIDCLoginFailed
+	}
+
+	oauth2Config, verifier, err := c.discover(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, err := oauth2Config.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(login.CodeVerifier))
+	if err != nil {
+		env.logger.Info().Err(err).Msg("OpenID Connect code exchange failed")
+		return nil, nil, errOIDCLoginFailed
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, nil, errOIDCLoginFailed
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		env.logger.Info().Err(err).Msg("OpenID Connect ID token verification failed")
+		return nil, nil, errOIDCLoginFailed
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, nil, errOIDCLoginFailed
+	}
+
+	var claims map[string]any
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, nil, errOIDCLoginFailed
+	}
+
+	identity := &oidcIdentity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+	}
+	identity.Username, _ = claims[c.config.UsernameClaim].(string)
+	identity.Email, _ = claims[c.config.EmailClaim].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+
+	return identity, login, nil
+}
+
+// user returns the user identity is linked to. An identity that is not linked to a user is linked to the user with the
+// same verified email address or a new user is created if configured to do so. loginErr is suitable for showing to the
+// user and is set when there is no user for identity. err is only non-nil if the lookup itself failed.
+func (c *oidcClient) user(ctx context.Context, dbpool *pgxpool.Pool, identity *oidcIdentity) (user *db.User, loginErr error, err error) {
+	// Only an email address the identity provider has verified is trusted.
+	var verifiedEmail string
+	if identity.EmailVerified {
+		verifiedEmail = identity.Email
+	}
+
+	user, err = db.RecordUserIdentityLogin(ctx, dbpool, identity.Issuer, identity.Subject, verifiedEmail)
+	if err == nil {
+		return user, nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, err
+	}
+
+	if c.config.LinkByEmail && verifiedEmail != "" {
+		user, err = db.GetUserByVerifiedEmail(ctx, dbpool, verifiedEmail)
+		if err == nil {
+			err = db.LinkUserIdentity(ctx, dbpool, user.ID, identity.Issuer, identity.Subject, verifiedEmail)
+			if err != nil {
+				return nil, nil, err
+			}
+			return user, nil, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, err
+		}
+	}
+
+	if !c.config.AutoProvision {
+		return nil, errors.New("No account is linked to this identity"), nil
+	}
+
+	userID := uuid.Must(uuid.NewV7())
+	validationErrors, err := validateUsernameAndEmail(ctx, dbpool, identity.Username, verifiedEmail, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if validationErrors != nil {
+		return nil, fmt.Errorf("Unable to create an account: %w", validationErrors.AllErrors()[0]), nil
+	}
+
+	err = db.CreateUserWithIdentity(ctx, dbpool, userID, identity.Username, verifiedEmail, identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err = db.GetUserByID(ctx, dbpool, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, nil, nil
+}
+
+// randomURLSafeString returns a random string suitable for use as an OAuth2 state or OpenID Connect nonce.
+func randomURLSafeString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// handleLoginOIDC redirects to the identity provider to log in.
+func handleLoginOIDC(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	authCodeURL, err := env.oidc.authCodeURL(w, r, r.FormValue("rememberMe") == "1")
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, authCodeURL, http.StatusSeeOther)
+	return nil
+}
+
+// handleLoginOIDCCallback completes an OpenID Connect login when the identity provider redirects back.
+func handleLoginOIDCCallback(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	identity, login, err := env.oidc.finishLogin(w, r)
+	if err != nil {
+		if errors.Is(err, errOIDCLoginFailed) {
+			loginErrors := &errortree.Node{}
+			loginErrors.Add(nil, err)
+			return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+		}
+		return err
+	}
+
+	user, loginErr, err := env.oidc.user(ctx, env.dbpool, identity)
+	if err != nil {
+		return err
+	}
+	if loginErr != nil {
+		loginErrors := &errortree.Node{}
+		loginErrors.Add(nil, loginErr)
+		return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+	}
+	if user.Disabled {
+		loginErrors := &errortree.Node{}
+		loginErrors.Add(nil, errUserSuspended)
+		return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+	}
+
+	// The identity provider replaces the password but not the user's own second factor.
+	twoFactorEnabled, err := db.UserTwoFactorEnabled(ctx, env.dbpool, user.ID)
+	if err != nil {
+		return err
+	}
+	twoFactorRequired, err := db.UserTwoFactorRequired(ctx, env.dbpool, user.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactorEnabled || twoFactorRequired {
+		err = setPendingTwoFactorLoginCookie(w, r, user.ID, login.RememberMe)
+		if err != nil {
+			return err
+		}
+
+		http.Redirect(w, r, "/login/two_factor", http.StatusSeeOther)
+		return nil
+	}
+
+	err = createLoginSession(ctx, w, r, user.ID, login.RememberMe)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
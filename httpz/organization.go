@@ -0,0 +1,517 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+)
+
+// userOrganizationNamesSQL is a SQL expression for the comma separated organization names and roles of the user in the
+// users table.
+const userOrganizationNamesSQL = `coalesce((
+	select string_agg(organizations.name || ' (' || organization_memberships.role || ')', ', ' order by organizations.name)
+	from organization_memberships
+		join organizations on organization_memberships.organization_id = organizations.id
+	where organization_memberships.user_id = users.id
+), '')`
+
+// currentWalkLogCondition returns a SQL condition on walks with the parameter $1 for the walk log user is working with.
+// organizationName is empty for the user's personal walk log.
+func currentWalkLogCondition(user *RequestUser) (condition string, arg any, organizationName string) {
+	if organization := user.CurrentOrganization(); organization != nil {
+		return "walks.organization_id = $1", organization.ID, organization.Name
+	}
+	return "walks.user_id = $1", user.ID, ""
+}
+
+// walkOrganizations returns the organizations user can share a walk with.
+func walkOrganizations(user *RequestUser) []view.WalkOrganization {
+	organizations := make([]view.WalkOrganization, 0, len(user.Organizations))
+	for _, organization := range user.Organizations {
+		organizations = append(organizations, view.WalkOrganization{ID: organization.ID, Name: organization.Name})
+	}
+	return organizations
+}
+
+// validateWalkOrganization parses the organization a walk is shared with from s. It adds an error to validationErrors if
+// userID is not a member of the organization. An empty s is a walk that is not shared.
+func validateWalkOrganization(ctx context.Context, tx pgx.Tx, userID uuid.UUID, s string, validationErrors *errortree.Node) (uuid.NullUUID, error) {
+	if s == "" {
+		return uuid.NullUUID{}, nil
+	}
+
+	organizationID, err := uuid.FromString(s)
+	if err != nil {
+		validationErrors.Add([]any{"organizationID"}, errors.New("Invalid team"))
+		return uuid.NullUUID{}, nil
+	}
+
+	_, err = db.GetOrganizationRole(ctx, tx, organizationID, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			validationErrors.Add([]any{"organizationID"}, errors.New("Invalid team"))
+			return uuid.NullUUID{}, nil
+		}
+		return uuid.NullUUID{}, err
+	}
+
+	return uuid.NullUUID{UUID: organizationID, Valid: true}, nil
+}
+
+// selectOrganizationPage selects the organization organizationID and its members and invitations for
+// view.OrganizationsShowPage. It returns pgx.ErrNoRows if userID is not a member of the organization.
+func selectOrganizationPage(ctx context.Context, dbpool *pgxpool.Pool, organizationID, userID uuid.UUID) (*view.OrganizationsPageOrganization, []*view.OrganizationsPageMember, []*view.OrganizationsPageInvitation, error) {
+	organization, err := pgxutil.SelectRow(ctx, dbpool,
+		`select organizations.id, organizations.name, organization_memberships.role,
+	(select count(*) from organization_memberships members where members.organization_id = organizations.id)
+from organizations
+	join organization_memberships on organizations.id = organization_memberships.organization_id
+where organizations.id = $1
+	and organization_memberships.user_id = $2`,
+		[]any{organizationID, userID},
+		pgx.RowToAddrOfStructByPos[view.OrganizationsPageOrganization],
+	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	members, err := pgxutil.Select(ctx, dbpool,
+		`select users.id, users.username, organization_memberships.role
+from organization_memberships
+	join users on organization_memberships.user_id = users.id
+where organization_memberships.organization_id = $1
+order by users.username`,
+		[]any{organizationID},
+		pgx.RowToAddrOfStructByPos[view.OrganizationsPageMember],
+	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var invitations []*view.OrganizationsPageInvitation
+	if organization.Role == db.OrganizationRoleOwner {
+		invitations, err = pgxutil.Select(ctx, dbpool,
+			`select id, expiration_time
+from organization_invitations
+where organization_id = $1
+	and expiration_time > now()
+order by insert_time`,
+			[]any{organizationID},
+			pgx.RowToAddrOfStructByPos[view.OrganizationsPageInvitation],
+		)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return organization, members, invitations, nil
+}
+
+// renderOrganizationsShowPage renders organizationID for userID. It returns pgx.ErrNoRows if userID is not a member of
+// the organization.
+func renderOrganizationsShowPage(ctx context.Context, w http.ResponseWriter, env *environment, organizationID, userID uuid.UUID, invitationURL string, validationErrors *errortree.Node) error {
+	organization, members, invitations, err := selectOrganizationPage(ctx, env.dbpool, organizationID, userID)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.OrganizationsShowPage(organization, members, invitations, invitationURL, userID, validationErrors)).Render(ctx, w)
+}
+
+// isOrganizationOwner returns whether userID is an owner of organizationID. It returns pgx.ErrNoRows if userID is not a
+// member of the organization.
+func isOrganizationOwner(ctx context.Context, env *environment, organizationID, userID uuid.UUID) (bool, error) {
+	role, err := db.GetOrganizationRole(ctx, env.dbpool, organizationID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role == db.OrganizationRoleOwner, nil
+}
+
+// handleCurrentOrganizationUpdate switches the walk log the current user is working with.
+func handleCurrentOrganizationUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	var organizationID uuid.NullUUID
+	if s, _ := params["organizationID"].(string); s != "" {
+		id, err := uuid.FromString(s)
+		if err != nil {
+			return err
+		}
+		organizationID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	err := db.SetCurrentOrganization(ctx, env.dbpool, loginSession.User.ID, organizationID)
+	if err != nil {
+		return err
+	}
+	evictLoginSessions(env, loginSession.ID)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
+
+// handleOrganizations lists the organizations the current user is a member of.
+func handleOrganizations(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	organizations, err := pgxutil.Select(ctx, env.dbpool,
+		`select organizations.id, organizations.name, organization_memberships.role,
+	(select count(*) from organization_memberships members where members.organization_id = organizations.id)
+from organizations
+	join organization_memberships on organizations.id = organization_memberships.organization_id
+where organization_memberships.user_id = $1
+order by organizations.name`,
+		[]any{getLoginSession(ctx).User.ID},
+		pgx.RowToAddrOfStructByPos[view.OrganizationsPageOrganization],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.OrganizationsPage(organizations)).Render(ctx, w)
+}
+
+// handleOrganizationsNew renders the new organization form.
+func handleOrganizationsNew(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	return view.ApplicationLayout(view.OrganizationsNewPage(&view.OrganizationFormFields{}, nil)).Render(ctx, w)
+}
+
+// handleOrganizationsCreate creates an organization owned by the current user.
+func handleOrganizationsCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := view.OrganizationFormFields{}
+	err := structify.Parse(params, &formData)
+	if err != nil {
+		return err
+	}
+	formData.Name = strings.TrimSpace(formData.Name)
+
+	if formData.Name == "" {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"name"}, errors.New("Name is required"))
+		return view.ApplicationLayout(view.OrganizationsNewPage(&formData, validationErrors)).Render(ctx, w)
+	}
+
+	loginSession := getLoginSession(ctx)
+	organizationID, err := db.CreateOrganization(ctx, env.dbpool, formData.Name, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	evictLoginSessions(env, loginSession.ID)
+
+	http.Redirect(w, r, "/organizations/"+organizationID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleOrganizationsShow shows an organization to one of its members.
+func handleOrganizationsShow(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	organizationID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	return renderOrganizationsShowPage(ctx, w, env, organizationID, getLoginSession(ctx).User.ID, "", nil)
+}
+
+// handleOrganizationsInvitationsCreate creates an invitation link for an organization.
+func handleOrganizationsInvitationsCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	organizationID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	role, err := db.GetOrganizationRole(ctx, env.dbpool, organizationID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	if role != db.OrganizationRoleOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	token, err := db.CreateOrganizationInvitation(ctx, env.dbpool, organizationID)
+	if err != nil {
+		return err
+	}
+
+	invitationURL := env.baseURL + "/organization_invitations/" + token
+	return renderOrganizationsShowPage(ctx, w, env, organizationID, loginSession.User.ID, invitationURL, nil)
+}
+
+// handleOrganizationsInvitationsDelete revokes an invitation link.
+func handleOrganizationsInvitationsDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	organizationID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+	invitationID, err := uuid.FromString(params["invitationID"].(string))
+	if err != nil {
+		return err
+	}
+
+	role, err := db.GetOrganizationRole(ctx, env.dbpool, organizationID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	if role != db.OrganizationRoleOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	_, err = pgxutil.ExecRow(ctx, env.dbpool, "delete from organization_invitations where id = $1 and organization_id = $2", invitationID, organizationID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/organizations/"+organizationID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleOrganizationsMembersDelete removes a member from an organization or lets the current user leave it.
+func handleOrganizationsMembersDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	organizationID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+	memberID, err := uuid.FromString(params["userID"].(string))
+	if err != nil {
+		return err
+	}
+
+	// Members can leave. Only owners can remove someone else.
+	role, err := db.GetOrganizationRole(ctx, env.dbpool, organizationID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	if memberID != loginSession.User.ID && role != db.OrganizationRoleOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	err = db.RemoveOrganizationMember(ctx, env.dbpool, organizationID, memberID)
+	if err != nil {
+		if errors.Is(err, db.ErrLastOrganizationOwner) {
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"action"}, errors.New("An organization must have an owner"))
+			w.WriteHeader(http.StatusConflict)
+			return renderOrganizationsShowPage(ctx, w, env, organizationID, loginSession.User.ID, "", validationErrors)
+		}
+		return err
+	}
+
+	if memberID == loginSession.User.ID {
+		evictLoginSessions(env, loginSession.ID)
+		http.Redirect(w, r, "/organizations", http.StatusSeeOther)
+		return nil
+	}
+	http.Redirect(w, r, "/organizations/"+organizationID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleOrganizationsEdit renders the rename organization form.
+func handleOrganizationsEdit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	organizationID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	isOwner, err := isOrganizationOwner(ctx, env, organizationID, getLoginSession(ctx).User.ID)
+	if err != nil {
+		return err
+	}
+	if !isOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	formData := view.OrganizationFormFields{}
+	err = env.dbpool.QueryRow(ctx, "select name from organizations where id = $1", organizationID).Scan(&formData.Name)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.OrganizationsEditPage(organizationID, &formData, nil)).Render(ctx, w)
+}
+
+// handleOrganizationsUpdate renames an organization.
+func handleOrganizationsUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	organizationID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	isOwner, err := isOrganizationOwner(ctx, env, organizationID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	if !isOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	formData := view.OrganizationFormFields{}
+	err = structify.Parse(params, &formData)
+	if err != nil {
+		return err
+	}
+	formData.Name = strings.TrimSpace(formData.Name)
+
+	if formData.Name == "" {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"name"}, errors.New("Name is required"))
+		return view.ApplicationLayout(view.OrganizationsEditPage(organizationID, &formData, validationErrors)).Render(ctx, w)
+	}
+
+	_, err = pgxutil.ExecRow(ctx, env.dbpool, "update organizations set name = $2 where id = $1", organizationID, formData.Name)
+	if err != nil {
+		return err
+	}
+	evictLoginSessions(env, loginSession.ID)
+
+	http.Redirect(w, r, "/organizations/"+organizationID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleOrganizationsDelete deletes an organization.
+func handleOrganizationsDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	organizationID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	isOwner, err := isOrganizationOwner(ctx, env, organizationID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	if !isOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	err = db.DeleteOrganization(ctx, env.dbpool, organizationID)
+	if err != nil {
+		return err
+	}
+	evictLoginSessions(env, loginSession.ID)
+
+	http.Redirect(w, r, "/organizations", http.StatusSeeOther)
+	return nil
+}
+
+// handleOrganizationsMembersRoleUpdate changes the role of a member of an organization.
+func handleOrganizationsMembersRoleUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	organizationID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+	memberID, err := uuid.FromString(params["userID"].(string))
+	if err != nil {
+		return err
+	}
+
+	isOwner, err := isOrganizationOwner(ctx, env, organizationID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	if !isOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	role, _ := params["role"].(string)
+	if role != db.OrganizationRoleOwner && role != db.OrganizationRoleMember {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil
+	}
+
+	err = db.SetOrganizationMemberRole(ctx, env.dbpool, organizationID, memberID, role)
+	if err != nil {
+		if errors.Is(err, db.ErrLastOrganizationOwner) {
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"action"}, errors.New("An organization must have an owner"))
+			w.WriteHeader(http.StatusConflict)
+			return renderOrganizationsShowPage(ctx, w, env, organizationID, loginSession.User.ID, "", validationErrors)
+		}
+		return err
+	}
+
+	http.Redirect(w, r, "/organizations/"+organizationID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleOrganizationInvitation shows an invitation to join an organization.
+func handleOrganizationInvitation(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	// The token is in the URL. Do not leak it to other sites through the Referer header.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	token := params["token"].(string)
+	invitation, err := db.GetOrganizationInvitation(ctx, env.dbpool, token)
+	if err != nil {
+		if errors.Is(err, db.ErrOrganizationInvitationInvalid) {
+			return view.ApplicationLayout(view.OrganizationInvitationInvalidPage()).Render(ctx, w)
+		}
+		return err
+	}
+
+	return view.ApplicationLayout(view.OrganizationInvitationPage(invitation.OrganizationName, token)).Render(ctx, w)
+}
+
+// handleOrganizationInvitationAccept adds the current user to the organization of an invitation.
+func handleOrganizationInvitationAccept(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+	_, err := db.AcceptOrganizationInvitation(ctx, env.dbpool, params["token"].(string), loginSession.User.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrOrganizationInvitationInvalid) {
+			return view.ApplicationLayout(view.OrganizationInvitationInvalidPage()).Render(ctx, w)
+		}
+		return err
+	}
+	evictLoginSessions(env, loginSession.ID)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
+
+// handleSystemOrganizations lists all organizations.
+func handleSystemOrganizations(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	organizations, err := pgxutil.Select(ctx, env.dbpool,
+		`select organizations.id, organizations.name,
+	coalesce((
+		select string_agg(users.username, ', ' order by users.username)
+		from organization_memberships
+	join users on organization_memberships.user_id = users.id
+		where organization_memberships.organization_id = organizations.id
+	and organization_memberships.role = $1
+	), ''),
+	(select count(*) from organization_memberships where organization_id = organizations.id)
+from organizations
+order by organizations.name`,
+		[]any{db.OrganizationRoleOwner},
+		pgx.RowToAddrOfStructByPos[view.SystemOrganizationsPageOrganization],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.SystemOrganizationsPage(organizations)).Render(ctx, w)
+}
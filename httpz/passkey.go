@@ -0,0 +1,295 @@
+package httpz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+	"github.com/rs/zerolog"
+)
+
+const webAuthnSessionCookieName = "web-starter-app-webauthn-session"
+
+// webAuthnUser adapts a user to the webauthn.User interface.
+type webAuthnUser struct {
+	id          uuid.UUID
+	username    string
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID returns the user handle. The user ID is used directly as it is already an opaque, unique value.
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.id.Bytes()
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.username
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.username
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// loadWebAuthnUser loads a user and their WebAuthn credentials.
+func loadWebAuthnUser(ctx context.Context, dbconn pgxutil.DB, userID uuid.UUID) (*webAuthnUser, error) {
+	user, err := db.GetUserByID(ctx, dbconn, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := db.GetWebAuthnCredentials(ctx, dbconn, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webAuthnUser{
+		id:          user.ID,
+		username:    user.Username,
+		credentials: credentials,
+	}, nil
+}
+
+// setWebAuthnSessionCookie stores the WebAuthn ceremony session data in a cookie between the begin and finish requests.
+func setWebAuthnSessionCookie(w http.ResponseWriter, r *http.Request, sessionData *webauthn.SessionData) error {
+	ctx := r.Context()
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+	cookie := *env.sessionCookieTemplate
+	cookie.Name = webAuthnSessionCookieName
+
+	sessionDataJSON, err := json.Marshal(sessionData)
+	if err != nil {
+		return err
+	}
+
+	cookie.Value, err = env.secureCookie.Encode(cookie.Name, sessionDataJSON)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &cookie)
+
+	return nil
+}
+
+// takeWebAuthnSessionCookie returns the WebAuthn ceremony session data from the request cookie and clears the cookie in
+// the response so the challenge cannot be reused. It returns false if there is no valid session data.
+func takeWebAuthnSessionCookie(w http.ResponseWriter, r *http.Request) (*webauthn.SessionData, bool) {
+	ctx := r.Context()
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+
+	clearCookie := *env.sessionCookieTemplate
+	clearCookie.Name = webAuthnSessionCookieName
+	clearCookie.Expires = time.Unix(0, 0)
+	http.SetCookie(w, &clearCookie)
+
+	cookie, err := r.Cookie(webAuthnSessionCookieName)
+	if err != nil {
+		return nil, false
+	}
+
+	var sessionDataJSON []byte
+	err = env.secureCookie.Decode(webAuthnSessionCookieName, cookie.Value, &sessionDataJSON)
+	if err != nil {
+		env.logger.Warn().Err(err).Msg("error decoding WebAuthn session cookie")
+		return nil, false
+	}
+
+	sessionData := &webauthn.SessionData{}
+	err = json.Unmarshal(sessionDataJSON, sessionData)
+	if err != nil {
+		return nil, false
+	}
+
+	return sessionData, true
+}
+
+// writeJSON writes v as a JSON response with status code.
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// handleLoginPasskeyBegin starts a WebAuthn login ceremony.
+func handleLoginPasskeyBegin(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	assertion, sessionData, err := env.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return err
+	}
+
+	err = setWebAuthnSessionCookie(w, r, sessionData)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, assertion)
+}
+
+// handleLoginPasskeyFinish verifies the browser's WebAuthn assertion and logs the user in.
+func handleLoginPasskeyFinish(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	sessionData, ok := takeWebAuthnSessionCookie(w, r)
+	if !ok {
+		return writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Passkey login expired. Please try again."})
+	}
+
+	var user *webAuthnUser
+	credential, err := env.webAuthn.FinishDiscoverableLogin(
+		func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
+			}
+
+			user, err = loadWebAuthnUser(ctx, env.dbpool, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			return user, nil
+		},
+		*sessionData,
+		r,
+	)
+	if err != nil {
+		zerolog.Ctx(ctx).Info().Err(err).Msg("passkey login failed")
+		return writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Passkey login failed"})
+	}
+
+	if credential.Authenticator.CloneWarning {
+		zerolog.Ctx(ctx).Warn().Str("user_id", user.id.String()).Msg("passkey signature counter indicates a cloned authenticator")
+		return writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Passkey login failed"})
+	}
+
+	err = db.UpdateWebAuthnCredentialAfterLogin(ctx, env.dbpool, credential)
+	if err != nil {
+		return err
+	}
+
+	// A passkey with user verification is itself multi-factor so the TOTP step is skipped.
+	err = createLoginSession(ctx, w, r, user.id, r.URL.Query().Get("rememberMe") == "1")
+	if err != nil {
+		if errors.Is(err, errUserSuspended) {
+			return writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		}
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]string{"redirect": "/"})
+}
+
+// handlePasskeys lists the current user's passkeys.
+func handlePasskeys(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	passkeys, err := pgxutil.Select(ctx, env.dbpool,
+		"select id, name, insert_time, last_used_time from webauthn_credentials where user_id = $1 order by insert_time",
+		[]any{loginSession.User.ID},
+		pgx.RowToAddrOfStructByPos[view.PasskeysPagePasskey],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.PasskeysPage(passkeys)).Render(ctx, w)
+}
+
+// handlePasskeysRegistrationBegin starts a WebAuthn registration ceremony for a new passkey.
+func handlePasskeysRegistrationBegin(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	user, err := loadWebAuthnUser(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	excludeCredentials := make([]protocol.CredentialDescriptor, len(user.credentials))
+	for i, c := range user.credentials {
+		excludeCredentials[i] = c.Descriptor()
+	}
+
+	creation, sessionData, err := env.webAuthn.BeginRegistration(
+		user,
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			UserVerification:   protocol.VerificationRequired,
+		}),
+		webauthn.WithExclusions(excludeCredentials),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = setWebAuthnSessionCookie(w, r, sessionData)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, creation)
+}
+
+// handlePasskeysRegistrationFinish verifies the browser's WebAuthn attestation and saves the new passkey.
+func handlePasskeysRegistrationFinish(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	name, _ := params["name"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Name is required"})
+	}
+
+	sessionData, ok := takeWebAuthnSessionCookie(w, r)
+	if !ok {
+		return writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Passkey registration expired. Please try again."})
+	}
+
+	user, err := loadWebAuthnUser(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	credential, err := env.webAuthn.FinishRegistration(user, *sessionData, r)
+	if err != nil {
+		zerolog.Ctx(ctx).Info().Err(err).Msg("passkey registration failed")
+		return writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Passkey registration failed"})
+	}
+
+	err = db.InsertWebAuthnCredential(ctx, env.dbpool, user.id, name, credential)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+// handlePasskeysDelete deletes one of the current user's passkeys.
+func handlePasskeysDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	passkeyID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	_, err = pgxutil.ExecRow(ctx, env.dbpool, "delete from webauthn_credentials where id = $1 and user_id = $2", passkeyID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/passkeys", http.StatusSeeOther)
+	return nil
+}
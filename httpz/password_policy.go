@@ -0,0 +1,42 @@
+package httpz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/web-starter-app/lib/breachedpasswords"
+)
+
+// PasswordPolicy configures the requirements for passwords chosen by users.
+type PasswordPolicy struct {
+	MinLength int
+
+	// BreachedPasswords rejects passwords that are known to have been exposed in data breaches. It is optional.
+	BreachedPasswords *breachedpasswords.List
+}
+
+// validate checks that password satisfies the policy for the user username. policyErr is suitable for showing to the
+// user and is set when password is rejected. err is only non-nil if the check itself failed.
+func (p *PasswordPolicy) validate(username, password string) (policyErr error, err error) {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters", p.MinLength), nil
+	}
+
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("Password must not contain your username"), nil
+	}
+
+	if p.BreachedPasswords != nil {
+		breached, err := p.BreachedPasswords.Contains(password)
+		if err != nil {
+			return nil, fmt.Errorf("check breached passwords: %w", err)
+		}
+		if breached {
+			return errors.New("Password has appeared in a data breach. Please choose a different password"), nil
+		}
+	}
+
+	return nil, nil
+}
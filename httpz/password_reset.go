@@ -0,0 +1,124 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/errortree"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+	"github.com/rs/zerolog"
+)
+
+// handleForgotPassword renders the form to request a password reset email.
+func handleForgotPassword(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := &view.ForgotPasswordFormFields{}
+	return view.ApplicationLayout(view.ForgotPasswordPage(formData, nil)).Render(ctx, w)
+}
+
+// handleForgotPasswordSubmit sends a password reset email if the address belongs to a user.
+func handleForgotPasswordSubmit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := &view.ForgotPasswordFormFields{}
+	err := structify.Parse(params, formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.ForgotPasswordPage(formData, validationErrors)).Render(ctx, w)
+		}
+		return err
+	}
+
+	formData.Email = strings.TrimSpace(formData.Email)
+	if formData.Email == "" {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"email"}, errors.New("Email is required"))
+		return view.ApplicationLayout(view.ForgotPasswordPage(formData, validationErrors)).Render(ctx, w)
+	}
+
+	allowed, err := db.RecordPasswordResetRequest(ctx, env.dbpool, formData.Email, clientIP(r))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"email"}, errPasswordResetThrottled)
+		return view.ApplicationLayout(view.ForgotPasswordPage(formData, validationErrors)).Render(ctx, w)
+	}
+
+	// The response is the same whether or not the email address belongs to a user so it does not reveal which
+	// email addresses have accounts. The email is sent in the background so the response time does not reveal it
+	// either.
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		err := sendPasswordResetEmail(ctx, env, formData.Email)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to send password reset email")
+		}
+	}()
+
+	return view.ApplicationLayout(view.ForgotPasswordSentPage()).Render(ctx, w)
+}
+
+// handleResetPassword renders the form to choose a new password with a reset token.
+func handleResetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	// The token is in the URL. Do not leak it to other sites through the Referer header.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	formData := &view.ResetPasswordFormFields{Token: r.URL.Query().Get("token")}
+	user, err := db.GetPasswordResetTokenUser(ctx, env.dbpool, formData.Token)
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetTokenInvalid) {
+			return view.ApplicationLayout(view.ResetPasswordInvalidPage()).Render(ctx, w)
+		}
+		return err
+	}
+
+	return view.ApplicationLayout(view.ResetPasswordPage(user.Username, formData, nil)).Render(ctx, w)
+}
+
+// handleResetPasswordSubmit sets a new password with a reset token.
+func handleResetPasswordSubmit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	formData := &view.ResetPasswordFormFields{}
+	err := structify.Parse(params, formData)
+	if err != nil {
+		return err
+	}
+
+	user, err := db.GetPasswordResetTokenUser(ctx, env.dbpool, formData.Token)
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetTokenInvalid) {
+			return view.ApplicationLayout(view.ResetPasswordInvalidPage()).Render(ctx, w)
+		}
+		return err
+	}
+
+	if formData.NewPassword == "" {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"newPassword"}, errors.New("New password is required"))
+		return view.ApplicationLayout(view.ResetPasswordPage(user.Username, formData, validationErrors)).Render(ctx, w)
+	}
+
+	policyErr, err := env.passwordPolicy.validate(user.Username, formData.NewPassword)
+	if err != nil {
+		return err
+	}
+	if policyErr != nil {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"newPassword"}, policyErr)
+		return view.ApplicationLayout(view.ResetPasswordPage(user.Username, formData, validationErrors)).Render(ctx, w)
+	}
+
+	err = db.ResetUserPasswordWithToken(ctx, env.dbpool, formData.Token, formData.NewPassword)
+	if err != nil {
+		if errors.Is(err, db.ErrPasswordResetTokenInvalid) {
+			return view.ApplicationLayout(view.ResetPasswordInvalidPage()).Render(ctx, w)
+		}
+		return err
+	}
+
+	return view.ApplicationLayout(view.ResetPasswordCompletePage()).Render(ctx, w)
+}
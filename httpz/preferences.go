@@ -0,0 +1,38 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/errortree"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/lib/distance"
+	"github.com/jackc/web-starter-app/view"
+)
+
+// handlePreferences renders the current user's preferences.
+func handlePreferences(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	return view.ApplicationLayout(view.PreferencesPage(nil)).Render(ctx, w)
+}
+
+// handlePreferencesUpdate updates the current user's preferences.
+func handlePreferencesUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	unit, _ := params["distanceUnit"].(string)
+	if !distance.Unit(unit).Valid() {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"distanceUnit"}, errors.New("Invalid distance unit"))
+		return view.ApplicationLayout(view.PreferencesPage(validationErrors)).Render(ctx, w)
+	}
+
+	err := db.SetUserDistanceUnit(ctx, env.dbpool, loginSession.User.ID, distance.Unit(unit))
+	if err != nil {
+		return err
+	}
+	evictLoginSessions(env, loginSession.ID)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,152 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+	"github.com/rs/zerolog"
+)
+
+// handleRegister renders the registration form.
+func handleRegister(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := &view.RegisterFormFields{}
+	return view.ApplicationLayout(view.RegisterPage(formData, nil)).Render(ctx, w)
+}
+
+// handleRegisterSubmit creates a user from the registration form.
+func handleRegisterSubmit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := &view.RegisterFormFields{}
+	err := structify.Parse(params, formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.RegisterPage(formData, validationErrors)).Render(ctx, w)
+		}
+		return err
+	}
+
+	userID := uuid.Must(uuid.NewV7())
+
+	formData.Email = strings.TrimSpace(formData.Email)
+	validationErrors := &errortree.Node{}
+	usernameErr, err := validateUsername(ctx, env.dbpool, formData.Username, userID)
+	if err != nil {
+		return err
+	}
+	if usernameErr != nil {
+		validationErrors.Add([]any{"username"}, usernameErr)
+	}
+	// Whether the email address already has an account is not checked so registering does not reveal it. The
+	// address is only reserved once it is verified.
+	if formData.Email == "" {
+		validationErrors.Add([]any{"email"}, errors.New("Email is required"))
+	} else if emailErr := validateEmailAddress(formData.Email); emailErr != nil {
+		validationErrors.Add([]any{"email"}, emailErr)
+	}
+	if formData.Password == "" {
+		validationErrors.Add([]any{"password"}, errors.New("Password is required"))
+	} else {
+		policyErr, err := env.passwordPolicy.validate(formData.Username, formData.Password)
+		if err != nil {
+			return err
+		}
+		if policyErr != nil {
+			validationErrors.Add([]any{"password"}, policyErr)
+		}
+	}
+	if len(validationErrors.AllErrors()) > 0 {
+		return view.ApplicationLayout(view.RegisterPage(formData, validationErrors)).Render(ctx, w)
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		err := pgxutil.InsertRow(ctx, tx, "users", map[string]any{
+			"id":       userID,
+			"username": formData.Username,
+			"email":    formData.Email,
+		})
+		if err != nil {
+			return err
+		}
+
+		return db.SetUserPassword(ctx, tx, userID, formData.Password)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The account already exists so the user is still logged in if the email fails. They can resend it from
+	// /verify_email.
+	err = sendEmailVerification(ctx, env, userID, formData.Username, formData.Email)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("user_id", userID.String()).Msg("failed to send email verification")
+	}
+
+	err = createLoginSession(ctx, w, r, userID, false)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/verify_email", http.StatusSeeOther)
+	return nil
+}
+
+// handleVerifyEmailConfirm verifies an email address with the token from a verification email.
+func handleVerifyEmailConfirm(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	// The token is in the URL. Do not leak it to other sites through the Referer header.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	err := db.VerifyUserEmailWithToken(ctx, env.dbpool, r.URL.Query().Get("token"))
+	if err != nil {
+		if errors.Is(err, db.ErrEmailVerificationTokenInvalid) {
+			return view.ApplicationLayout(view.VerifyEmailInvalidPage()).Render(ctx, w)
+		}
+		return err
+	}
+
+	return view.ApplicationLayout(view.VerifyEmailCompletePage()).Render(ctx, w)
+}
+
+// handleVerifyEmail asks the current user to verify their email address.
+func handleVerifyEmail(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+	if loginSession.User.EmailVerified {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return nil
+	}
+
+	user, err := db.GetUserByID(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.VerifyEmailPage(string(user.Email), false)).Render(ctx, w)
+}
+
+// handleVerifyEmailResend sends another verification email to the current user.
+func handleVerifyEmailResend(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+	if loginSession.User.EmailVerified {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return nil
+	}
+
+	user, err := db.GetUserByID(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	err = sendEmailVerification(ctx, env, user.ID, user.Username, string(user.Email))
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.VerifyEmailPage(string(user.Email), true)).Render(ctx, w)
+}
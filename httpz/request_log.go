@@ -0,0 +1,59 @@
+package httpz
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// secretPathPrefixes are the paths where the next path segment is a secret token such as a share link.
+var secretPathPrefixes = []string{"/shared/", "/organization_invitations/"}
+
+// secretQueryParams are the query parameters that contain a secret token.
+var secretQueryParams = []string{"token"}
+
+const redacted = "REDACTED"
+
+// redactURL returns u as a string with secret tokens replaced so they are not written to logs.
+func redactURL(u *url.URL) string {
+	redactedURL := *u
+	for _, prefix := range secretPathPrefixes {
+		if rest, ok := strings.CutPrefix(u.Path, prefix); ok && rest != "" {
+			_, remainder, _ := strings.Cut(rest, "/")
+			redactedURL.Path = prefix + redacted
+			if remainder != "" {
+				redactedURL.Path += "/" + remainder
+			}
+			redactedURL.RawPath = ""
+			break
+		}
+	}
+
+	query := u.Query()
+	for _, name := range secretQueryParams {
+		if query.Has(name) {
+			query.Set(name, redacted)
+			redactedURL.RawQuery = query.Encode()
+		}
+	}
+
+	return redactedURL.String()
+}
+
+// redactedURLHandler returns a middleware handler that adds the request URL with secret tokens redacted to the request
+// logger as fieldKey. It is used instead of hlog.URLHandler.
+func redactedURLHandler(fieldKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			logger := zerolog.Ctx(r.Context())
+			logger.UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str(fieldKey, redactURL(r.URL))
+			})
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,276 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+)
+
+// userPermissionsSQL is a SQL expression for the sorted permissions of the user in the users table.
+const userPermissionsSQL = `array(
+	select distinct role_permissions.permission
+	from user_roles
+		join role_permissions on user_roles.role_id = role_permissions.role_id
+	where user_roles.user_id = users.id
+	order by 1
+)`
+
+// userRoleNamesSQL is a SQL expression for the comma separated role names of the user in the users table.
+const userRoleNamesSQL = `coalesce((
+	select string_agg(roles.name, ', ' order by roles.name)
+	from user_roles
+		join roles on user_roles.role_id = roles.id
+	where user_roles.user_id = users.id
+), '')`
+
+// selectRoles returns all roles for the roles fields of the user form.
+func selectRoles(ctx context.Context, dbpool *pgxpool.Pool) ([]*view.SystemUsersRole, error) {
+	return pgxutil.Select(ctx, dbpool, "select id, name from roles order by name", nil, pgx.RowToAddrOfStructByPos[view.SystemUsersRole])
+}
+
+// validateRoleIDs parses the role IDs submitted with the user form. Each must be the ID of one of roles.
+func validateRoleIDs(roleIDStrings []string, roles []*view.SystemUsersRole) ([]uuid.UUID, *errortree.Node) {
+	roleIDs := make([]uuid.UUID, 0, len(roleIDStrings))
+	for _, s := range roleIDStrings {
+		idx := slices.IndexFunc(roles, func(role *view.SystemUsersRole) bool { return role.ID.String() == s })
+		if idx == -1 {
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"roleIDs"}, errors.New("Role does not exist"))
+			return nil, validationErrors
+		}
+		roleIDs = append(roleIDs, roles[idx].ID)
+	}
+
+	return roleIDs, nil
+}
+
+// validateRole validates the role form. roleID is the ID of the role being changed and is excluded from the check for a
+// duplicate name.
+func validateRole(ctx context.Context, dbpool *pgxpool.Pool, formData *view.SystemRolesFormFields, roleID uuid.UUID) (*errortree.Node, error) {
+	validationErrors := &errortree.Node{}
+
+	formData.Name = strings.TrimSpace(formData.Name)
+	if formData.Name == "" {
+		validationErrors.Add([]any{"name"}, errors.New("Name is required"))
+	} else {
+		var nameTaken bool
+		err := dbpool.QueryRow(ctx, "select exists(select 1 from roles where lower(name) = lower($1) and id <> $2)", formData.Name, roleID).Scan(&nameTaken)
+		if err != nil {
+			return nil, err
+		}
+		if nameTaken {
+			validationErrors.Add([]any{"name"}, errors.New("Name is already taken"))
+		}
+	}
+
+	for _, permission := range formData.Permissions {
+		if !slices.Contains(db.Permissions, permission) {
+			validationErrors.Add([]any{"permissions"}, errors.New("Permission does not exist"))
+			break
+		}
+	}
+
+	if len(validationErrors.AllErrors()) > 0 {
+		return validationErrors, nil
+	}
+
+	return nil, nil
+}
+
+// selectRoleFormFields returns the role form fields for roleID.
+func selectRoleFormFields(ctx context.Context, dbpool *pgxpool.Pool, roleID uuid.UUID) (*view.SystemRolesFormFields, error) {
+	formData := &view.SystemRolesFormFields{}
+	err := dbpool.QueryRow(
+		ctx,
+		"select name, array(select permission from role_permissions where role_id = roles.id) from roles where id = $1",
+		roleID,
+	).Scan(&formData.Name, &formData.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	return formData, nil
+}
+
+// handleSystemRoles lists roles.
+func handleSystemRoles(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	roles, err := pgxutil.Select(ctx, env.dbpool,
+		`select id, name,
+	array(select permission from role_permissions where role_id = roles.id order by permission),
+	(select count(*) from user_roles where role_id = roles.id)
+from roles
+order by name`,
+		nil,
+		pgx.RowToAddrOfStructByPos[view.SystemRolesPageRole],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.SystemRolesPage(roles)).Render(r.Context(), w)
+}
+
+// handleSystemRolesNew renders the new role form.
+func handleSystemRolesNew(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := view.SystemRolesFormFields{}
+	return view.ApplicationLayout(view.SystemRolesNewPage(&formData, db.Permissions, nil)).Render(r.Context(), w)
+}
+
+// handleSystemRolesCreate creates a role.
+func handleSystemRolesCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	formData := view.SystemRolesFormFields{}
+	err := structify.Parse(params, &formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.SystemRolesNewPage(&formData, db.Permissions, validationErrors)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	validationErrors, err := validateRole(ctx, env.dbpool, &formData, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	if validationErrors != nil {
+		return view.ApplicationLayout(view.SystemRolesNewPage(&formData, db.Permissions, validationErrors)).Render(r.Context(), w)
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		roleID, err := db.CreateRole(ctx, tx, formData.Name, formData.Permissions)
+		if err != nil {
+			return err
+		}
+
+		after, err := db.AuditRoleFields(ctx, tx, roleID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionRoleCreate, roleID, formData.Name, db.AuditChanges(nil, after))
+	})
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/system/roles", http.StatusSeeOther)
+	return nil
+}
+
+// handleSystemRolesEdit renders the edit role form.
+func handleSystemRolesEdit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	roleID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	formData, err := selectRoleFormFields(ctx, env.dbpool, roleID)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.SystemRolesEditPage(roleID, formData, db.Permissions, nil)).Render(r.Context(), w)
+}
+
+// handleSystemRolesUpdate updates a role.
+func handleSystemRolesUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	roleID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	formData := view.SystemRolesFormFields{}
+	err = structify.Parse(params, &formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.SystemRolesEditPage(roleID, &formData, db.Permissions, validationErrors)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	validationErrors, err := validateRole(ctx, env.dbpool, &formData, roleID)
+	if err != nil {
+		return err
+	}
+	if validationErrors != nil {
+		return view.ApplicationLayout(view.SystemRolesEditPage(roleID, &formData, db.Permissions, validationErrors)).Render(r.Context(), w)
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		before, err := db.AuditRoleFields(ctx, tx, roleID)
+		if err != nil {
+			return err
+		}
+
+		err = db.UpdateRole(ctx, tx, roleID, formData.Name, formData.Permissions)
+		if err != nil {
+			return err
+		}
+
+		after, err := db.AuditRoleFields(ctx, tx, roleID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionRoleUpdate, roleID, formData.Name, db.AuditChanges(before, after))
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrNoRoleManager) {
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"permissions"}, errors.New("At least one user must be able to manage roles"))
+			return view.ApplicationLayout(view.SystemRolesEditPage(roleID, &formData, db.Permissions, validationErrors)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	http.Redirect(w, r, "/system/roles", http.StatusSeeOther)
+	return nil
+}
+
+// handleSystemRolesDelete deletes a role.
+func handleSystemRolesDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	roleID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		before, err := db.AuditRoleFields(ctx, tx, roleID)
+		if err != nil {
+			return err
+		}
+
+		err = db.DeleteRole(ctx, tx, roleID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionRoleDelete, roleID, before["name"].(string), db.AuditChanges(before, nil))
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrNoRoleManager) {
+			formData, err := selectRoleFormFields(ctx, env.dbpool, roleID)
+			if err != nil {
+				return err
+			}
+
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"delete"}, errors.New("At least one user must be able to manage roles"))
+			return view.ApplicationLayout(view.SystemRolesEditPage(roleID, formData, db.Permissions, validationErrors)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	http.Redirect(w, r, "/system/roles", http.StatusSeeOther)
+	return nil
+}
@@ -1,63 +1,125 @@
 package httpz
 
 import (
-	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/gofrs/uuid/v5"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gorilla/csrf"
-	"github.com/gorilla/securecookie"
-	"github.com/jackc/errortree"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype/zeronull"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/jackc/pgxutil"
-	"github.com/jackc/structify"
 	"github.com/jackc/web-starter-app/db"
 	"github.com/jackc/web-starter-app/lib/bee"
+	"github.com/jackc/web-starter-app/lib/mail"
+	"github.com/jackc/web-starter-app/lib/realip"
 	"github.com/jackc/web-starter-app/view"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
-	"github.com/shopspring/decimal"
 )
 
-// NewHandler returns an http.Handler that serves the web application.
-func NewHandler(
-	dbpool *pgxpool.Pool,
-	logger *zerolog.Logger,
-	csrfKey []byte,
-	secureCookies bool,
-	cookieAuthenticationKey []byte,
-	cookieEncryptionKey []byte,
-	assetManifest map[string]string,
-) (http.Handler, error) {
+// HandlerConfig configures the handler returned by NewHandler.
+type HandlerConfig struct {
+	DBPool *pgxpool.Pool
+	Logger *zerolog.Logger
+
+	// CSRFKeys, CookieAuthenticationKeys and CookieEncryptionKeys are key generations ordered newest first. New values
+	// are protected with the newest generation and values protected with any generation are accepted.
+	// CookieAuthenticationKeys and CookieEncryptionKeys are pairs and must be the same length.
+	CSRFKeys                 [][]byte
+	CookieAuthenticationKeys [][]byte
+	CookieEncryptionKeys     [][]byte
+
+	// SecureCookies sets the Secure attribute on cookies. It should only be false in development.
+	SecureCookies bool
+
+	LoginSessionIdleTimeout           time.Duration
+	LoginSessionMaxLifetime           time.Duration
+	PersistentLoginSessionIdleTimeout time.Duration
+	PersistentLoginSessionMaxLifetime time.Duration
+
+	WebAuthnRPID      string
+	WebAuthnRPOrigins []string
+
+	Mailer   mail.Mailer
+	MailFrom string
+
+	// BaseURL is the public URL of the application. It is used to build links in emails and OIDC redirects.
+	BaseURL string
+
+	// TrustedProxies are the proxies whose X-Forwarded-For header is trusted.
+	TrustedProxies []netip.Prefix
+
+	RegistrationEnabled bool
+
+	// OIDCConfig enables single sign-on with an OpenID Connect identity provider. It may be nil.
+	OIDCConfig *OIDCConfig
+
+	PasswordPolicy    *PasswordPolicy
+	LoginSessionCache *LoginSessionCache
+
+	// AssetManifest maps asset names to their built paths. nil means the Vite development server serves the assets.
+	AssetManifest map[string]string
+}
 
+// NewHandler returns an http.Handler that serves the web application.
+func NewHandler(config *HandlerConfig) (http.Handler, error) {
 	router := chi.NewRouter()
 
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          config.WebAuthnRPID,
+		RPDisplayName: "web-starter-app",
+		RPOrigins:     config.WebAuthnRPOrigins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("configure WebAuthn: %w", err)
+	}
+
+	// securecookie rejects cookies older than its MaxAge. The login session's own lifetime is enforced by
+	// loginSessionHandler so the cookie must remain decodable for at least that long.
+	secureCookie := newSecureCookieCodecs(
+		config.CookieAuthenticationKeys,
+		config.CookieEncryptionKeys,
+		int(max(config.LoginSessionMaxLifetime, config.PersistentLoginSessionMaxLifetime)/time.Second),
+	)
+
 	env := &environment{
-		dbpool:       dbpool,
-		logger:       logger,
-		secureCookie: securecookie.New(cookieAuthenticationKey, cookieEncryptionKey),
+		dbpool:       config.DBPool,
+		logger:       config.Logger,
+		secureCookie: secureCookie,
 		sessionCookieTemplate: &http.Cookie{
 			Name:     "web-starter-app-session",
 			Path:     "/",
-			Secure:   secureCookies,
+			Secure:   config.SecureCookies,
 			HttpOnly: true,
 			SameSite: http.SameSiteLaxMode,
 		},
+		loginSessionIdleTimeout:           config.LoginSessionIdleTimeout,
+		loginSessionMaxLifetime:           config.LoginSessionMaxLifetime,
+		persistentLoginSessionIdleTimeout: config.PersistentLoginSessionIdleTimeout,
+		persistentLoginSessionMaxLifetime: config.PersistentLoginSessionMaxLifetime,
+		webAuthn:                          webAuthn,
+		mailer:                            config.Mailer,
+		mailFrom:                          config.MailFrom,
+		baseURL:                           strings.TrimSuffix(config.BaseURL, "/"),
+		passwordPolicy:                    config.PasswordPolicy,
+		loginSessionCache:                 config.LoginSessionCache,
 	}
 
 	router.Use(middleware.Compress(5))
-	router.Use(middleware.RealIP)
+	// X-Forwarded-For is only trusted from the configured proxies. Otherwise a client could choose its own IP address and
+	// evade login throttling.
+	router.Use(realip.Handler(config.TrustedProxies))
 
-	router.Use(hlog.NewHandler(*logger))
+	router.Use(hlog.NewHandler(*config.Logger))
 	router.Use(hlog.RequestIDHandler("request_id", "x-request-id"))
 	router.Use(hlog.MethodHandler("method"))
-	router.Use(hlog.URLHandler("url"))
+	router.Use(redactedURLHandler("url"))
 	router.Use(hlog.RemoteAddrHandler("remote_ip"))
 	router.Use(hlog.AccessHandler(func(r *http.Request, status, size int, duration time.Duration) {
 		hlog.FromRequest(r).Info().
@@ -72,21 +134,37 @@ func NewHandler(
 	router.Use(setContextValue(ctxKeyEnvironment, env))
 
 	viewEnvironment := &view.Environment{
-		AssetManifest: assetManifest,
+		AssetManifest:       config.AssetManifest,
+		RegistrationEnabled: config.RegistrationEnabled,
+	}
+	if config.OIDCConfig != nil {
+		env.oidc = newOIDCClient(config.OIDCConfig, config.BaseURL+"/login/oidc/callback")
+		viewEnvironment.OIDCDisplayName = config.OIDCConfig.DisplayName
 	}
-	if assetManifest == nil {
+	if config.AssetManifest == nil {
 		viewEnvironment.ViteHotReload = true
 	}
 	router.Use(setContextValue(view.EnvironmentCtxKey, viewEnvironment))
 
-	CSRF := csrf.Protect(csrfKey, csrf.Path("/"), csrf.Secure(secureCookies))
+	router.Use(csrfKeyRotationHandler(config.CSRFKeys))
+	CSRF := csrf.Protect(config.CSRFKeys[0], csrf.Path("/"), csrf.Secure(config.SecureCookies), csrf.MaxAge(csrfMaxAge))
+	router.Use(apiTokenHandler())
 	router.Use(CSRF)
 
 	router.Use(loginSessionHandler())
+	router.Use(requirePasswordChangeHandler("/change_password", "/logout"))
 
 	hb := bee.HandlerBuilder[*environment]{
 		CtxKeyEnv: ctxKeyEnvironment,
 		ErrorHandlers: []bee.ErrorHandler{
+			// A missing row is usually a bad ID in the URL or a row that belongs to another user.
+			func(w http.ResponseWriter, r *http.Request, err error) (bool, error) {
+				if !errors.Is(err, pgx.ErrNoRows) {
+					return false, nil
+				}
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return true, nil
+			},
 			func(w http.ResponseWriter, r *http.Request, err error) (bool, error) {
 				zerolog.Ctx(r.Context()).Error().Err(err).Msg("error handling request")
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -95,415 +173,155 @@ func NewHandler(
 		},
 	}
 
-	router.Method("GET", "/login", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-		return view.ApplicationLayout(view.LoginPage(nil)).Render(ctx, w)
-	}))
+	// rawBodyHB builds handlers that read the request body themselves such as the WebAuthn ceremonies which must verify
+	// the exact JSON sent by the browser. Only route and query parameters are parsed.
+	rawBodyHB := hb
+	rawBodyHB.ParseParams = func(r *http.Request) (map[string]any, error) {
+		r = r.Clone(r.Context())
+		r.Header.Del("Content-Type")
+		return bee.ParseParams(r)
+	}
 
-	router.Method("POST", "/login/submit", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-		user, err := db.GetUserByUsername(ctx, env.dbpool, r.FormValue("username"))
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				loginErrors := &errortree.Node{}
-				loginErrors.Add(nil, errors.New("Invalid username or password"))
-				return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
-			}
-			return err
-		}
-
-		err = db.ValidateUserPassword(ctx, env.dbpool, user.ID, r.FormValue("password"))
-		if err != nil {
-			loginErrors := &errortree.Node{}
-			loginErrors.Add(nil, errors.New("Invalid username or password"))
-			return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
-		}
-
-		now := time.Now()
-		loginSessionID, err := pgxutil.InsertRowReturning(ctx, env.dbpool, "login_sessions", map[string]any{
-			"id":                            uuid.Must(uuid.NewV7()),
-			"user_id":                       user.ID,
-			"user_agent":                    zeronull.Text(r.UserAgent()),
-			"login_time":                    now,
-			"login_request_id":              middleware.GetReqID(ctx),
-			"approximate_last_request_time": now,
-		},
-			"id",
-			pgx.RowTo[uuid.UUID],
-		)
-		if err != nil {
-			return err
-		}
+	router.Method("GET", "/login", hb.New(handleLogin))
+	router.Method("POST", "/login/submit", hb.New(handleLoginSubmit))
 
-		setLoginSessionCookie(w, r, loginSessionID)
+	router.Method("POST", "/login/passkey/begin", hb.New(handleLoginPasskeyBegin))
+	router.Method("POST", "/login/passkey/finish", rawBodyHB.New(handleLoginPasskeyFinish))
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return nil
-	}))
+	if env.oidc != nil {
+		router.Method("POST", "/login/oidc", hb.New(handleLoginOIDC))
+		router.Method("GET", "/login/oidc/callback", hb.New(handleLoginOIDCCallback))
+	}
 
-	router.Method("POST", "/logout", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-		loginSession := getLoginSession(ctx)
-		if loginSession != nil {
-			_, err := env.dbpool.Exec(ctx, "delete from login_sessions where id=$1", loginSession.ID)
-			if err != nil {
-				return err
-			}
-		}
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return nil
-	}))
+	router.Method("GET", "/login/two_factor", hb.New(handleLoginTwoFactor))
+	router.Method("POST", "/login/two_factor", hb.New(handleLoginTwoFactorSubmit))
 
-	router.Group(func(router chi.Router) {
-		router.Use(requireCurrentUserHandler("/login"))
-		router.Method("GET", "/", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			now, err := db.GetCurrentTime(ctx, env.dbpool)
-			if err != nil {
-				return err
-			}
-
-			loginSession := getLoginSession(ctx)
-			name := loginSession.User.Username
-
-			var walkRecords []*view.HomeWalkRecord
-			if loginSession.User != nil {
-				walkRecords, err = pgxutil.Select(ctx, env.dbpool, "select id, duration, distance_in_miles, finish_time from walks where user_id=$1 order by finish_time desc", []any{loginSession.User.ID}, pgx.RowToAddrOfStructByPos[view.HomeWalkRecord])
-				if err != nil {
-					return err
-				}
-			}
+	router.Method("GET", "/forgot_password", hb.New(handleForgotPassword))
+	router.Method("POST", "/forgot_password", hb.New(handleForgotPasswordSubmit))
+	router.Method("GET", "/reset_password", hb.New(handleResetPassword))
+	router.Method("POST", "/reset_password", hb.New(handleResetPasswordSubmit))
 
-			return view.ApplicationLayout(view.Home(name, now, walkRecords)).Render(r.Context(), w)
-		}))
+	router.Method("POST", "/logout", hb.New(handleLogout))
 
-		router.Method("GET", "/walks/new", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			formData := view.WalkFormFields{}
-			return view.ApplicationLayout(view.WalksNew(&formData, nil)).Render(r.Context(), w)
-		}))
+	router.Method("POST", "/impersonation/stop", hb.New(handleImpersonationStop))
 
-		router.Method("POST", "/walks", func() http.Handler {
-			return hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-				loginSession := getLoginSession(ctx)
+	if config.RegistrationEnabled {
+		router.Method("GET", "/register", hb.New(handleRegister))
+		router.Method("POST", "/register", hb.New(handleRegisterSubmit))
+	}
 
-				formData := view.WalkFormFields{}
-				err := structify.Parse(params, &formData)
-				if err != nil {
-					return err
-				}
+	// The confirmation link does not require a login session so it works when opened in a different browser.
+	router.Method("GET", "/verify_email/confirm", hb.New(handleVerifyEmailConfirm))
 
-				validationErrors := &errortree.Node{}
-				duration, err := time.ParseDuration(formData.Duration)
-				if err != nil {
-					validationErrors.Add([]any{"duration"}, errors.New("Invalid duration"))
-				} else if duration <= 0 {
-					validationErrors.Add([]any{"duration"}, errors.New("Duration must be greater than 0"))
-				}
+	// Anyone with a share link can see what it shares without logging in.
+	router.Method("GET", "/shared/{token}", hb.New(handleShared))
 
-				distanceInMiles, err := decimal.NewFromString(formData.DistanceInMiles)
-				if err != nil {
-					validationErrors.Add([]any{"distanceInMiles"}, errors.New("Invalid distance"))
-				} else if distanceInMiles.LessThanOrEqual(decimal.Zero) {
-					validationErrors.Add([]any{"distanceInMiles"}, errors.New("Distance must be greater than 0"))
-				}
+	// Users that have not verified their email address can only reach these routes.
+	router.Group(func(router chi.Router) {
+		router.Use(requireCurrentUserHandler("/login"))
+		router.Use(forbidAPITokenHandler())
 
-				if validationErrors.AllErrors() != nil {
-					return view.ApplicationLayout(view.WalksNew(&formData, validationErrors)).Render(r.Context(), w)
-				}
+		router.Method("GET", "/verify_email", hb.New(handleVerifyEmail))
+		router.With(forbidImpersonationHandler()).Method("POST", "/verify_email/resend", hb.New(handleVerifyEmailResend))
+	})
 
-				err = pgxutil.InsertRow(ctx, env.dbpool, "walks", map[string]any{
-					"id":                uuid.Must(uuid.NewV7()),
-					"user_id":           loginSession.User.ID,
-					"duration":          formData.Duration,
-					"distance_in_miles": formData.DistanceInMiles,
-				})
-				if err != nil {
-					return err
-				}
+	router.Group(func(router chi.Router) {
+		router.Use(requireCurrentUserHandler("/login"))
+		router.Use(requireVerifiedEmailHandler("/verify_email"))
+		router.Use(requireAPITokenScopeHandler())
+		router.Use(currentUserTxHandler())
+
+		router.Method("GET", "/", hb.New(handleHome))
+		router.Method("GET", "/walks/new", hb.New(handleWalksNew))
+		router.Method("POST", "/walks", hb.New(handleWalksCreate))
+		router.Method("GET", "/walks/{id}", hb.New(handleWalksShow))
+		router.Method("GET", "/walks/{id}/edit", hb.New(handleWalksEdit))
+		router.Method("POST", "/walks/{id}/update", hb.New(handleWalksUpdate))
+		router.Method("POST", "/walks/{id}/delete", hb.New(handleWalksDelete))
+		router.Method("GET", "/stats", hb.New(handleStats))
+
+		router.With(forbidAPITokenHandler()).Method("GET", "/share_links", hb.New(handleShareLinks))
+		router.With(forbidAPITokenHandler(), forbidImpersonationHandler()).Method("POST", "/share_links", hb.New(handleShareLinksCreate))
+		router.With(forbidAPITokenHandler()).Method("POST", "/share_links/log/delete", hb.New(handleShareLinksLogDelete))
+		router.With(forbidAPITokenHandler()).Method("POST", "/share_links/{id}/delete", hb.New(handleShareLinksDelete))
+		router.With(forbidAPITokenHandler(), forbidImpersonationHandler()).Method("POST", "/walks/{id}/share_links", hb.New(handleWalksShareLinksCreate))
+		router.With(forbidAPITokenHandler()).Method("POST", "/walks/{id}/share_links/delete", hb.New(handleWalksShareLinksDelete))
+	})
 
-				http.Redirect(w, r, "/", http.StatusSeeOther)
-				return nil
-			})
-		}())
-
-		router.Method("GET", "/walks/{id}", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			loginSession := getLoginSession(ctx)
-
-			walkID, err := uuid.FromString(params["id"].(string))
-			if err != nil {
-				return err
-			}
-			walkRecord, err := pgxutil.SelectRow(ctx, env.dbpool, "select id, duration, distance_in_miles, finish_time from walks where id = $1 and user_id = $2", []any{walkID, loginSession.User.ID}, pgx.RowToAddrOfStructByPos[view.WalkRecord])
-			if err != nil {
-				return err
-			}
-
-			return view.ApplicationLayout(view.WalksShow(walkRecord)).Render(r.Context(), w)
-		}))
-
-		router.Method("GET", "/walks/{id}/edit", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			loginSession := getLoginSession(ctx)
-
-			walkID, err := uuid.FromString(params["id"].(string))
-			if err != nil {
-				return err
-			}
-
-			var duration time.Duration
-			var distanceInMiles decimal.Decimal
-			err = env.dbpool.QueryRow(
-				ctx,
-				"select duration, distance_in_miles from walks where id = $1 and user_id = $2",
-				walkID, loginSession.User.ID,
-			).Scan(&duration, &distanceInMiles)
-			if err != nil {
-				return err
-			}
-
-			formData := view.WalkFormFields{
-				Duration:        duration.String(),
-				DistanceInMiles: distanceInMiles.String(),
-			}
-			return view.ApplicationLayout(view.WalksEdit(walkID, &formData, nil)).Render(r.Context(), w)
-		}))
-
-		router.Method("POST", "/walks/{id}/update", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			loginSession := getLoginSession(ctx)
-
-			walkID, err := uuid.FromString(params["id"].(string))
-			if err != nil {
-				return err
-			}
-
-			formData := view.WalkFormFields{}
-			err = structify.Parse(params, &formData)
-			if err != nil {
-				if validationErrors, ok := err.(*errortree.Node); ok {
-					return view.ApplicationLayout(view.WalksEdit(walkID, &formData, validationErrors)).Render(r.Context(), w)
-				}
-				return err
-			}
-
-			validationErrors := &errortree.Node{}
-			duration, err := time.ParseDuration(formData.Duration)
-			if err != nil {
-				validationErrors.Add([]any{"duration"}, errors.New("Invalid duration"))
-			} else if duration <= 0 {
-				validationErrors.Add([]any{"duration"}, errors.New("Duration must be greater than 0"))
-			}
-
-			distanceInMiles, err := decimal.NewFromString(formData.DistanceInMiles)
-			if err != nil {
-				validationErrors.Add([]any{"distanceInMiles"}, errors.New("Invalid distance"))
-			} else if distanceInMiles.LessThanOrEqual(decimal.Zero) {
-				validationErrors.Add([]any{"distanceInMiles"}, errors.New("Distance must be greater than 0"))
-			}
-
-			if validationErrors.AllErrors() != nil {
-				return view.ApplicationLayout(view.WalksEdit(walkID, &formData, validationErrors)).Render(r.Context(), w)
-			}
-
-			err = pgxutil.UpdateRow(ctx, env.dbpool, "walks", map[string]any{
-				"duration":          duration,
-				"distance_in_miles": distanceInMiles,
-			}, map[string]any{
-				"id":      walkID,
-				"user_id": loginSession.User.ID,
-			})
-			if err != nil {
-				return err
-			}
-
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return nil
-		}))
-
-		router.Method("POST", "/walks/{id}/delete", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			loginSession := getLoginSession(ctx)
-
-			walkID, err := uuid.FromString(params["id"].(string))
-			if err != nil {
-				return err
-			}
-
-			_, err = pgxutil.ExecRow(ctx, env.dbpool, "delete from walks where id = $1 and user_id = $2", walkID, loginSession.User.ID)
-			if err != nil {
-				return err
-			}
-
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return nil
-		}))
-
-		router.Method("GET", "/change_password", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			formData := view.ChangePasswordFormFields{}
-
-			return view.ApplicationLayout(view.ChangePassword(&formData, nil)).Render(r.Context(), w)
-		}))
-
-		router.Method("POST", "/change_password", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			loginSession := getLoginSession(ctx)
-
-			formData := view.ChangePasswordFormFields{}
-			err := structify.Parse(params, &formData)
-			if err != nil {
-				if validationErrors, ok := err.(*errortree.Node); ok {
-					return view.ApplicationLayout(view.ChangePassword(&formData, validationErrors)).Render(r.Context(), w)
-				}
-				return err
-			}
-
-			err = db.ValidateUserPassword(ctx, env.dbpool, loginSession.User.ID, formData.CurrentPassword)
-			if err != nil {
-				validationErrors := &errortree.Node{}
-				validationErrors.Add([]any{"currentPassword"}, errors.New("Invalid password"))
-				return view.ApplicationLayout(view.ChangePassword(&formData, validationErrors)).Render(r.Context(), w)
-			}
-
-			err = db.SetUserPassword(ctx, env.dbpool, loginSession.User.ID, formData.NewPassword)
-			if err != nil {
-				return err
-			}
-
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return nil
-		}))
+	router.Group(func(router chi.Router) {
+		router.Use(requireCurrentUserHandler("/login"))
+		router.Use(requireVerifiedEmailHandler("/verify_email"))
+		router.Use(forbidAPITokenHandler())
+
+		router.With(forbidImpersonationHandler()).Method("GET", "/change_password", hb.New(handleChangePassword))
+		router.With(forbidImpersonationHandler()).Method("POST", "/change_password", hb.New(handleChangePasswordSubmit))
+
+		router.Method("GET", "/login_sessions", hb.New(handleLoginSessions))
+		router.With(forbidImpersonationHandler()).Method("POST", "/login_sessions/{id}/delete", hb.New(handleLoginSessionsDelete))
+		router.With(forbidImpersonationHandler()).Method("POST", "/login_sessions/delete_others", hb.New(handleLoginSessionsDeleteOthers))
+		router.With(forbidImpersonationHandler()).Method("POST", "/login_sessions/delete_remembered", hb.New(handleLoginSessionsDeleteRemembered))
+
+		router.Method("GET", "/passkeys", hb.New(handlePasskeys))
+		router.With(forbidImpersonationHandler()).Method("POST", "/passkeys/registration/begin", hb.New(handlePasskeysRegistrationBegin))
+		router.With(forbidImpersonationHandler()).Method("POST", "/passkeys/registration/finish", rawBodyHB.New(handlePasskeysRegistrationFinish))
+		router.With(forbidImpersonationHandler()).Method("POST", "/passkeys/{id}/delete", hb.New(handlePasskeysDelete))
+
+		router.Method("GET", "/api_tokens", hb.New(handleAPITokens))
+		router.With(forbidImpersonationHandler()).Method("POST", "/api_tokens", hb.New(handleAPITokensCreate))
+		router.With(forbidImpersonationHandler()).Method("POST", "/api_tokens/{id}/delete", hb.New(handleAPITokensDelete))
+
+		router.Method("GET", "/preferences", hb.New(handlePreferences))
+		router.Method("POST", "/preferences", hb.New(handlePreferencesUpdate))
+
+		router.Method("POST", "/current_organization", hb.New(handleCurrentOrganizationUpdate))
+		router.Method("GET", "/organizations", hb.New(handleOrganizations))
+		router.Method("GET", "/organizations/new", hb.New(handleOrganizationsNew))
+		router.Method("POST", "/organizations", hb.New(handleOrganizationsCreate))
+		router.Method("GET", "/organizations/{id}", hb.New(handleOrganizationsShow))
+		router.Method("POST", "/organizations/{id}/invitations", hb.New(handleOrganizationsInvitationsCreate))
+		router.Method("POST", "/organizations/{id}/invitations/{invitationID}/delete", hb.New(handleOrganizationsInvitationsDelete))
+		router.Method("POST", "/organizations/{id}/members/{userID}/delete", hb.New(handleOrganizationsMembersDelete))
+		router.Method("GET", "/organizations/{id}/edit", hb.New(handleOrganizationsEdit))
+		router.Method("POST", "/organizations/{id}/update", hb.New(handleOrganizationsUpdate))
+		router.Method("POST", "/organizations/{id}/delete", hb.New(handleOrganizationsDelete))
+		router.Method("POST", "/organizations/{id}/members/{userID}/role", hb.New(handleOrganizationsMembersRoleUpdate))
+		router.Method("GET", "/organization_invitations/{token}", hb.New(handleOrganizationInvitation))
+		router.With(forbidImpersonationHandler()).Method("POST", "/organization_invitations/{token}/accept", hb.New(handleOrganizationInvitationAccept))
+
+		router.Method("GET", "/two_factor", hb.New(handleTwoFactor))
+		router.With(forbidImpersonationHandler()).Method("POST", "/two_factor/setup", hb.New(handleTwoFactorSetupStart))
+		router.With(forbidImpersonationHandler()).Method("GET", "/two_factor/setup", hb.New(handleTwoFactorSetup))
+		router.With(forbidImpersonationHandler()).Method("POST", "/two_factor/confirm", hb.New(handleTwoFactorConfirm))
+		router.With(forbidImpersonationHandler()).Method("POST", "/two_factor/recovery_codes", hb.New(handleTwoFactorRecoveryCodes))
+		router.With(forbidImpersonationHandler()).Method("POST", "/two_factor/disable", hb.New(handleTwoFactorDisable))
 	})
 
 	router.Route("/system", func(router chi.Router) {
-		router.Use(requireSystemUserHandler("/login"))
-
-		router.Method("GET", "/users", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			users, err := pgxutil.Select(ctx, env.dbpool, "select id, username, system from users order by username", nil, pgx.RowToStructByPos[view.SystemUsersPageUser])
-			if err != nil {
-				return err
-			}
-
-			return view.ApplicationLayout(view.SystemUsersPage(users)).Render(r.Context(), w)
-		}))
-
-		router.Method("GET", "/users/new", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			formData := view.SystemUsersFormFields{}
-			return view.ApplicationLayout(view.SystemUsersNewPage(&formData, nil)).Render(r.Context(), w)
-		}))
-
-		router.Method("POST", "/users", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			formData := view.SystemUsersFormFields{}
-			err := structify.Parse(params, &formData)
-			if err != nil {
-				if validationErrors, ok := err.(*errortree.Node); ok {
-					return view.ApplicationLayout(view.SystemUsersNewPage(&formData, validationErrors)).Render(r.Context(), w)
-				}
-				return err
-			}
-
-			userID := uuid.Must(uuid.NewV7())
-
-			var nameTaken bool
-			err = env.dbpool.QueryRow(ctx, "select exists(select 1 from users where username = $1)", formData.Username).Scan(&nameTaken)
-			if err != nil {
-				return err
-			}
-			if nameTaken {
-				validationErrors := &errortree.Node{}
-				validationErrors.Add([]any{"username"}, errors.New("Username is already taken"))
-				return view.ApplicationLayout(view.SystemUsersNewPage(&formData, validationErrors)).Render(r.Context(), w)
-			}
-
-			err = pgxutil.InsertRow(ctx, env.dbpool, "users", map[string]any{
-				"id":       userID,
-				"username": formData.Username,
-				"system":   formData.System,
-			})
-			if err != nil {
-				return err
-			}
-
-			http.Redirect(w, r, "/system/users", http.StatusSeeOther)
-			return nil
-
-		}))
-
-		router.Method("GET", "/users/{id}", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			userID, err := uuid.FromString(params["id"].(string))
-			if err != nil {
-				return err
-			}
-			user, err := pgxutil.SelectRow(ctx, env.dbpool, "select id, username, system from users where id = $1", []any{userID}, pgx.RowToAddrOfStructByPos[view.SystemUsersPageUser])
-			if err != nil {
-				return err
-			}
-
-			return view.ApplicationLayout(view.SystemUsersShowPage(user)).Render(r.Context(), w)
-		}))
-
-		router.Method("GET", "/users/{id}/edit", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			userID, err := uuid.FromString(params["id"].(string))
-			if err != nil {
-				return err
-			}
-
-			formData := view.SystemUsersFormFields{}
-			err = env.dbpool.QueryRow(ctx, "select username, system from users where id = $1", userID).Scan(&formData.Username, &formData.System)
-			if err != nil {
-				return err
-			}
-
-			return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, nil)).Render(r.Context(), w)
-		}))
-
-		router.Method("POST", "/users/{id}/update", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			userID, err := uuid.FromString(params["id"].(string))
-			if err != nil {
-				return err
-			}
-
-			formData := view.SystemUsersFormFields{}
-			err = structify.Parse(params, &formData)
-			if err != nil {
-				if validationErrors, ok := err.(*errortree.Node); ok {
-					return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, validationErrors)).Render(r.Context(), w)
-				}
-				return err
-			}
-
-			var nameTaken bool
-			err = env.dbpool.QueryRow(ctx, "select exists(select 1 from users where username = $1 and id <> $2)", formData.Username, userID).Scan(&nameTaken)
-			if err != nil {
-				return err
-			}
-			if nameTaken {
-				validationErrors := &errortree.Node{}
-				validationErrors.Add([]any{"username"}, errors.New("Username is already taken"))
-				return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, validationErrors)).Render(r.Context(), w)
-			}
-
-			err = pgxutil.UpdateRow(ctx, env.dbpool, "users", map[string]any{
-				"username": formData.Username,
-				"system":   formData.System,
-			}, map[string]any{
-				"id": userID,
-			})
-			if err != nil {
-				return err
-			}
-
-			// TODO handle validation errors
-
-			http.Redirect(w, r, "/system/users", http.StatusSeeOther)
-			return nil
-		}))
-
-		router.Method("POST", "/users/{id}/delete", hb.New(func(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
-			userID, err := uuid.FromString(params["id"].(string))
-			if err != nil {
-				return err
-			}
-
-			_, err = pgxutil.ExecRow(ctx, env.dbpool, "delete from users where id = $1", userID)
-			if err != nil {
-				return err
-			}
-
-			http.Redirect(w, r, "/system/users", http.StatusSeeOther)
-			return nil
-		}))
+		router.Use(requireCurrentUserHandler("/login"))
+		router.Use(forbidAPITokenHandler())
+
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("GET", "/users", hb.New(handleSystemUsers))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("GET", "/users/new", hb.New(handleSystemUsersNew))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("POST", "/users", hb.New(handleSystemUsersCreate))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("GET", "/users/{id}", hb.New(handleSystemUsersShow))
+		router.With(requirePermissionHandler(db.PermissionUsersImpersonate)).Method("POST", "/users/{id}/impersonate", hb.New(handleSystemUsersImpersonate))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("POST", "/users/{id}/unlock", hb.New(handleSystemUsersUnlock))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("GET", "/users/{id}/edit", hb.New(handleSystemUsersEdit))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("POST", "/users/{id}/update", hb.New(handleSystemUsersUpdate))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("POST", "/users/{id}/suspend", hb.New(handleSystemUsersSuspend))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("POST", "/users/{id}/reactivate", hb.New(handleSystemUsersReactivate))
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("POST", "/users/{id}/delete", hb.New(handleSystemUsersDelete))
+
+		router.With(requirePermissionHandler(db.PermissionUsersManage)).Method("GET", "/organizations", hb.New(handleSystemOrganizations))
+
+		router.With(requirePermissionHandler(db.PermissionRolesManage)).Method("GET", "/roles", hb.New(handleSystemRoles))
+		router.With(requirePermissionHandler(db.PermissionRolesManage)).Method("GET", "/roles/new", hb.New(handleSystemRolesNew))
+		router.With(requirePermissionHandler(db.PermissionRolesManage)).Method("POST", "/roles", hb.New(handleSystemRolesCreate))
+		router.With(requirePermissionHandler(db.PermissionRolesManage)).Method("GET", "/roles/{id}/edit", hb.New(handleSystemRolesEdit))
+		router.With(requirePermissionHandler(db.PermissionRolesManage)).Method("POST", "/roles/{id}/update", hb.New(handleSystemRolesUpdate))
+		router.With(requirePermissionHandler(db.PermissionRolesManage)).Method("POST", "/roles/{id}/delete", hb.New(handleSystemRolesDelete))
+
+		router.With(requirePermissionHandler(db.PermissionAuditRead)).Method("GET", "/audit", hb.New(handleSystemAudit))
 	})
 
 	return router, nil
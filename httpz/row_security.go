@@ -0,0 +1,103 @@
+package httpz
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/rs/zerolog"
+)
+
+// txResponseWriter holds a response until the transaction it depends on has ended.
+type txResponseWriter struct {
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+}
+
+func (trw *txResponseWriter) Header() http.Header {
+	return trw.header
+}
+
+func (trw *txResponseWriter) Write(p []byte) (int, error) {
+	if trw.statusCode == 0 {
+		trw.statusCode = http.StatusOK
+	}
+	return trw.body.Write(p)
+}
+
+func (trw *txResponseWriter) WriteHeader(statusCode int) {
+	if trw.statusCode == 0 {
+		trw.statusCode = statusCode
+	}
+}
+
+// writeTo sends the held response to w.
+func (trw *txResponseWriter) writeTo(w http.ResponseWriter) {
+	maps.Copy(w.Header(), trw.header)
+	w.WriteHeader(trw.statusCode)
+	w.Write(trw.body.Bytes())
+}
+
+// currentUserTxHandler returns a middleware handler that runs the request in a transaction limited by row-level security
+// to the rows of the current user. The response is held until the transaction ends. The transaction is committed
+// before a successful or redirect response is sent so the client never sees a change that was not saved. It is rolled
+// back for any error response. It must be used after requireCurrentUserHandler.
+func currentUserTxHandler() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			env := ctx.Value(ctxKeyEnvironment).(*environment)
+			loginSession := getLoginSession(ctx)
+
+			tx, err := env.dbpool.Begin(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("failed to begin current user transaction")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			defer tx.Rollback(ctx)
+
+			err = db.SetCurrentUserID(ctx, tx, loginSession.User.ID)
+			if err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("failed to set current user")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			trw := &txResponseWriter{header: w.Header().Clone()}
+			ctx = context.WithValue(ctx, ctxKeyCurrentUserTx, tx)
+			next.ServeHTTP(trw, r.WithContext(ctx))
+			if trw.statusCode == 0 {
+				trw.statusCode = http.StatusOK
+			}
+
+			if trw.statusCode >= http.StatusBadRequest {
+				err = tx.Rollback(ctx)
+				if err != nil {
+					zerolog.Ctx(ctx).Error().Err(err).Msg("failed to roll back current user transaction")
+				}
+				trw.writeTo(w)
+				return
+			}
+
+			err = tx.Commit(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("failed to commit current user transaction")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			trw.writeTo(w)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// getCurrentUserTx returns the transaction started by currentUserTxHandler.
+func getCurrentUserTx(ctx context.Context) pgx.Tx {
+	return ctx.Value(ctxKeyCurrentUserTx).(pgx.Tx)
+}
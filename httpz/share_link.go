@@ -0,0 +1,210 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/lib/distance"
+	"github.com/jackc/web-starter-app/view"
+)
+
+// shareLinkLifetime parses the expiresInDays parameter of a new share link. ok is false if it is not one of
+// view.ShareLinkExpiresInDays.
+func shareLinkLifetime(params map[string]any) (lifetime time.Duration, ok bool) {
+	s, _ := params["expiresInDays"].(string)
+	days, err := strconv.Atoi(s)
+	if err != nil || !slices.Contains(view.ShareLinkExpiresInDays, days) {
+		return 0, false
+	}
+	return time.Duration(days) * 24 * time.Hour, true
+}
+
+// renderShareLinksPage renders the share links of the user tx is limited to by row-level security.
+func renderShareLinksPage(ctx context.Context, w http.ResponseWriter, tx pgx.Tx, newURL string) error {
+	now, err := db.GetCurrentTime(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	links, err := pgxutil.Select(ctx, tx,
+		`select share_links.id, share_links.walk_id, walks.finish_time, share_links.expiration_time, share_links.view_count, share_links.insert_time
+from share_links
+	left join walks on share_links.walk_id = walks.id
+order by share_links.insert_time desc`,
+		nil,
+		pgx.RowToAddrOfStructByPos[view.ShareLinksPageLink],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.ShareLinksPage(links, newURL, now)).Render(ctx, w)
+}
+
+// handleShared shows the walk or walk log of a share link to anyone with the link.
+func handleShared(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	// The token is in the URL. Do not leak it to other sites through the Referer header.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	shareLink, err := db.ViewShareLink(ctx, env.dbpool, params["token"].(string))
+	if err != nil {
+		if errors.Is(err, db.ErrShareLinkInvalid) {
+			w.WriteHeader(http.StatusNotFound)
+			return view.ApplicationLayout(view.ShareLinkInvalidPage()).Render(ctx, w)
+		}
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		// The share link only grants access to the walks of the user that created it.
+		err := db.SetCurrentUserID(ctx, tx, shareLink.UserID)
+		if err != nil {
+			return err
+		}
+
+		// Distances are shown in the unit of the user that shared them.
+		var username string
+		var distanceUnit distance.Unit
+		err = tx.QueryRow(ctx, "select username, distance_unit from users where id = $1", shareLink.UserID).Scan(&username, &distanceUnit)
+		if err != nil {
+			return err
+		}
+		ctx = context.WithValue(ctx, view.DistanceUnitCtxKey, distanceUnit)
+
+		if shareLink.WalkID.Valid {
+			walkRecord, err := pgxutil.SelectRow(ctx, tx,
+				`select walks.id, walks.duration, walks.distance_in_miles, walks.finish_time, users.username, false, false
+from walks
+	join users on walks.user_id = users.id
+where walks.id = $1
+	and walks.user_id = $2`,
+				[]any{shareLink.WalkID, shareLink.UserID},
+				pgx.RowToAddrOfStructByPos[view.WalkRecord],
+			)
+			if err != nil {
+				return err
+			}
+
+			return view.ApplicationLayout(view.WalksShow(walkRecord)).Render(ctx, w)
+		}
+
+		walkRecords, err := pgxutil.Select(ctx, tx,
+			`select walks.id, walks.duration, walks.distance_in_miles, walks.finish_time, users.username
+from walks
+	join users on walks.user_id = users.id
+where walks.user_id = $1
+	and walks.organization_id is null
+order by walks.finish_time desc`,
+			[]any{shareLink.UserID},
+			pgx.RowToAddrOfStructByPos[view.HomeWalkRecord],
+		)
+		if err != nil {
+			return err
+		}
+
+		return view.ApplicationLayout(view.SharedWalkLogPage(username, walkRecords)).Render(ctx, w)
+	})
+}
+
+// handleShareLinks lists the current user's share links.
+func handleShareLinks(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	return renderShareLinksPage(ctx, w, getCurrentUserTx(ctx), "")
+}
+
+// handleShareLinksCreate creates a share link for the current user's personal walk log.
+func handleShareLinksCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	lifetime, ok := shareLinkLifetime(params)
+	if !ok {
+		http.Error(w, "Invalid expiration", http.StatusBadRequest)
+		return nil
+	}
+
+	token, err := db.CreateShareLink(ctx, getCurrentUserTx(ctx), loginSession.User.ID, uuid.NullUUID{}, lifetime)
+	if err != nil {
+		return err
+	}
+
+	return renderShareLinksPage(ctx, w, getCurrentUserTx(ctx), env.baseURL+"/shared/"+token)
+}
+
+// handleShareLinksLogDelete revokes the share links for the current user's personal walk log.
+func handleShareLinksLogDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	_, err := getCurrentUserTx(ctx).Exec(ctx, "delete from share_links where walk_id is null")
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
+
+// handleShareLinksDelete revokes one of the current user's share links.
+func handleShareLinksDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	shareLinkID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	_, err = pgxutil.ExecRow(ctx, getCurrentUserTx(ctx), "delete from share_links where id = $1", shareLinkID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/share_links", http.StatusSeeOther)
+	return nil
+}
+
+// handleWalksShareLinksCreate creates a share link for a walk.
+func handleWalksShareLinksCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	walkID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	lifetime, ok := shareLinkLifetime(params)
+	if !ok {
+		http.Error(w, "Invalid expiration", http.StatusBadRequest)
+		return nil
+	}
+
+	// Teammates can see the walk but only the user that logged it can share it.
+	_, err = pgxutil.ExecRow(ctx, getCurrentUserTx(ctx), "select 1 from walks where id = $1 and user_id = $2", walkID, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := db.CreateShareLink(ctx, getCurrentUserTx(ctx), loginSession.User.ID, uuid.NullUUID{UUID: walkID, Valid: true}, lifetime)
+	if err != nil {
+		return err
+	}
+
+	return renderShareLinksPage(ctx, w, getCurrentUserTx(ctx), env.baseURL+"/shared/"+token)
+}
+
+// handleWalksShareLinksDelete revokes the share links for a walk.
+func handleWalksShareLinksDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	walkID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	_, err = getCurrentUserTx(ctx).Exec(ctx, "delete from share_links where walk_id = $1", walkID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/walks/"+walkID.String(), http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,119 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/view"
+)
+
+// statsPeriod is a period the statistics page can group walks by.
+type statsPeriod struct {
+	// field is the date_trunc field for the period.
+	field string
+
+	// count is how many of the most recent periods are shown.
+	count int
+}
+
+// statsTimeZone is the time zone periods start and end in. Users do not have a time zone so periods are in UTC rather
+// than whatever time zone the database session happens to use.
+const statsTimeZone = "UTC"
+
+var statsPeriods = map[string]statsPeriod{
+	"week":  {field: "week", count: 12},
+	"month": {field: "month", count: 12},
+	"year":  {field: "year", count: 5},
+}
+
+// selectStatsPeriods returns the totals of the walks matching condition for each of the most recent periods including
+// periods without any walks. condition is a SQL condition on walks with the parameter $1 bound to arg. Periods are in
+// statsTimeZone. Each period also has the value of a least squares trend line of distance over the periods.
+func selectStatsPeriods(ctx context.Context, tx pgx.Tx, condition string, arg any, period statsPeriod) ([]*view.StatsPeriod, error) {
+	// Periods are computed on local timestamps in statsTimeZone so neither truncation nor interval arithmetic depends on
+	// the session time zone.
+	return pgxutil.Select(ctx, tx,
+		`with periods as (
+	select start, row_number() over (order by start) as idx
+	from generate_series(
+		date_trunc($2, now() at time zone $4) - ($3::int - 1) * ('1 ' || $2)::interval,
+		date_trunc($2, now() at time zone $4),
+		('1 ' || $2)::interval
+	) as start
+),
+totals as (
+	select periods.start, periods.idx,
+		count(walks.id) as walk_count,
+		coalesce(sum(walks.distance_in_miles), 0) as distance,
+		coalesce(sum(walks.duration), '0') as duration,
+		sum(walks.duration) / nullif(sum(walks.distance_in_miles), 0)::float8 as pace
+	from periods
+		left join walks on date_trunc($2, walks.finish_time at time zone $4) = periods.start
+			and `+condition+`
+	group by periods.start, periods.idx
+)
+select start, walk_count, distance, duration, pace,
+	regr_intercept(distance::float8, idx) over () + regr_slope(distance::float8, idx) over () * idx
+from totals
+order by start`,
+		[]any{arg, period.field, period.count, statsTimeZone},
+		pgx.RowToAddrOfStructByPos[view.StatsPeriod],
+	)
+}
+
+// selectStatsSummary returns the totals of all walks matching condition. condition is a SQL condition on walks with the
+// parameter $1 bound to arg.
+func selectStatsSummary(ctx context.Context, tx pgx.Tx, condition string, arg any) (*view.StatsSummary, error) {
+	summary, err := pgxutil.SelectRow(ctx, tx,
+		`select count(*), coalesce(sum(distance_in_miles), 0), coalesce(sum(duration), '0'),
+	sum(duration) / nullif(sum(distance_in_miles), 0)::float8
+from walks
+where `+condition,
+		[]any{arg},
+		pgx.RowToAddrOfStructByPos[view.StatsSummary],
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.LongestWalk, err = pgxutil.SelectRow(ctx, tx,
+		`select id, duration, distance_in_miles, finish_time
+from walks
+where `+condition+`
+order by distance_in_miles desc, finish_time
+limit 1`,
+		[]any{arg},
+		pgx.RowToAddrOfStructByPos[view.StatsWalk],
+	)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// handleStats renders the walking statistics of the walk log the user is working with.
+func handleStats(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	period, _ := params["period"].(string)
+	if _, ok := statsPeriods[period]; !ok {
+		period = "month"
+	}
+
+	condition, arg, organizationName := currentWalkLogCondition(getLoginSession(ctx).User)
+	tx := getCurrentUserTx(ctx)
+
+	summary, err := selectStatsSummary(ctx, tx, condition, arg)
+	if err != nil {
+		return err
+	}
+
+	periods, err := selectStatsPeriods(ctx, tx, condition, arg, statsPeriods[period])
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.StatsPage(organizationName, period, summary, periods)).Render(ctx, w)
+}
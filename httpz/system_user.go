@@ -0,0 +1,443 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype/zeronull"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/view"
+	"github.com/rs/zerolog"
+)
+
+// refuseSystemUsersAction renders the page for userID with err explaining why the action was refused.
+func refuseSystemUsersAction(ctx context.Context, w http.ResponseWriter, env *environment, userID uuid.UUID, err error) error {
+	validationErrors := &errortree.Node{}
+	validationErrors.Add([]any{"action"}, err)
+	w.WriteHeader(http.StatusConflict)
+	return renderSystemUsersShowPage(ctx, w, env, userID, validationErrors)
+}
+
+// renderSystemUsersShowPage renders the page for userID. validationErrors explains why an action on the page
+// was refused.
+func renderSystemUsersShowPage(ctx context.Context, w http.ResponseWriter, env *environment, userID uuid.UUID, validationErrors *errortree.Node) error {
+	user, err := pgxutil.SelectRow(ctx, env.dbpool, "select id, username, coalesce(email, ''), "+userRoleNamesSQL+", two_factor_required, disabled_at is not null, "+userOrganizationNamesSQL+" from users where id = $1", []any{userID}, pgx.RowToAddrOfStructByPos[view.SystemUsersPageUser])
+	if err != nil {
+		return err
+	}
+
+	lockedUntil, err := db.GetUserLoginLockedUntil(ctx, env.dbpool, userID)
+	if err != nil {
+		return err
+	}
+
+	impersonations, err := pgxutil.Select(ctx, env.dbpool,
+		`select impersonator_username, client_ip, start_time, end_time
+from impersonations
+where impersonated_user_id = $1
+order by start_time desc
+limit 20`,
+		[]any{userID},
+		pgx.RowToAddrOfStructByPos[view.SystemUsersPageImpersonation],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.SystemUsersShowPage(user, lockedUntil, impersonations, validationErrors)).Render(ctx, w)
+}
+
+// handleSystemUsers lists users.
+func handleSystemUsers(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	// organizationID optionally limits the users to the members of an organization.
+	var organizationID uuid.NullUUID
+	var organizationName string
+	if s, _ := params["organizationID"].(string); s != "" {
+		id, err := uuid.FromString(s)
+		if err != nil {
+			return err
+		}
+		organizationID = uuid.NullUUID{UUID: id, Valid: true}
+
+		err = env.dbpool.QueryRow(ctx, "select name from organizations where id = $1", id).Scan(&organizationName)
+		if err != nil {
+			return err
+		}
+	}
+
+	users, err := pgxutil.Select(ctx, env.dbpool,
+		"select id, username, coalesce(email, ''), "+userRoleNamesSQL+", two_factor_required, disabled_at is not null, "+userOrganizationNamesSQL+`
+from users
+where $1::uuid is null
+	or exists (select 1 from organization_memberships where organization_id = $1 and user_id = users.id)
+order by username`,
+		[]any{organizationID},
+		pgx.RowToStructByPos[view.SystemUsersPageUser],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.SystemUsersPage(users, organizationName)).Render(r.Context(), w)
+}
+
+// handleSystemUsersNew renders the new user form.
+func handleSystemUsersNew(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	roles, err := selectRoles(ctx, env.dbpool)
+	if err != nil {
+		return err
+	}
+
+	formData := view.SystemUsersFormFields{}
+	return view.ApplicationLayout(view.SystemUsersNewPage(&formData, roles, nil)).Render(r.Context(), w)
+}
+
+// handleSystemUsersCreate creates a user.
+func handleSystemUsersCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	roles, err := selectRoles(ctx, env.dbpool)
+	if err != nil {
+		return err
+	}
+
+	formData := view.SystemUsersFormFields{}
+	err = structify.Parse(params, &formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.SystemUsersNewPage(&formData, roles, validationErrors)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	roleIDs, validationErrors := validateRoleIDs(formData.RoleIDs, roles)
+	if validationErrors != nil {
+		return view.ApplicationLayout(view.SystemUsersNewPage(&formData, roles, validationErrors)).Render(r.Context(), w)
+	}
+
+	userID := uuid.Must(uuid.NewV7())
+
+	formData.Email = strings.TrimSpace(formData.Email)
+	validationErrors, err = validateUsernameAndEmail(ctx, env.dbpool, formData.Username, formData.Email, userID)
+	if err != nil {
+		return err
+	}
+	if validationErrors != nil {
+		return view.ApplicationLayout(view.SystemUsersNewPage(&formData, roles, validationErrors)).Render(r.Context(), w)
+	}
+
+	// An email address entered by an administrator is considered verified.
+	var emailVerifiedTime zeronull.Timestamptz
+	if formData.Email != "" {
+		emailVerifiedTime = zeronull.Timestamptz(time.Now())
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		err := pgxutil.InsertRow(ctx, tx, "users", map[string]any{
+			"id":                  userID,
+			"username":            formData.Username,
+			"email":               zeronull.Text(formData.Email),
+			"email_verified_time": emailVerifiedTime,
+			"two_factor_required": formData.TwoFactorRequired,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = db.SetUserRoles(ctx, tx, userID, roleIDs)
+		if err != nil {
+			return err
+		}
+
+		after, err := db.AuditUserFields(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionUserCreate, userID, formData.Username, db.AuditChanges(nil, after))
+	})
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/system/users", http.StatusSeeOther)
+	return nil
+
+}
+
+// handleSystemUsersShow shows a user.
+func handleSystemUsersShow(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	userID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	return renderSystemUsersShowPage(ctx, w, env, userID, nil)
+}
+
+// handleSystemUsersUnlock clears a user's failed login attempts.
+func handleSystemUsersUnlock(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	userID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		var username string
+		err := tx.QueryRow(ctx, "select username from users where id = $1", userID).Scan(&username)
+		if err != nil {
+			return err
+		}
+
+		err = db.ResetUserLoginThrottle(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionUserUnlock, userID, username, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/system/users/"+userID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleSystemUsersEdit renders the edit user form.
+func handleSystemUsersEdit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	userID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	roles, err := selectRoles(ctx, env.dbpool)
+	if err != nil {
+		return err
+	}
+
+	formData := view.SystemUsersFormFields{}
+	err = env.dbpool.QueryRow(
+		ctx,
+		"select username, coalesce(email, ''), array(select role_id::text from user_roles where user_id = users.id), two_factor_required from users where id = $1",
+		userID,
+	).Scan(&formData.Username, &formData.Email, &formData.RoleIDs, &formData.TwoFactorRequired)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, roles, nil)).Render(r.Context(), w)
+}
+
+// handleSystemUsersUpdate updates a user.
+func handleSystemUsersUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	userID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	roles, err := selectRoles(ctx, env.dbpool)
+	if err != nil {
+		return err
+	}
+
+	formData := view.SystemUsersFormFields{}
+	err = structify.Parse(params, &formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, roles, validationErrors)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	roleIDs, validationErrors := validateRoleIDs(formData.RoleIDs, roles)
+	if validationErrors != nil {
+		return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, roles, validationErrors)).Render(r.Context(), w)
+	}
+
+	if userID == getLoginSession(ctx).User.ID {
+		var currentRoleIDs []uuid.UUID
+		err = env.dbpool.QueryRow(ctx, "select array(select role_id from user_roles where user_id = $1)", userID).Scan(&currentRoleIDs)
+		if err != nil {
+			return err
+		}
+		for _, roleID := range currentRoleIDs {
+			if !slices.Contains(roleIDs, roleID) {
+				validationErrors := &errortree.Node{}
+				validationErrors.Add([]any{"roleIDs"}, errors.New("You cannot remove your own roles"))
+				return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, roles, validationErrors)).Render(r.Context(), w)
+			}
+		}
+	}
+
+	formData.Email = strings.TrimSpace(formData.Email)
+	validationErrors, err = validateUsernameAndEmail(ctx, env.dbpool, formData.Username, formData.Email, userID)
+	if err != nil {
+		return err
+	}
+	if validationErrors != nil {
+		return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, roles, validationErrors)).Render(r.Context(), w)
+	}
+
+	// An email address entered by an administrator is considered verified. An unchanged address keeps its existing
+	// verification status.
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		before, err := db.AuditUserFields(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = pgxutil.ExecRow(
+			ctx,
+			tx,
+			`update users
+set username = $1,
+	email = $2,
+	email_verified_time = case
+		when $2::text is null then null
+		when lower(email) is distinct from lower($2) then now()
+		else email_verified_time
+	end,
+	two_factor_required = $3
+where id = $4`,
+			formData.Username, zeronull.Text(formData.Email), formData.TwoFactorRequired, userID,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = db.SetUserRoles(ctx, tx, userID, roleIDs)
+		if err != nil {
+			return err
+		}
+
+		after, err := db.AuditUserFields(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionUserUpdate, userID, formData.Username, db.AuditChanges(before, after))
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrNoRoleManager) {
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"roleIDs"}, errors.New("At least one user must be able to manage roles"))
+			return view.ApplicationLayout(view.SystemUsersEditPage(userID, &formData, roles, validationErrors)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	// TODO handle validation errors
+
+	http.Redirect(w, r, "/system/users", http.StatusSeeOther)
+	return nil
+}
+
+// handleSystemUsersSuspend suspends a user.
+func handleSystemUsersSuspend(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	userID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	if userID == getLoginSession(ctx).User.ID {
+		return refuseSystemUsersAction(ctx, w, env, userID, errors.New("You cannot suspend yourself"))
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		var username string
+		err := tx.QueryRow(ctx, "select username from users where id = $1", userID).Scan(&username)
+		if err != nil {
+			return err
+		}
+
+		err = db.SuspendUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionUserSuspend, userID, username, nil)
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrNoRoleManager) {
+			return refuseSystemUsersAction(ctx, w, env, userID, errors.New("At least one user must be able to manage roles"))
+		}
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Str("user_id", userID.String()).Msg("suspended user")
+
+	http.Redirect(w, r, "/system/users/"+userID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleSystemUsersReactivate reactivates a suspended user.
+func handleSystemUsersReactivate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	userID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		var username string
+		err := tx.QueryRow(ctx, "select username from users where id = $1", userID).Scan(&username)
+		if err != nil {
+			return err
+		}
+
+		err = db.ReactivateUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionUserReactivate, userID, username, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/system/users/"+userID.String(), http.StatusSeeOther)
+	return nil
+}
+
+// handleSystemUsersDelete permanently deletes a user and their data.
+func handleSystemUsersDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	userID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	if userID == getLoginSession(ctx).User.ID {
+		return refuseSystemUsersAction(ctx, w, env, userID, errors.New("You cannot delete yourself"))
+	}
+
+	err = pgx.BeginFunc(ctx, env.dbpool, func(tx pgx.Tx) error {
+		before, err := db.AuditUserFields(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = db.DeleteUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		return db.InsertAuditLogEntry(ctx, tx, auditActor(r), db.AuditActionUserDelete, userID, before["username"].(string), db.AuditChanges(before, nil))
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrNoRoleManager) {
+			return refuseSystemUsersAction(ctx, w, env, userID, errors.New("At least one user must be able to manage roles"))
+		}
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Str("user_id", userID.String()).Msg("deleted user")
+
+	http.Redirect(w, r, "/system/users", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,367 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/errortree"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/lib/totp"
+	"github.com/jackc/web-starter-app/view"
+	"rsc.io/qr"
+)
+
+// totpIssuer identifies the application in the user's authenticator app.
+const totpIssuer = "web-starter-app"
+
+const (
+	pendingTwoFactorLoginCookieName = "web-starter-app-pending-two-factor-login"
+	pendingTwoFactorLoginLifetime   = 10 * time.Minute
+)
+
+// pendingTwoFactorLogin records that a user has entered a correct password but has not yet entered a two-factor code.
+// It is stored in a cookie instead of the database so no login session exists until both factors are verified.
+type pendingTwoFactorLogin struct {
+	UserID     uuid.UUID
+	RememberMe bool
+	ExpiresAt  time.Time
+}
+
+// setPendingTwoFactorLoginCookie sets the pending two-factor login cookie in the response.
+func setPendingTwoFactorLoginCookie(w http.ResponseWriter, r *http.Request, userID uuid.UUID, rememberMe bool) error {
+	ctx := r.Context()
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+	cookie := *env.sessionCookieTemplate
+	cookie.Name = pendingTwoFactorLoginCookieName
+
+	pending := pendingTwoFactorLogin{
+		UserID:     userID,
+		RememberMe: rememberMe,
+		ExpiresAt:  time.Now().Add(pendingTwoFactorLoginLifetime),
+	}
+
+	var err error
+	cookie.Value, err = env.secureCookie.Encode(cookie.Name, pending)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &cookie)
+
+	return nil
+}
+
+// getPendingTwoFactorLogin returns the pending two-factor login from the request cookie. It returns false if there is no
+// valid, unexpired pending two-factor login.
+func getPendingTwoFactorLogin(r *http.Request) (*pendingTwoFactorLogin, bool) {
+	ctx := r.Context()
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+
+	cookie, err := r.Cookie(pendingTwoFactorLoginCookieName)
+	if err != nil {
+		return nil, false
+	}
+
+	pending := &pendingTwoFactorLogin{}
+	err = env.secureCookie.Decode(pendingTwoFactorLoginCookieName, cookie.Value, pending)
+	if err != nil {
+		env.logger.Warn().Err(err).Msg("error decoding pending two-factor login cookie")
+		return nil, false
+	}
+
+	if time.Now().After(pending.ExpiresAt) {
+		return nil, false
+	}
+
+	return pending, true
+}
+
+// clearPendingTwoFactorLoginCookie clears the pending two-factor login cookie in the response.
+func clearPendingTwoFactorLoginCookie(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	env := ctx.Value(ctxKeyEnvironment).(*environment)
+	cookie := *env.sessionCookieTemplate
+	cookie.Name = pendingTwoFactorLoginCookieName
+	cookie.Expires = time.Unix(0, 0)
+	http.SetCookie(w, &cookie)
+}
+
+// renderTwoFactorSetup renders the QR code of the current user's pending TOTP secret. It redirects to /two_factor if
+// setup has not been started.
+func renderTwoFactorSetup(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, formData *view.TwoFactorCodeFormFields, validationErrors *errortree.Node) error {
+	loginSession := getLoginSession(ctx)
+
+	secret, err := db.GetPendingUserTwoFactorSecret(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrTwoFactorNotPending) {
+			http.Redirect(w, r, "/two_factor", http.StatusSeeOther)
+			return nil
+		}
+		return err
+	}
+
+	qrCode, err := qr.Encode(totp.URI(totpIssuer, loginSession.User.Username, secret), qr.M)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.TwoFactorSetupPage("/two_factor/confirm", qrCode, totp.EncodeSecret(secret), formData, validationErrors)).Render(ctx, w)
+}
+
+// renderLoginTwoFactorSetup renders the two-factor setup page for a user who is required to use two-factor
+// authentication but has not yet set it up.
+func renderLoginTwoFactorSetup(ctx context.Context, w http.ResponseWriter, env *environment, userID uuid.UUID, formData *view.TwoFactorCodeFormFields, validationErrors *errortree.Node) error {
+	secret, err := db.GetPendingUserTwoFactorSecret(ctx, env.dbpool, userID)
+	if err != nil {
+		if !errors.Is(err, db.ErrTwoFactorNotPending) {
+			return err
+		}
+		secret, err = db.StartUserTwoFactorSetup(ctx, env.dbpool, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	user, err := db.GetUserByID(ctx, env.dbpool, userID)
+	if err != nil {
+		return err
+	}
+
+	qrCode, err := qr.Encode(totp.URI(totpIssuer, user.Username, secret), qr.M)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.TwoFactorSetupPage("/login/two_factor", qrCode, totp.EncodeSecret(secret), formData, validationErrors)).Render(ctx, w)
+}
+
+// handleLoginTwoFactor renders the second login step for a user whose password has been checked.
+func handleLoginTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	pending, ok := getPendingTwoFactorLogin(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return nil
+	}
+
+	twoFactorEnabled, err := db.UserTwoFactorEnabled(ctx, env.dbpool, pending.UserID)
+	if err != nil {
+		return err
+	}
+
+	formData := &view.TwoFactorCodeFormFields{}
+	if !twoFactorEnabled {
+		return renderLoginTwoFactorSetup(ctx, w, env, pending.UserID, formData, nil)
+	}
+
+	return view.ApplicationLayout(view.LoginTwoFactorPage(formData, nil)).Render(ctx, w)
+}
+
+// handleLoginTwoFactorSubmit checks the two-factor code of the second login step and logs the user in.
+func handleLoginTwoFactorSubmit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	pending, ok := getPendingTwoFactorLogin(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return nil
+	}
+
+	formData := &view.TwoFactorCodeFormFields{}
+	err := structify.Parse(params, formData)
+	if err != nil {
+		return err
+	}
+
+	twoFactorEnabled, err := db.UserTwoFactorEnabled(ctx, env.dbpool, pending.UserID)
+	if err != nil {
+		return err
+	}
+
+	if twoFactorEnabled {
+		// Two-factor codes are throttled with the password so a stolen password does not allow unlimited code guesses.
+		user, err := db.GetUserByID(ctx, env.dbpool, pending.UserID)
+		if err != nil {
+			return err
+		}
+		ip := clientIP(r)
+
+		lockedUntil, err := loginLockedUntil(ctx, env, r, user.Username)
+		if err != nil {
+			return err
+		}
+		if !lockedUntil.IsZero() {
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"code"}, errLoginLocked)
+			return view.ApplicationLayout(view.LoginTwoFactorPage(formData, validationErrors)).Render(ctx, w)
+		}
+
+		err = db.ValidateUserTwoFactorCode(ctx, env.dbpool, pending.UserID, formData.Code)
+		if err != nil {
+			if errors.Is(err, db.ErrTwoFactorCodeIncorrect) {
+				err = db.RecordFailedLogin(ctx, env.dbpool, user.Username, ip)
+				if err != nil {
+					return err
+				}
+
+				validationErrors := &errortree.Node{}
+				validationErrors.Add([]any{"code"}, errors.New("Invalid code"))
+				return view.ApplicationLayout(view.LoginTwoFactorPage(formData, validationErrors)).Render(ctx, w)
+			}
+			return err
+		}
+
+		clearPendingTwoFactorLoginCookie(w, r)
+		err = createLoginSession(ctx, w, r, pending.UserID, pending.RememberMe)
+		if err != nil {
+			if errors.Is(err, errUserSuspended) {
+				loginErrors := &errortree.Node{}
+				loginErrors.Add(nil, err)
+				return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+			}
+			return err
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return nil
+	}
+
+	recoveryCodes, err := db.EnableUserTwoFactor(ctx, env.dbpool, pending.UserID, formData.Code)
+	if err != nil {
+		if errors.Is(err, db.ErrTwoFactorCodeIncorrect) {
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"code"}, errors.New("Invalid code"))
+			return renderLoginTwoFactorSetup(ctx, w, env, pending.UserID, formData, validationErrors)
+		}
+		return err
+	}
+
+	clearPendingTwoFactorLoginCookie(w, r)
+	err = createLoginSession(ctx, w, r, pending.UserID, pending.RememberMe)
+	if err != nil {
+		if errors.Is(err, errUserSuspended) {
+			loginErrors := &errortree.Node{}
+			loginErrors.Add(nil, err)
+			return view.ApplicationLayout(view.LoginPage(loginErrors)).Render(ctx, w)
+		}
+		return err
+	}
+
+	return view.ApplicationLayout(view.TwoFactorRecoveryCodesPage(recoveryCodes, "/")).Render(ctx, w)
+}
+
+// handleTwoFactor shows whether two-factor authentication is enabled for the current user.
+func handleTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	twoFactorEnabled, err := db.UserTwoFactorEnabled(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	formData := &view.TwoFactorDisableFormFields{}
+	return view.ApplicationLayout(view.TwoFactorPage(twoFactorEnabled, formData, nil)).Render(ctx, w)
+}
+
+// handleTwoFactorSetupStart generates a pending TOTP secret for the current user.
+func handleTwoFactorSetupStart(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	_, err := db.StartUserTwoFactorSetup(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/two_factor/setup", http.StatusSeeOther)
+	return nil
+}
+
+// handleTwoFactorSetup renders the QR code of the pending TOTP secret.
+func handleTwoFactorSetup(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	return renderTwoFactorSetup(ctx, w, r, env, &view.TwoFactorCodeFormFields{}, nil)
+}
+
+// handleTwoFactorConfirm enables two-factor authentication once a code from the pending secret is entered.
+func handleTwoFactorConfirm(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	formData := &view.TwoFactorCodeFormFields{}
+	err := structify.Parse(params, formData)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodes, err := db.EnableUserTwoFactor(ctx, env.dbpool, loginSession.User.ID, formData.Code)
+	if err != nil {
+		if errors.Is(err, db.ErrTwoFactorCodeIncorrect) {
+			validationErrors := &errortree.Node{}
+			validationErrors.Add([]any{"code"}, errors.New("Invalid code"))
+			return renderTwoFactorSetup(ctx, w, r, env, formData, validationErrors)
+		}
+		if errors.Is(err, db.ErrTwoFactorNotPending) {
+			http.Redirect(w, r, "/two_factor", http.StatusSeeOther)
+			return nil
+		}
+		return err
+	}
+
+	return view.ApplicationLayout(view.TwoFactorRecoveryCodesPage(recoveryCodes, "/two_factor")).Render(ctx, w)
+}
+
+// handleTwoFactorRecoveryCodes replaces the current user's recovery codes.
+func handleTwoFactorRecoveryCodes(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	twoFactorEnabled, err := db.UserTwoFactorEnabled(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	if !twoFactorEnabled {
+		http.Redirect(w, r, "/two_factor", http.StatusSeeOther)
+		return nil
+	}
+
+	recoveryCodes, err := db.ReplaceUserRecoveryCodes(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.TwoFactorRecoveryCodesPage(recoveryCodes, "/two_factor")).Render(ctx, w)
+}
+
+// handleTwoFactorDisable disables two-factor authentication for the current user.
+func handleTwoFactorDisable(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	formData := &view.TwoFactorDisableFormFields{}
+	err := structify.Parse(params, formData)
+	if err != nil {
+		return err
+	}
+
+	twoFactorRequired, err := db.UserTwoFactorRequired(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactorRequired {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add(nil, errors.New("Two-factor authentication is required for your account"))
+		return view.ApplicationLayout(view.TwoFactorPage(true, formData, validationErrors)).Render(ctx, w)
+	}
+
+	err = db.ValidateUserPassword(ctx, env.dbpool, loginSession.User.ID, formData.CurrentPassword)
+	if err != nil {
+		validationErrors := &errortree.Node{}
+		validationErrors.Add([]any{"currentPassword"}, errors.New("Invalid password"))
+		return view.ApplicationLayout(view.TwoFactorPage(true, formData, validationErrors)).Render(ctx, w)
+	}
+
+	err = db.DisableUserTwoFactor(ctx, env.dbpool, loginSession.User.ID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/two_factor", http.StatusSeeOther)
+	return nil
+}
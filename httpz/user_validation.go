@@ -0,0 +1,103 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgxutil"
+)
+
+// validateUsername checks that username is present and does not belong to a user other than userID. The returned
+// validation error is suitable for showing to the user. err is only non-nil if the check itself failed.
+func validateUsername(ctx context.Context, db pgxutil.DB, username string, userID uuid.UUID) (validationErr error, err error) {
+	if username == "" {
+		return errors.New("Username is required"), nil
+	}
+
+	var nameTaken bool
+	err = db.QueryRow(ctx, "select exists(select 1 from users where username = $1 and id <> $2)", username, userID).Scan(&nameTaken)
+	if err != nil {
+		return nil, err
+	}
+	if nameTaken {
+		return errors.New("Username is already taken"), nil
+	}
+
+	return nil, nil
+}
+
+// validateEmailAddress checks that email is a plain email address. An empty email is valid as email is optional. The
+// returned validation error is suitable for showing to the user.
+func validateEmailAddress(email string) error {
+	if email == "" {
+		return nil
+	}
+
+	// Only accept a bare address. mail.ParseAddress also accepts forms such as "Name <address>".
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("Email is not a valid email address")
+	}
+
+	return nil
+}
+
+// validateUserEmail checks that email is a plain email address that has not been verified by a user other than userID.
+// An empty email is valid as email is optional. It reveals whether an email address has an account so it must only be
+// used where that is acceptable such as by an administrator. The returned validation error is suitable for showing to
+// the user. err is only non-nil if the check itself failed.
+func validateUserEmail(ctx context.Context, db pgxutil.DB, email string, userID uuid.UUID) (validationErr error, err error) {
+	if email == "" {
+		return nil, nil
+	}
+
+	validationErr = validateEmailAddress(email)
+	if validationErr != nil {
+		return validationErr, nil
+	}
+
+	var emailTaken bool
+	err = db.QueryRow(
+		ctx,
+		"select exists(select 1 from users where lower(email) = lower($1) and email_verified_time is not null and id <> $2)",
+		email, userID,
+	).Scan(&emailTaken)
+	if err != nil {
+		return nil, err
+	}
+	if emailTaken {
+		return errors.New("Email is already taken"), nil
+	}
+
+	return nil, nil
+}
+
+// validateUsernameAndEmail validates username and email for userID. It returns nil if both are valid.
+func validateUsernameAndEmail(ctx context.Context, db pgxutil.DB, username, email string, userID uuid.UUID) (*errortree.Node, error) {
+	validationErrors := &errortree.Node{}
+
+	usernameErr, err := validateUsername(ctx, db, username, userID)
+	if err != nil {
+		return nil, err
+	}
+	if usernameErr != nil {
+		validationErrors.Add([]any{"username"}, usernameErr)
+	}
+
+	emailErr, err := validateUserEmail(ctx, db, email, userID)
+	if err != nil {
+		return nil, err
+	}
+	if emailErr != nil {
+		validationErrors.Add([]any{"email"}, emailErr)
+	}
+
+	if len(validationErrors.AllErrors()) > 0 {
+		return validationErrors, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,272 @@
+package httpz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/errortree"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/structify"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/lib/distance"
+	"github.com/jackc/web-starter-app/view"
+	"github.com/shopspring/decimal"
+)
+
+// parseWalkDistance parses the distance of a walk submitted with params and returns it in miles. s is the distance
+// field. A distance without a unit is in unit. API clients written before distances could be entered in other units
+// send the distance in miles as distanceInMiles instead.
+func parseWalkDistance(s string, params map[string]any, unit distance.Unit) (decimal.Decimal, error) {
+	if s == "" {
+		if distanceInMiles, _ := params["distanceInMiles"].(string); distanceInMiles != "" {
+			return decimal.NewFromString(distanceInMiles)
+		}
+	}
+
+	return distance.Parse(s, unit)
+}
+
+// handleHome lists the walks of the walk log the user is working with.
+func handleHome(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	now, err := db.GetCurrentTime(ctx, env.dbpool)
+	if err != nil {
+		return err
+	}
+
+	loginSession := getLoginSession(ctx)
+	name := loginSession.User.Username
+
+	where, arg, organizationName := currentWalkLogCondition(loginSession.User)
+
+	var logShared bool
+	if organizationName == "" {
+		err = getCurrentUserTx(ctx).QueryRow(ctx,
+			"select exists (select 1 from share_links where walk_id is null and expiration_time > now())",
+		).Scan(&logShared)
+		if err != nil {
+			return err
+		}
+	}
+
+	walkRecords, err := pgxutil.Select(ctx, getCurrentUserTx(ctx),
+		`select walks.id, walks.duration, walks.distance_in_miles, walks.finish_time, users.username
+from walks
+	join users on walks.user_id = users.id
+where `+where+`
+order by walks.finish_time desc`,
+		[]any{arg},
+		pgx.RowToAddrOfStructByPos[view.HomeWalkRecord],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.Home(name, now, organizationName, logShared, walkRecords)).Render(r.Context(), w)
+}
+
+// handleWalksNew renders the new walk form.
+func handleWalksNew(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	user := getLoginSession(ctx).User
+	formData := view.WalkFormFields{}
+	if organization := user.CurrentOrganization(); organization != nil {
+		formData.OrganizationID = organization.ID.String()
+	}
+	return view.ApplicationLayout(view.WalksNew(&formData, walkOrganizations(user), nil)).Render(r.Context(), w)
+}
+
+// handleWalksCreate creates a walk.
+func handleWalksCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	formData := view.WalkFormFields{}
+	err := structify.Parse(params, &formData)
+	if err != nil {
+		return err
+	}
+
+	validationErrors := &errortree.Node{}
+	duration, err := time.ParseDuration(formData.Duration)
+	if err != nil {
+		validationErrors.Add([]any{"duration"}, errors.New("Invalid duration"))
+	} else if duration <= 0 {
+		validationErrors.Add([]any{"duration"}, errors.New("Duration must be greater than 0"))
+	}
+
+	distanceInMiles, err := parseWalkDistance(formData.Distance, params, loginSession.User.DistanceUnit)
+	if err != nil {
+		validationErrors.Add([]any{"distance"}, errors.New("Invalid distance"))
+	} else if distanceInMiles.LessThanOrEqual(decimal.Zero) {
+		validationErrors.Add([]any{"distance"}, errors.New("Distance must be greater than 0"))
+	}
+
+	organizationID, err := validateWalkOrganization(ctx, getCurrentUserTx(ctx), loginSession.User.ID, formData.OrganizationID, validationErrors)
+	if err != nil {
+		return err
+	}
+
+	if validationErrors.AllErrors() != nil {
+		return view.ApplicationLayout(view.WalksNew(&formData, walkOrganizations(loginSession.User), validationErrors)).Render(r.Context(), w)
+	}
+
+	err = pgxutil.InsertRow(ctx, getCurrentUserTx(ctx), "walks", map[string]any{
+		"id":                uuid.Must(uuid.NewV7()),
+		"user_id":           loginSession.User.ID,
+		"duration":          formData.Duration,
+		"distance_in_miles": distanceInMiles,
+		"organization_id":   organizationID,
+	})
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
+
+// handleWalksShow shows a walk.
+func handleWalksShow(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	walkID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+	walkRecord, err := pgxutil.SelectRow(ctx, getCurrentUserTx(ctx),
+		`select walks.id, walks.duration, walks.distance_in_miles, walks.finish_time, users.username, walks.user_id = $2,
+	exists (select 1 from share_links where share_links.walk_id = walks.id and share_links.expiration_time > now())
+from walks
+	join users on walks.user_id = users.id
+where walks.id = $1`,
+		[]any{walkID, loginSession.User.ID},
+		pgx.RowToAddrOfStructByPos[view.WalkRecord],
+	)
+	if err != nil {
+		return err
+	}
+
+	return view.ApplicationLayout(view.WalksShow(walkRecord)).Render(r.Context(), w)
+}
+
+// handleWalksEdit renders the edit walk form.
+func handleWalksEdit(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	walkID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	// Teammates can see the walk but only the user that logged it can change it.
+	var duration time.Duration
+	var distanceInMiles decimal.Decimal
+	var organizationID uuid.NullUUID
+	err = getCurrentUserTx(ctx).QueryRow(
+		ctx,
+		"select duration, distance_in_miles, organization_id from walks where id = $1 and user_id = $2",
+		walkID, loginSession.User.ID,
+	).Scan(&duration, &distanceInMiles, &organizationID)
+	if err != nil {
+		return err
+	}
+
+	formData := view.WalkFormFields{
+		Duration: duration.String(),
+		Distance: loginSession.User.DistanceUnit.FromMilesForDisplay(distanceInMiles).String(),
+	}
+	if organizationID.Valid {
+		formData.OrganizationID = organizationID.UUID.String()
+	}
+	return view.ApplicationLayout(view.WalksEdit(walkID, &formData, walkOrganizations(loginSession.User), nil)).Render(r.Context(), w)
+}
+
+// handleWalksUpdate updates a walk.
+func handleWalksUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	loginSession := getLoginSession(ctx)
+
+	walkID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	formData := view.WalkFormFields{}
+	err = structify.Parse(params, &formData)
+	if err != nil {
+		if validationErrors, ok := err.(*errortree.Node); ok {
+			return view.ApplicationLayout(view.WalksEdit(walkID, &formData, walkOrganizations(loginSession.User), validationErrors)).Render(r.Context(), w)
+		}
+		return err
+	}
+
+	validationErrors := &errortree.Node{}
+	duration, err := time.ParseDuration(formData.Duration)
+	if err != nil {
+		validationErrors.Add([]any{"duration"}, errors.New("Invalid duration"))
+	} else if duration <= 0 {
+		validationErrors.Add([]any{"duration"}, errors.New("Duration must be greater than 0"))
+	}
+
+	distanceInMiles, err := parseWalkDistance(formData.Distance, params, loginSession.User.DistanceUnit)
+	if err != nil {
+		validationErrors.Add([]any{"distance"}, errors.New("Invalid distance"))
+	} else if distanceInMiles.LessThanOrEqual(decimal.Zero) {
+		validationErrors.Add([]any{"distance"}, errors.New("Distance must be greater than 0"))
+	} else {
+		// The edit form shows the distance rounded. Keep the exact distance when it was not changed.
+		var previousDistanceInMiles decimal.Decimal
+		err = getCurrentUserTx(ctx).QueryRow(
+			ctx,
+			"select distance_in_miles from walks where id = $1 and user_id = $2",
+			walkID, loginSession.User.ID,
+		).Scan(&previousDistanceInMiles)
+		if err != nil {
+			return err
+		}
+		unit := loginSession.User.DistanceUnit
+		if distanceInMiles.Equal(unit.ToMiles(unit.FromMilesForDisplay(previousDistanceInMiles))) {
+			distanceInMiles = previousDistanceInMiles
+		}
+	}
+
+	organizationID, err := validateWalkOrganization(ctx, getCurrentUserTx(ctx), loginSession.User.ID, formData.OrganizationID, validationErrors)
+	if err != nil {
+		return err
+	}
+
+	if validationErrors.AllErrors() != nil {
+		return view.ApplicationLayout(view.WalksEdit(walkID, &formData, walkOrganizations(loginSession.User), validationErrors)).Render(r.Context(), w)
+	}
+
+	err = pgxutil.UpdateRow(ctx, getCurrentUserTx(ctx), "walks", map[string]any{
+		"duration":          duration,
+		"distance_in_miles": distanceInMiles,
+		"organization_id":   organizationID,
+	}, map[string]any{
+		"id": walkID,
+	})
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
+
+// handleWalksDelete deletes a walk.
+func handleWalksDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, env *environment, params map[string]any) error {
+	walkID, err := uuid.FromString(params["id"].(string))
+	if err != nil {
+		return err
+	}
+
+	_, err = pgxutil.ExecRow(ctx, getCurrentUserTx(ctx), "delete from walks where id = $1", walkID)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,138 @@
+// Package breachedpasswords checks passwords against an offline list of passwords known to have been exposed in data
+// breaches.
+//
+// The list is a text file with one uppercase hex SHA-1 hash per line sorted in ascending order. Each hash may be
+// followed by a colon and a count which is ignored. This is the format of the Pwned Passwords "ordered by hash"
+// download. To reduce the size of the file, every hash may be truncated to the same number of characters. A shorter
+// prefix rejects a few more passwords that were never breached.
+//
+// The file is binary searched on disk so it does not need to fit in memory and the list is never sent over the
+// network.
+package breachedpasswords
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+// List is a list of breached passwords. It is safe for concurrent use.
+type List struct {
+	r    io.ReaderAt
+	size int64
+	file *os.File
+}
+
+// New returns a List that reads size bytes from r.
+func New(r io.ReaderAt, size int64) *List {
+	return &List{r: r, size: size}
+}
+
+// Open opens the list at path. The caller must call Close when done.
+func Open(path string) (*List, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	list := New(file, fileInfo.Size())
+	list.file = file
+	return list, nil
+}
+
+// Close closes the file opened by Open.
+func (l *List) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Contains reports whether password is in the list.
+func (l *List) Contains(password string) (bool, error) {
+	digest := sha1.Sum([]byte(password))
+	target := bytes.ToUpper([]byte(hex.EncodeToString(digest[:])))
+
+	// Find the first line whose hash is not less than target.
+	lo, hi := int64(0), l.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		key, err := l.keyAt(mid)
+		if err != nil {
+			return false, err
+		}
+		if key == nil || compareKey(key, target) >= 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	key, err := l.keyAt(lo)
+	if err != nil {
+		return false, err
+	}
+
+	return key != nil && compareKey(key, target) == 0, nil
+}
+
+// compareKey compares the possibly truncated hash key with the same number of characters of target.
+func compareKey(key, target []byte) int {
+	return bytes.Compare(key, target[:min(len(key), len(target))])
+}
+
+// keyAt returns the hash of the first line that starts at or after offset. It returns nil if there is no such line.
+func (l *List) keyAt(offset int64) ([]byte, error) {
+	start := offset
+	if start > 0 {
+		// Start at the previous byte so a line beginning exactly at offset is not skipped.
+		start--
+	}
+	br := bufio.NewReaderSize(io.NewSectionReader(l.r, start, l.size-start), 128)
+
+	if offset > 0 {
+		_, err := br.ReadSlice('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+			if !errors.Is(err, bufio.ErrBufferFull) {
+				return nil, err
+			}
+			// The line is longer than the buffer. Skip the rest of it.
+			_, err = br.ReadString('\n')
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil, nil
+				}
+				return nil, err
+			}
+		}
+	}
+
+	line, err := br.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	key := []byte(line)
+	if i := bytes.IndexAny(key, ":\r\n"); i >= 0 {
+		key = key[:i]
+	}
+	if len(key) == 0 {
+		// A blank line can only be at the end of the file.
+		return nil, nil
+	}
+
+	return bytes.ToUpper(key), nil
+}
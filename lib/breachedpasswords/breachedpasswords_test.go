@@ -0,0 +1,99 @@
+package breachedpasswords_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackc/web-starter-app/lib/breachedpasswords"
+	"github.com/stretchr/testify/require"
+)
+
+func hashList(passwords []string, prefixLen int, suffix string) string {
+	hashes := make([]string, len(passwords))
+	for i, password := range passwords {
+		digest := sha1.Sum([]byte(password))
+		hashes[i] = strings.ToUpper(hex.EncodeToString(digest[:]))[:prefixLen] + suffix
+	}
+	slices.Sort(hashes)
+	return strings.Join(hashes, "\r\n") + "\r\n"
+}
+
+func TestListContains(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein", "monkey", "dragon", "iloveyou", "trustno1"}
+	notBreached := []string{"", "correct horse battery staple", "Password", "1234567"}
+
+	for _, tc := range []struct {
+		testName string
+		contents string
+	}{
+		{testName: "full hashes", contents: hashList(breached, 40, "")},
+		{testName: "full hashes with counts", contents: hashList(breached, 40, ":12345")},
+		{testName: "truncated hashes", contents: hashList(breached, 16, "")},
+	} {
+		t.Run(tc.testName, func(t *testing.T) {
+			list := breachedpasswords.New(strings.NewReader(tc.contents), int64(len(tc.contents)))
+
+			for _, password := range breached {
+				contains, err := list.Contains(password)
+				require.NoError(t, err)
+				require.Truef(t, contains, "%q", password)
+			}
+
+			for _, password := range notBreached {
+				contains, err := list.Contains(password)
+				require.NoError(t, err)
+				require.Falsef(t, contains, "%q", password)
+			}
+		})
+	}
+}
+
+func TestListContainsEmptyList(t *testing.T) {
+	list := breachedpasswords.New(strings.NewReader(""), 0)
+	contains, err := list.Contains("password")
+	require.NoError(t, err)
+	require.False(t, contains)
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	err := os.WriteFile(path, []byte(hashList([]string{"password"}, 40, ":3")), 0o644)
+	require.NoError(t, err)
+
+	list, err := breachedpasswords.Open(path)
+	require.NoError(t, err)
+	defer list.Close()
+
+	contains, err := list.Contains("password")
+	require.NoError(t, err)
+	require.True(t, contains)
+
+	contains, err = list.Contains("not breached")
+	require.NoError(t, err)
+	require.False(t, contains)
+}
+
+func TestListContainsManyPasswords(t *testing.T) {
+	var breached []string
+	for i := range 1000 {
+		breached = append(breached, "breached"+strconv.Itoa(i))
+	}
+	contents := hashList(breached, 40, ":1")
+	list := breachedpasswords.New(strings.NewReader(contents), int64(len(contents)))
+
+	for i := range 1000 {
+		contains, err := list.Contains("breached" + strconv.Itoa(i))
+		require.NoError(t, err)
+		require.True(t, contains)
+
+		contains, err = list.Contains("safe" + strconv.Itoa(i))
+		require.NoError(t, err)
+		require.False(t, contains)
+	}
+}
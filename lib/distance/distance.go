@@ -0,0 +1,127 @@
+// Package distance converts and parses distances in miles and kilometers.
+//
+// Distances are decimal so a distance entered by a user is converted without the rounding errors of floating point.
+package distance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Unit is a unit of distance. Its value is its abbreviation.
+type Unit string
+
+const (
+	Miles      Unit = "mi"
+	Kilometers Unit = "km"
+)
+
+// Units are all supported units.
+var Units = []Unit{Miles, Kilometers}
+
+// kilometersPerMile is exact by the definition of the international mile.
+var kilometersPerMile = decimal.RequireFromString("1.609344")
+
+// toMilesPlaces is the number of decimal places a distance in kilometers is rounded to when converted to miles.
+// fromMilesPlaces is the number of decimal places a distance in miles is rounded to when converted to kilometers. The
+// error from converting to miles is small enough that any distance in kilometers of up to fromMilesPlaces decimal
+// places is exactly restored when converted back.
+const (
+	toMilesPlaces   = 20
+	fromMilesPlaces = 12
+)
+
+// DisplayPlaces is the number of decimal places a distance is rounded to when it is shown to a user.
+const DisplayPlaces = 2
+
+// Valid returns true if u is a supported unit.
+func (u Unit) Valid() bool {
+	return u == Miles || u == Kilometers
+}
+
+// Name returns the plural name of u such as "miles".
+func (u Unit) Name() string {
+	switch u {
+	case Kilometers:
+		return "kilometers"
+	default:
+		return "miles"
+	}
+}
+
+// PerMile returns the number of u in one mile.
+func (u Unit) PerMile() decimal.Decimal {
+	switch u {
+	case Kilometers:
+		return kilometersPerMile
+	default:
+		return decimal.NewFromInt(1)
+	}
+}
+
+// ToMiles converts d in u to miles.
+func (u Unit) ToMiles(d decimal.Decimal) decimal.Decimal {
+	switch u {
+	case Kilometers:
+		return d.DivRound(kilometersPerMile, toMilesPlaces)
+	default:
+		return d
+	}
+}
+
+// FromMiles converts miles to u.
+func (u Unit) FromMiles(miles decimal.Decimal) decimal.Decimal {
+	switch u {
+	case Kilometers:
+		return miles.Mul(kilometersPerMile).Round(fromMilesPlaces)
+	default:
+		return miles
+	}
+}
+
+// FromMilesForDisplay converts miles to u rounded to DisplayPlaces decimal places.
+func (u Unit) FromMilesForDisplay(miles decimal.Decimal) decimal.Decimal {
+	return u.FromMiles(miles).Round(DisplayPlaces)
+}
+
+// unitNames maps the lowercase names Parse accepts to units.
+var unitNames = map[string]Unit{
+	"mi":         Miles,
+	"mile":       Miles,
+	"miles":      Miles,
+	"km":         Kilometers,
+	"kilometer":  Kilometers,
+	"kilometers": Kilometers,
+	"kilometre":  Kilometers,
+	"kilometres": Kilometers,
+}
+
+// Parse parses a distance such as "5", "5km" or "3.1 mi" and returns it in miles. A distance without a unit is in
+// defaultUnit.
+func Parse(s string, defaultUnit Unit) (decimal.Decimal, error) {
+	s = strings.TrimSpace(s)
+	numberEnd := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+')
+	})
+	if numberEnd == -1 {
+		numberEnd = len(s)
+	}
+
+	d, err := decimal.NewFromString(s[:numberEnd])
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	unit := defaultUnit
+	if suffix := strings.TrimSpace(s[numberEnd:]); suffix != "" {
+		var ok bool
+		unit, ok = unitNames[strings.ToLower(suffix)]
+		if !ok {
+			return decimal.Decimal{}, fmt.Errorf("unknown unit: %q", suffix)
+		}
+	}
+
+	return unit.ToMiles(d), nil
+}
@@ -0,0 +1,57 @@
+package distance_test
+
+import (
+	"testing"
+
+	"github.com/jackc/web-starter-app/lib/distance"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		s           string
+		defaultUnit distance.Unit
+		miles       string
+	}{
+		{s: "3.5", defaultUnit: distance.Miles, miles: "3.5"},
+		{s: " 3.5 ", defaultUnit: distance.Miles, miles: "3.5"},
+		{s: "1.609344", defaultUnit: distance.Kilometers, miles: "1"},
+		{s: "1.609344km", defaultUnit: distance.Miles, miles: "1"},
+		{s: "3.1 mi", defaultUnit: distance.Kilometers, miles: "3.1"},
+		{s: "2 Miles", defaultUnit: distance.Kilometers, miles: "2"},
+		{s: "8.04672 kilometres", defaultUnit: distance.Miles, miles: "5"},
+	} {
+		miles, err := distance.Parse(tc.s, tc.defaultUnit)
+		require.NoErrorf(t, err, "s: %q", tc.s)
+		require.Truef(t, decimal.RequireFromString(tc.miles).Equal(miles), "s: %q, miles: %s", tc.s, miles)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "km", "abc", "5 furlongs", "1.2.3"} {
+		_, err := distance.Parse(s, distance.Miles)
+		require.Errorf(t, err, "s: %q", s)
+	}
+}
+
+func TestKilometersRoundTrip(t *testing.T) {
+	for _, s := range []string{"5", "0.1", "42.195", "10.000000000001", "123456.789012345678"} {
+		km := decimal.RequireFromString(s)
+		miles := distance.Kilometers.ToMiles(km)
+		require.Truef(t, km.Round(12).Equal(distance.Kilometers.FromMiles(miles)), "km: %s", s)
+	}
+}
+
+func TestMilesUnchanged(t *testing.T) {
+	miles := decimal.RequireFromString("3.14159")
+	require.True(t, miles.Equal(distance.Miles.ToMiles(miles)))
+	require.True(t, miles.Equal(distance.Miles.FromMiles(miles)))
+}
+
+func TestFromMilesForDisplay(t *testing.T) {
+	miles := distance.Kilometers.ToMiles(decimal.NewFromInt(5))
+	require.Equal(t, "3.11", distance.Miles.FromMilesForDisplay(miles).String())
+	require.Equal(t, "5", distance.Kilometers.FromMilesForDisplay(miles).String())
+	require.Equal(t, "2.5", distance.Miles.FromMilesForDisplay(decimal.RequireFromString("2.5")).String())
+}
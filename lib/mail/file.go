@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// FileMailer writes each message to a new .eml file in Dir instead of sending it. Dir is created if it does not exist.
+// It is intended for development and tests.
+type FileMailer struct {
+	Dir string
+
+	seq atomic.Int64
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	msgBytes, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// The sequence number keeps the names unique and in order when several messages are sent in the same nanosecond.
+	name := fmt.Sprintf("%d-%06d.eml", time.Now().UnixNano(), m.seq.Add(1))
+
+	// Write to a temporary name and rename so a reader never sees a partially written message.
+	tmpPath := filepath.Join(m.Dir, name+".tmp")
+	err = os.WriteFile(tmpPath, msgBytes, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(m.Dir, name))
+}
+
+// LogMailer logs messages instead of sending them. It is intended for development. The text body is logged so links in
+// the message can be copied from the log.
+type LogMailer struct {
+	Logger *zerolog.Logger
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg *Message) error {
+	m.Logger.Info().
+		Str("from", msg.From).
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Str("text_body", msg.TextBody).
+		Msg("email message")
+	return nil
+}
@@ -0,0 +1,204 @@
+package mail_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/web-starter-app/lib/mail"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageBytes(t *testing.T) {
+	for _, tc := range []struct {
+		testName string
+		msg      *mail.Message
+	}{
+		{
+			testName: "text only",
+			msg: &mail.Message{
+				From:     "App <app@example.com>",
+				To:       "user@example.com",
+				Subject:  "Héllo",
+				TextBody: "Visit https://example.com/?a=1&b=2 now.",
+			},
+		},
+		{
+			testName: "text and HTML",
+			msg: &mail.Message{
+				From:     "App <app@example.com>",
+				To:       "user@example.com",
+				Subject:  "Hello",
+				TextBody: "Visit https://example.com/?a=1&b=2 now.",
+				HTMLBody: `<p>Visit <a href="https://example.com/?a=1&amp;b=2">this link</a> now.</p>`,
+			},
+		},
+	} {
+		t.Run(tc.testName, func(t *testing.T) {
+			msgBytes, err := tc.msg.Bytes()
+			require.NoError(t, err)
+
+			textBody, htmlBody, subject := parseMessage(t, msgBytes)
+			require.Equal(t, tc.msg.Subject, subject)
+			require.Equal(t, tc.msg.TextBody, textBody)
+			require.Equal(t, tc.msg.HTMLBody, htmlBody)
+		})
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := &mail.FileMailer{Dir: dir}
+
+	for _, subject := range []string{"First", "Second"} {
+		err := mailer.Send(context.Background(), &mail.Message{
+			From:     "app@example.com",
+			To:       "user@example.com",
+			Subject:  subject,
+			TextBody: "Body",
+		})
+		require.NoError(t, err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+
+	for i, subject := range []string{"First", "Second"} {
+		msgBytes, err := os.ReadFile(matches[i])
+		require.NoError(t, err)
+		_, _, actualSubject := parseMessage(t, msgBytes)
+		require.Equal(t, subject, actualSubject)
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	type received struct {
+		from string
+		to   string
+		data string
+	}
+	receivedChan := make(chan received, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var r received
+		br := bufio.NewReader(conn)
+		io.WriteString(conn, "220 localhost ESMTP\r\n")
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				io.WriteString(conn, "250 localhost\r\n")
+			case strings.HasPrefix(line, "MAIL FROM:"):
+				r.from = strings.TrimPrefix(line, "MAIL FROM:")
+				io.WriteString(conn, "250 OK\r\n")
+			case strings.HasPrefix(line, "RCPT TO:"):
+				r.to = strings.TrimPrefix(line, "RCPT TO:")
+				io.WriteString(conn, "250 OK\r\n")
+			case line == "DATA":
+				io.WriteString(conn, "354 Go ahead\r\n")
+				sb := &strings.Builder{}
+				for {
+					dataLine, err := br.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dataLine == ".\r\n" {
+						break
+					}
+					sb.WriteString(dataLine)
+				}
+				r.data = sb.String()
+				io.WriteString(conn, "250 OK\r\n")
+			case line == "QUIT":
+				io.WriteString(conn, "221 Bye\r\n")
+				receivedChan <- r
+				return
+			default:
+				io.WriteString(conn, "502 Not implemented\r\n")
+			}
+		}
+	}()
+
+	mailer := &mail.SMTPMailer{Addr: ln.Addr().String()}
+	err = mailer.Send(context.Background(), &mail.Message{
+		From:     "App <app@example.com>",
+		To:       "User <user@example.com>",
+		Subject:  "Hello",
+		TextBody: "Body",
+	})
+	require.NoError(t, err)
+
+	r := <-receivedChan
+	require.Equal(t, "<app@example.com>", r.from)
+	require.Equal(t, "<user@example.com>", r.to)
+
+	textBody, _, subject := parseMessage(t, []byte(r.data))
+	require.Equal(t, "Hello", subject)
+	// The SMTP client terminates the message with a line break before the final dot.
+	require.Equal(t, "Body\r\n", textBody)
+}
+
+func parseMessage(t *testing.T, msgBytes []byte) (textBody, htmlBody, subject string) {
+	t.Helper()
+
+	msg, err := netmail.ReadMessage(strings.NewReader(string(msgBytes)))
+	require.NoError(t, err)
+
+	subject, err = (&mime.WordDecoder{}).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+
+	if mediaType == "text/plain" {
+		body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+		require.NoError(t, err)
+		return string(body), "", subject
+	}
+
+	require.Equal(t, "multipart/alternative", mediaType)
+	mpr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mpr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		// multipart.Reader transparently decodes quoted-printable parts.
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+
+		switch part.Header.Get("Content-Type") {
+		case "text/plain; charset=utf-8":
+			textBody = string(body)
+		case "text/html; charset=utf-8":
+			htmlBody = string(body)
+		}
+	}
+
+	return textBody, htmlBody, subject
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+// SMTPMailer sends messages through an SMTP server. STARTTLS is used when the server supports it.
+type SMTPMailer struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+
+	// Username and Password are used for PLAIN authentication if Username is not empty. net/smtp refuses to send
+	// credentials over an unencrypted connection to anything other than localhost.
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	msgBytes, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	// smtp.SendMail does not take a context. The message is small so the only long wait would be a slow server.
+	return smtp.SendMail(m.Addr, auth, from.Address, []string{to.Address}, msgBytes)
+}
@@ -0,0 +1,117 @@
+// Package realip determines the IP address of the client that made a request through reverse proxies.
+//
+// The X-Forwarded-For and X-Real-IP headers are set by the client as easily as by a proxy. They are only trusted when the
+// request comes from a configured proxy.
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefixes parses a comma separated list of IP addresses and CIDR prefixes such as "10.0.0.0/8, 127.0.0.1". An IP
+// address is a prefix that only contains that address.
+func ParsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or prefix: %q", field)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// ClientIP returns the IP address of the client that made r. When r comes from one of trustedProxies the address is
+// the rightmost address in X-Forwarded-For that is not a trusted proxy. Addresses further left could have been forged by
+// the client. X-Real-IP is used when a trusted proxy does not send X-Forwarded-For. Otherwise it is the address r came
+// from.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+
+	if !trusted(remoteIP, trustedProxies) {
+		return remoteIP
+	}
+
+	var forwardedFor []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(value, ",") {
+			forwardedFor = append(forwardedFor, strings.TrimSpace(ip))
+		}
+	}
+
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip := forwardedFor[i]
+		if _, err := netip.ParseAddr(ip); err != nil {
+			// A malformed address means the rest of the header cannot be trusted.
+			return remoteIP
+		}
+		if !trusted(ip, trustedProxies) {
+			return ip
+		}
+		remoteIP = ip
+	}
+
+	if len(forwardedFor) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			if _, err := netip.ParseAddr(realIP); err == nil {
+				return realIP
+			}
+		}
+	}
+
+	return remoteIP
+}
+
+// trusted returns true if ip is in one of trustedProxies.
+func trusted(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handler returns a middleware handler that replaces r.RemoteAddr with the ClientIP of r.
+func Handler(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if len(trustedProxies) > 0 {
+				r.RemoteAddr = ClientIP(r, trustedProxies)
+			}
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
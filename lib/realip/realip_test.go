@@ -0,0 +1,55 @@
+package realip_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/web-starter-app/lib/realip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := realip.ParsePrefixes(" 10.0.0.0/8, 127.0.0.1,::1 ,")
+	require.NoError(t, err)
+	require.Len(t, prefixes, 3)
+	require.Equal(t, "10.0.0.0/8", prefixes[0].String())
+	require.Equal(t, "127.0.0.1/32", prefixes[1].String())
+	require.Equal(t, "::1/128", prefixes[2].String())
+
+	_, err = realip.ParsePrefixes("10.0.0.0/8, example.com")
+	require.Error(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies, err := realip.ParsePrefixes("10.0.0.0/8")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		expectedIP   string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.1:1234", expectedIP: "203.0.113.1"},
+		{name: "untrusted proxy", remoteAddr: "203.0.113.1:1234", forwardedFor: []string{"198.51.100.1"}, expectedIP: "203.0.113.1"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1"}, expectedIP: "198.51.100.1"},
+		{name: "forged address", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.1, 198.51.100.1"}, expectedIP: "198.51.100.1"},
+		{name: "chained proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1", "10.0.0.2"}, expectedIP: "198.51.100.1"},
+		{name: "only proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"10.0.0.3, 10.0.0.2"}, expectedIP: "10.0.0.3"},
+		{name: "malformed", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1, garbage"}, expectedIP: "10.0.0.1"},
+		{name: "real ip", remoteAddr: "10.0.0.1:1234", realIP: "198.51.100.1", expectedIP: "198.51.100.1"},
+		{name: "untrusted real ip", remoteAddr: "203.0.113.1:1234", realIP: "198.51.100.1", expectedIP: "203.0.113.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for _, value := range tc.forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+
+		require.Equalf(t, tc.expectedIP, realip.ClientIP(r, trustedProxies), "%s", tc.name)
+	}
+}
@@ -0,0 +1,111 @@
+// Package totp implements the time-based one-time password algorithm defined in RFC 6238.
+//
+// Only the parameters supported by common authenticator apps are implemented: HMAC-SHA1, 6 digit codes, and a 30
+// second time step.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Period is the time step. Each code is valid for one period.
+	Period = 30 * time.Second
+
+	// Digits is the number of digits in a code.
+	Digits = 6
+
+	// SecretLen is the length in bytes of secrets returned by GenerateSecret. RFC 4226 recommends 160 bits.
+	SecretLen = 20
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretLen)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret returns secret in the base32 format users type into authenticator apps.
+func EncodeSecret(secret []byte) string {
+	return base32NoPadding.EncodeToString(secret)
+}
+
+// URI returns the otpauth URI for secret. It is typically presented to the user as a QR code.
+//
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func URI(issuer, accountName string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + accountName,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// Step returns the time step that contains t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// CodeAtStep returns the code for secret at step.
+func CodeAtStep(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation as defined in RFC 4226 section 5.3.
+	offset := sum[len(sum)-1] & 0x0f
+	binCode := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, binCode%1_000_000)
+}
+
+// Code returns the code for secret at time t.
+func Code(secret []byte, t time.Time) string {
+	return CodeAtStep(secret, Step(t))
+}
+
+// Validate checks whether code is valid for secret at time t. To allow for clock drift codes from up to skew steps
+// before or after t are accepted. If the code is valid the matching step is returned. Callers should record the step and
+// reject any later code with a step less than or equal to it to prevent replay.
+func Validate(secret []byte, code string, t time.Time, skew int64) (step int64, ok bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for s := current - skew; s <= current+skew; s++ {
+		if subtle.ConstantTimeCompare([]byte(CodeAtStep(secret, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
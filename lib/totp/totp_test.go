@@ -0,0 +1,56 @@
+package totp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/web-starter-app/lib/totp"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA1 secret from the RFC 6238 appendix B test vectors.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The RFC test vectors are 8 digits. The 6 digit codes are the last 6 digits.
+	for _, tc := range []struct {
+		unixTime int64
+		code     string
+	}{
+		{unixTime: 59, code: "287082"},
+		{unixTime: 1111111109, code: "081804"},
+		{unixTime: 1111111111, code: "050471"},
+		{unixTime: 1234567890, code: "005924"},
+		{unixTime: 2000000000, code: "279037"},
+		{unixTime: 20000000000, code: "353130"},
+	} {
+		require.Equalf(t, tc.code, totp.Code(rfc6238Secret, time.Unix(tc.unixTime, 0)), "unixTime: %d", tc.unixTime)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code := totp.Code(rfc6238Secret, now)
+
+	step, ok := totp.Validate(rfc6238Secret, code, now, 1)
+	require.True(t, ok)
+	require.Equal(t, totp.Step(now), step)
+
+	step, ok = totp.Validate(rfc6238Secret, code, now.Add(totp.Period), 1)
+	require.True(t, ok)
+	require.Equal(t, totp.Step(now), step)
+
+	_, ok = totp.Validate(rfc6238Secret, code, now.Add(2*totp.Period), 1)
+	require.False(t, ok)
+
+	_, ok = totp.Validate(rfc6238Secret, "000000", now, 1)
+	require.False(t, ok)
+
+	_, ok = totp.Validate(rfc6238Secret, "", now, 1)
+	require.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := totp.URI("web-starter-app", "jack", rfc6238Secret)
+	require.Equal(t, "otpauth://totp/web-starter-app:jack?algorithm=SHA1&digits=6&issuer=web-starter-app&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", uri)
+}
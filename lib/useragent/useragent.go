@@ -0,0 +1,88 @@
+// Package useragent extracts a human readable browser and operating system from a User-Agent header.
+//
+// It is not a complete User-Agent parser. It recognizes the common desktop and mobile browsers well enough to let a
+// user identify their own devices.
+package useragent
+
+import (
+	"regexp"
+	"strings"
+)
+
+// UserAgent is the result of parsing a User-Agent header. Fields that could not be determined are empty.
+type UserAgent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+}
+
+// String returns a short description such as "Firefox 133 on Linux".
+func (ua UserAgent) String() string {
+	browser := ua.Browser
+	if browser == "" {
+		browser = "Unknown browser"
+	} else if ua.BrowserVersion != "" {
+		browser = browser + " " + ua.BrowserVersion
+	}
+
+	if ua.OS == "" {
+		return browser
+	}
+
+	return browser + " on " + ua.OS
+}
+
+type browserMatcher struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// browserMatchers are checked in order. Order matters because many browsers include the tokens of the browsers they
+// are derived from. e.g. Edge includes Chrome and Safari and Chrome includes Safari.
+var browserMatchers = []browserMatcher{
+	{name: "Edge", re: regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+)`)},
+	{name: "Opera", re: regexp.MustCompile(`(?:OPR|Opera)/(\d+)`)},
+	{name: "Samsung Internet", re: regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+	{name: "Firefox", re: regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+	{name: "Chrome", re: regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+	{name: "Safari", re: regexp.MustCompile(`Version/(\d+)[.\d]* (?:Mobile/\S+ )?Safari/`)},
+}
+
+type osMatcher struct {
+	name   string
+	substr string
+}
+
+// osMatchers are checked in order for the same reason as browserMatchers. e.g. Android includes Linux and iOS includes
+// "like Mac OS X".
+var osMatchers = []osMatcher{
+	{name: "iOS", substr: "iPhone"},
+	{name: "iPadOS", substr: "iPad"},
+	{name: "Android", substr: "Android"},
+	{name: "ChromeOS", substr: "CrOS"},
+	{name: "Windows", substr: "Windows"},
+	{name: "macOS", substr: "Macintosh"},
+	{name: "Linux", substr: "Linux"},
+}
+
+// Parse parses s.
+func Parse(s string) UserAgent {
+	var ua UserAgent
+
+	for _, m := range browserMatchers {
+		if match := m.re.FindStringSubmatch(s); match != nil {
+			ua.Browser = m.name
+			ua.BrowserVersion = match[1]
+			break
+		}
+	}
+
+	for _, m := range osMatchers {
+		if strings.Contains(s, m.substr) {
+			ua.OS = m.name
+			break
+		}
+	}
+
+	return ua
+}
@@ -0,0 +1,72 @@
+package useragent_test
+
+import (
+	"testing"
+
+	"github.com/jackc/web-starter-app/lib/useragent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		testName  string
+		userAgent string
+		expected  useragent.UserAgent
+		str       string
+	}{
+		{
+			testName:  "empty",
+			userAgent: "",
+			expected:  useragent.UserAgent{},
+			str:       "Unknown browser",
+		},
+		{
+			testName:  "Chrome on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+			expected:  useragent.UserAgent{Browser: "Chrome", BrowserVersion: "131", OS: "Windows"},
+			str:       "Chrome 131 on Windows",
+		},
+		{
+			testName:  "Edge on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36 Edg/131.0.2903.86",
+			expected:  useragent.UserAgent{Browser: "Edge", BrowserVersion: "131", OS: "Windows"},
+			str:       "Edge 131 on Windows",
+		},
+		{
+			testName:  "Firefox on Linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:133.0) Gecko/20100101 Firefox/133.0",
+			expected:  useragent.UserAgent{Browser: "Firefox", BrowserVersion: "133", OS: "Linux"},
+			str:       "Firefox 133 on Linux",
+		},
+		{
+			testName:  "Safari on macOS",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.2 Safari/605.1.15",
+			expected:  useragent.UserAgent{Browser: "Safari", BrowserVersion: "18", OS: "macOS"},
+			str:       "Safari 18 on macOS",
+		},
+		{
+			testName:  "Safari on iOS",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 18_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.2 Mobile/15E148 Safari/604.1",
+			expected:  useragent.UserAgent{Browser: "Safari", BrowserVersion: "18", OS: "iOS"},
+			str:       "Safari 18 on iOS",
+		},
+		{
+			testName:  "Chrome on Android",
+			userAgent: "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Mobile Safari/537.36",
+			expected:  useragent.UserAgent{Browser: "Chrome", BrowserVersion: "131", OS: "Android"},
+			str:       "Chrome 131 on Android",
+		},
+		{
+			testName:  "unknown browser on known OS",
+			userAgent: "SomeBot/1.0 (Linux)",
+			expected:  useragent.UserAgent{OS: "Linux"},
+			str:       "Unknown browser on Linux",
+		},
+	} {
+		t.Run(tc.testName, func(t *testing.T) {
+			ua := useragent.Parse(tc.userAgent)
+			require.Equal(t, tc.expected, ua)
+			require.Equal(t, tc.str, ua.String())
+		})
+	}
+}
@@ -0,0 +1,34 @@
+create table user_totp_secrets (
+	user_id uuid primary key references users,
+	secret bytea not null,
+	enabled_time timestamptz,
+	last_used_step bigint not null default 0,
+	insert_time timestamptz not null default now(),
+	update_time timestamptz not null default now()
+);
+
+create trigger on_user_totp_secret_update
+before update on user_totp_secrets
+for each row execute procedure timestamp_update();
+
+grant select, insert, update, delete on user_totp_secrets to {{.app_user}};
+
+create table user_recovery_codes (
+	id uuid primary key,
+	user_id uuid not null references users,
+	digest bytea not null,
+	used_time timestamptz,
+	insert_time timestamptz not null default now()
+);
+
+create index on user_recovery_codes (user_id);
+
+grant select, insert, update, delete on user_recovery_codes to {{.app_user}};
+
+alter table users add column two_factor_required boolean not null default false;
+
+---- create above / drop below ----
+
+alter table users drop column two_factor_required;
+drop table user_recovery_codes;
+drop table user_totp_secrets;
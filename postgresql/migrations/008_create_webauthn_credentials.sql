@@ -0,0 +1,22 @@
+create table webauthn_credentials (
+	id uuid primary key,
+	user_id uuid not null references users,
+	name text not null,
+	credential_id bytea not null unique,
+	credential jsonb not null,
+	last_used_time timestamptz,
+	insert_time timestamptz not null default now(),
+	update_time timestamptz not null default now()
+);
+
+create index on webauthn_credentials (user_id);
+
+create trigger on_webauthn_credential_update
+before update on webauthn_credentials
+for each row execute procedure timestamp_update();
+
+grant select, insert, update, delete on webauthn_credentials to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table webauthn_credentials;
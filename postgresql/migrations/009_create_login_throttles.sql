@@ -0,0 +1,14 @@
+create table login_throttles (
+	kind text not null,
+	key text not null,
+	failure_count int not null,
+	last_failure_time timestamptz not null,
+	locked_until timestamptz,
+	primary key (kind, key)
+);
+
+grant select, insert, update, delete on login_throttles to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table login_throttles;
@@ -0,0 +1,21 @@
+alter table users add column email text;
+
+create unique index on users (lower(email));
+
+create table password_reset_tokens (
+	id uuid primary key,
+	user_id uuid not null references users,
+	token_digest bytea not null unique,
+	expiration_time timestamptz not null,
+	used_time timestamptz,
+	insert_time timestamptz not null default now()
+);
+
+create index on password_reset_tokens (user_id);
+
+grant select, insert, update, delete on password_reset_tokens to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table password_reset_tokens;
+alter table users drop column email;
@@ -0,0 +1,31 @@
+alter table users add column email_verified_time timestamptz;
+
+-- Email addresses that existing users already have were entered by system users.
+update users set email_verified_time = now() where email is not null;
+
+-- An email address only belongs to the user that verified it. Anyone could otherwise reserve an address before its
+-- owner registers. Several unverified users may claim the same address but only one can verify it.
+drop index users_lower_idx;
+
+create unique index users_verified_email_idx on users (lower(email)) where email_verified_time is not null;
+
+create table email_verification_tokens (
+	id uuid primary key,
+	user_id uuid not null references users,
+	email text not null,
+	token_digest bytea not null unique,
+	expiration_time timestamptz not null,
+	used_time timestamptz,
+	insert_time timestamptz not null default now()
+);
+
+create index on email_verification_tokens (user_id);
+
+grant select, insert, update, delete on email_verification_tokens to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table email_verification_tokens;
+drop index users_verified_email_idx;
+create unique index on users (lower(email));
+alter table users drop column email_verified_time;
@@ -0,0 +1,5 @@
+alter table login_sessions add column persistent boolean not null default false;
+
+---- create above / drop below ----
+
+alter table login_sessions drop column persistent;
@@ -0,0 +1,29 @@
+-- An impersonation login session belongs to the impersonated user. It references the system user's own login session
+-- so the system user can return to it and so the impersonation ends when that session does.
+alter table login_sessions add column impersonator_login_session_id uuid references login_sessions on delete cascade;
+
+create index on login_sessions (impersonator_login_session_id);
+
+-- impersonations is an audit trail. It does not reference users so that it outlives the users it records.
+create table impersonations (
+	id uuid primary key,
+	impersonator_user_id uuid not null,
+	impersonator_username text not null,
+	impersonated_user_id uuid not null,
+	impersonated_username text not null,
+	login_session_id uuid not null,
+	client_ip text not null,
+	start_time timestamptz not null,
+	end_time timestamptz
+);
+
+create index on impersonations (impersonator_user_id);
+create index on impersonations (impersonated_user_id);
+create index on impersonations (login_session_id);
+
+grant select, insert, update on impersonations to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table impersonations;
+alter table login_sessions drop column impersonator_login_session_id;
@@ -0,0 +1,18 @@
+create table user_identities (
+	id uuid primary key,
+	user_id uuid not null references users,
+	issuer text not null,
+	subject text not null,
+	email text,
+	last_login_time timestamptz,
+	insert_time timestamptz not null default now(),
+	unique (issuer, subject)
+);
+
+create index on user_identities (user_id);
+
+grant select, insert, update, delete on user_identities to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table user_identities;
@@ -0,0 +1,17 @@
+create table api_tokens (
+	id uuid primary key,
+	user_id uuid not null references users,
+	name text not null,
+	scope text not null check (scope in ('walks:read', 'walks:write')),
+	token_digest bytea not null unique,
+	last_used_time timestamptz,
+	insert_time timestamptz not null default now()
+);
+
+create index on api_tokens (user_id);
+
+grant select, insert, update, delete on api_tokens to {{.app_user}};
+
+---- create above / drop below ----
+
+drop table api_tokens;
@@ -0,0 +1,6 @@
+-- A password set by an operator is temporary. It must be changed after login and cannot be used after it expires.
+alter table user_passwords add column temporary_expire_time timestamptz;
+
+---- create above / drop below ----
+
+alter table user_passwords drop column temporary_expire_time;
@@ -0,0 +1,60 @@
+-- Application instances cache login sessions. These triggers notify them when a cached login session or the user it
+-- belongs to changes.
+create function notify_login_session_cache_login_session() returns trigger
+language plpgsql
+as $$
+  begin
+    perform pg_notify('login_session_cache', 'login_session:' || old.id);
+    return null;
+  end;
+$$;
+
+-- Touching approximate_last_request_time does not notify. It happens about once a minute for every active login
+-- session and would otherwise evict the entry that was just cached. Instances reload a cached login session that
+-- appears to have expired so an old approximate_last_request_time cannot end a login session early.
+create trigger notify_login_session_cache
+after update of id, user_id, login_time, persistent, impersonator_login_session_id or delete on login_sessions
+for each row execute procedure notify_login_session_cache_login_session();
+
+create function notify_login_session_cache_user() returns trigger
+language plpgsql
+as $$
+  begin
+    if tg_op = 'DELETE' then
+      perform pg_notify('login_session_cache', 'user:' || old.id);
+    else
+      perform pg_notify('login_session_cache', 'user:' || new.id);
+    end if;
+    return null;
+  end;
+$$;
+
+create trigger notify_login_session_cache
+after update or delete on users
+for each row execute procedure notify_login_session_cache_user();
+
+create function notify_login_session_cache_user_password() returns trigger
+language plpgsql
+as $$
+  begin
+    if tg_op = 'DELETE' then
+      perform pg_notify('login_session_cache', 'user:' || old.user_id);
+    else
+      perform pg_notify('login_session_cache', 'user:' || new.user_id);
+    end if;
+    return null;
+  end;
+$$;
+
+create trigger notify_login_session_cache
+after insert or update or delete on user_passwords
+for each row execute procedure notify_login_session_cache_user_password();
+
+---- create above / drop below ----
+
+drop trigger notify_login_session_cache on user_passwords;
+drop function notify_login_session_cache_user_password();
+drop trigger notify_login_session_cache on users;
+drop function notify_login_session_cache_user();
+drop trigger notify_login_session_cache on login_sessions;
+drop function notify_login_session_cache_login_session();
@@ -0,0 +1,93 @@
+-- Permissions are defined by the application. Roles group permissions and are assigned to users. The users.system flag
+-- is replaced by the admin role which has every permission.
+create table roles (
+	id uuid primary key,
+	name text not null unique,
+	insert_time timestamptz not null default now()
+);
+
+create table role_permissions (
+	role_id uuid not null references roles on delete cascade,
+	permission text not null,
+	primary key (role_id, permission)
+);
+
+create table user_roles (
+	user_id uuid not null references users on delete cascade,
+	role_id uuid not null references roles on delete cascade,
+	primary key (user_id, role_id)
+);
+
+create index on user_roles (role_id);
+
+grant select, insert, update, delete on roles to {{.app_user}};
+grant select, insert, update, delete on role_permissions to {{.app_user}};
+grant select, insert, update, delete on user_roles to {{.app_user}};
+
+insert into roles (id, name) values (gen_random_uuid(), 'admin');
+
+insert into role_permissions (role_id, permission)
+select roles.id, permission
+from roles, unnest(array['users.manage', 'users.impersonate', 'roles.manage']) permission
+where roles.name = 'admin';
+
+insert into user_roles (user_id, role_id)
+select users.id, roles.id
+from users, roles
+where users.system and roles.name = 'admin';
+
+alter table users drop column system;
+
+-- Cached login sessions include the user's permissions.
+create function notify_login_session_cache_user_role() returns trigger
+language plpgsql
+as $$
+  begin
+    if tg_op = 'DELETE' then
+      perform pg_notify('login_session_cache', 'user:' || old.user_id);
+    else
+      perform pg_notify('login_session_cache', 'user:' || new.user_id);
+    end if;
+    return null;
+  end;
+$$;
+
+create trigger notify_login_session_cache
+after insert or update or delete on user_roles
+for each row execute procedure notify_login_session_cache_user_role();
+
+create function notify_login_session_cache_all() returns trigger
+language plpgsql
+as $$
+  begin
+    perform pg_notify('login_session_cache', 'all');
+    return null;
+  end;
+$$;
+
+create trigger notify_login_session_cache
+after insert or update or delete on role_permissions
+for each statement execute procedure notify_login_session_cache_all();
+
+---- create above / drop below ----
+
+drop trigger notify_login_session_cache on role_permissions;
+drop function notify_login_session_cache_all();
+drop trigger notify_login_session_cache on user_roles;
+drop function notify_login_session_cache_user_role();
+
+alter table users add column system boolean not null default false;
+
+update users
+set system = true
+where exists (
+	select 1
+	from user_roles
+		join roles on user_roles.role_id = roles.id
+	where user_roles.user_id = users.id
+		and roles.name = 'admin'
+);
+
+drop table user_roles;
+drop table role_permissions;
+drop table roles;
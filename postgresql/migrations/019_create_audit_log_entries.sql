@@ -0,0 +1,33 @@
+-- audit_log_entries records administrative changes. It does not reference users or roles so that it outlives what it
+-- records. actor_user_id is null when the change was made from the command line. In that case actor_username is the
+-- operating system user that ran the command. The application can only add entries.
+create table audit_log_entries (
+	id uuid primary key,
+	time timestamptz not null default now(),
+	action text not null,
+	actor_user_id uuid,
+	actor_username text not null,
+	target_id uuid,
+	target_name text,
+	request_id text,
+	client_ip text,
+	changes jsonb
+);
+
+create index on audit_log_entries (time);
+create index on audit_log_entries (action);
+create index on audit_log_entries (actor_user_id);
+create index on audit_log_entries (target_id);
+
+grant select, insert on audit_log_entries to {{.app_user}};
+
+insert into role_permissions (role_id, permission)
+select id, 'audit.read'
+from roles
+where name = 'admin';
+
+---- create above / drop below ----
+
+delete from role_permissions where permission = 'audit.read';
+
+drop table audit_log_entries;
@@ -0,0 +1,6 @@
+-- A suspended user cannot log in. Their data is kept so they can be reactivated.
+alter table users add column disabled_at timestamptz;
+
+---- create above / drop below ----
+
+alter table users drop column disabled_at;
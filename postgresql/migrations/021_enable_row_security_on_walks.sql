@@ -0,0 +1,17 @@
+-- Walks can only be seen and changed by the user in app.current_user_id. Requests set it for the length of their
+-- transaction. System operations such as permanently deleting a user set app.bypass_row_security instead. A connection
+-- with neither set sees no walks at all.
+--
+-- The credential tables are not covered. They are read to authenticate a request before its user is known.
+alter table walks enable row level security;
+
+create policy walks_owner on walks
+	using (
+		user_id = nullif(current_setting('app.current_user_id', true), '')::uuid
+		or current_setting('app.bypass_row_security', true) = 'on'
+	);
+
+---- create above / drop below ----
+
+drop policy walks_owner on walks;
+alter table walks disable row level security;
@@ -0,0 +1,86 @@
+create table organizations (
+	id uuid primary key,
+	name text not null check (name <> ''),
+	insert_time timestamptz not null default now()
+);
+
+grant select, insert, update, delete on organizations to {{.app_user}};
+
+create table organization_memberships (
+	organization_id uuid not null references organizations on delete cascade,
+	user_id uuid not null references users,
+	role text not null check (role in ('owner', 'member')),
+	insert_time timestamptz not null default now(),
+	primary key (organization_id, user_id)
+);
+
+create index on organization_memberships (user_id);
+
+grant select, insert, update, delete on organization_memberships to {{.app_user}};
+
+-- An invitation link can be used by any number of people until it expires or is revoked.
+create table organization_invitations (
+	id uuid primary key,
+	organization_id uuid not null references organizations on delete cascade,
+	token_digest bytea not null unique,
+	expiration_time timestamptz not null,
+	insert_time timestamptz not null default now()
+);
+
+create index on organization_invitations (organization_id);
+
+grant select, insert, update, delete on organization_invitations to {{.app_user}};
+
+-- current_organization_id is the organization chosen with the organization switcher. It is null when the user is working
+-- with their personal walk log.
+alter table users add column current_organization_id uuid references organizations on delete set null;
+
+-- A walk attributed to an organization can be seen by every member of the organization. Only the user that logged it can
+-- change it.
+alter table walks add column organization_id uuid references organizations on delete set null;
+
+create index on walks (organization_id);
+
+create policy walks_teammates on walks
+	for select
+	using (
+		organization_id in (
+			select organization_id
+			from organization_memberships
+			where user_id = nullif(current_setting('app.current_user_id', true), '')::uuid
+		)
+	);
+
+-- Login sessions cache the user's organizations for the organization switcher.
+create function notify_login_session_cache_organization_membership() returns trigger
+language plpgsql
+as $$
+  begin
+    if tg_op = 'DELETE' then
+      perform pg_notify('login_session_cache', 'user:' || old.user_id);
+    else
+      perform pg_notify('login_session_cache', 'user:' || new.user_id);
+    end if;
+    return null;
+  end;
+$$;
+
+create trigger notify_login_session_cache
+after insert or update or delete on organization_memberships
+for each row execute procedure notify_login_session_cache_organization_membership();
+
+create trigger notify_login_session_cache
+after update on organizations
+for each statement execute procedure notify_login_session_cache_all();
+
+---- create above / drop below ----
+
+drop trigger notify_login_session_cache on organizations;
+drop trigger notify_login_session_cache on organization_memberships;
+drop function notify_login_session_cache_organization_membership();
+drop policy walks_teammates on walks;
+alter table walks drop column organization_id;
+alter table users drop column current_organization_id;
+drop table organization_invitations;
+drop table organization_memberships;
+drop table organizations;
@@ -0,0 +1,40 @@
+-- A share link shows a single walk or, when walk_id is null, the user's whole personal walk log to anyone with the link.
+create table share_links (
+	id uuid primary key,
+	user_id uuid not null references users,
+	walk_id uuid references walks on delete cascade,
+	token_digest bytea not null unique,
+	expiration_time timestamptz not null,
+	view_count bigint not null default 0,
+	insert_time timestamptz not null default now()
+);
+
+create index on share_links (user_id);
+create index on share_links (walk_id);
+
+grant select, insert, update, delete on share_links to {{.app_user}};
+
+-- Share links can only be seen and changed by the user in app.current_user_id like walks. Viewing a share link sets
+-- app.bypass_row_security as the viewer is not a user.
+--
+-- The other tables with a user_id are deliberately not covered:
+--
+-- * users, login_sessions, user_passwords, user_totp_secrets, user_recovery_codes, webauthn_credentials,
+--   user_identities, api_tokens, password_reset_tokens, email_verification_tokens and login_throttles are read to
+--   authenticate a request before its user is known.
+-- * organization_memberships is read by the walks_teammates policy and when loading a login session. A policy on it
+--   that refers to organization_memberships would recurse. organizations and organization_invitations are read to
+--   accept an invitation before the user is a member. Access to organizations is checked with the member's role.
+-- * roles, role_permissions, user_roles, impersonations and audit_log_entries are administrative and are read across
+--   all users by the /system pages.
+alter table share_links enable row level security;
+
+create policy share_links_owner on share_links
+	using (
+		user_id = nullif(current_setting('app.current_user_id', true), '')::uuid
+		or current_setting('app.bypass_row_security', true) = 'on'
+	);
+
+---- create above / drop below ----
+
+drop table share_links;
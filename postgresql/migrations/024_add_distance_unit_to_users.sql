@@ -0,0 +1,6 @@
+-- Walks are always stored in miles. distance_unit is only how the user enters and sees distances.
+alter table users add column distance_unit text not null default 'mi' check (distance_unit in ('mi', 'km'));
+
+---- create above / drop below ----
+
+alter table users drop column distance_unit;
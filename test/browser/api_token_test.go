@@ -0,0 +1,115 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	page.ClickOn("API Tokens")
+	page.FillIn("Token Name", "read token")
+	page.ClickOn("Create token")
+	page.HasContent("#newAPIToken", "It will not be shown again.")
+	readToken := page.MustElement("#newAPIToken code").MustText()
+
+	page.FillIn("Token Name", "write token")
+	page.MustElement("select[name=scope]").MustSelect("Read and write walks")
+	page.ClickOn("Create token")
+	page.HasContent("#newAPIToken", "It will not be shown again.")
+	writeToken := page.MustElement("#newAPIToken code").MustText()
+
+	page.HasContent("td", "read token")
+	page.HasContent("td", "walks:write")
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	request := func(method, path, token string, form url.Values) *http.Response {
+		req, err := http.NewRequest(method, serverInstance.Server.URL+path, strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := client.Do(req)
+		require.NoError(t, err)
+		response.Body.Close()
+		return response
+	}
+	walk := url.Values{"duration": {"30m"}, "distance": {"1.5"}}
+
+	// Token authenticated requests do not need a CSRF token.
+	response := request("POST", "/walks", writeToken, walk)
+	require.Equal(t, http.StatusSeeOther, response.StatusCode)
+
+	// Clients written before distances could be entered in other units send distanceInMiles.
+	response = request("POST", "/walks", writeToken, url.Values{"duration": {"20m"}, "distanceInMiles": {"0.75"}})
+	require.Equal(t, http.StatusSeeOther, response.StatusCode)
+
+	response = request("GET", "/", readToken, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	response = request("POST", "/walks", readToken, walk)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	response = request("GET", "/change_password", writeToken, nil)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	response = request("POST", "/walks", "wsa_invalid", walk)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Without a token the request is still subject to CSRF protection.
+	response = request("POST", "/walks", "", walk)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	var walkCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from walks where user_id = $1", userID).Scan(&walkCount)
+	require.NoError(t, err)
+	require.Equal(t, 2, walkCount)
+
+	page.MustNavigate(fmt.Sprintf("%s/api_tokens", serverInstance.Server.URL))
+	page.MustElementR("tr", "write token").MustElement("button").MustClick()
+	page.DoesNotHaveContent("td", "write token")
+
+	response = request("POST", "/walks", writeToken, walk)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// A required password change does not redirect token authenticated requests to a form the client cannot use.
+	err = db.ResetUserPassword(ctx, dbconn, userID, "temporary", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	response = request("GET", "/", readToken, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+}
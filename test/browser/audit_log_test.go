@@ -0,0 +1,120 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/test/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	adminUserID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": adminUserID, "username": "admin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, adminUserID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, adminUserID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "admin")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, admin!")
+	page.ClickOn("Users")
+	page.ClickOn("New User")
+
+	page.FillIn("Username", "testuser")
+	page.ClickOn("Save")
+	page.HasContent("span", "testuser")
+
+	var userID uuid.UUID
+	err = dbconn.QueryRow(ctx, "select id from users where username = 'testuser'").Scan(&userID)
+	require.NoError(t, err)
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s/edit", serverInstance.Server.URL, userID))
+	page.FillIn("Email", "testuser@example.com")
+	page.ElementByLabel("admin").MustClick()
+	page.ClickOn("Save")
+	page.HasContent("a", "New User")
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Audit Log")
+
+	page.HasContent("td", "user.create")
+	page.HasContent("li", `email: "" → "testuser@example.com"`)
+	page.HasContent("li", `roles: \[\] → \["admin"\]`)
+
+	page.MustElement("select[name=action]").MustSelect("user.create")
+	page.ClickOn("Filter")
+	page.HasContent("td", "user.create")
+	page.DoesNotHaveContent("td", "user.update")
+
+	entries, err := db.SelectAuditLogEntries(ctx, dbconn, &db.AuditLogFilter{Target: "testuser"}, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, db.AuditActionUserUpdate, entries[0].Action)
+	require.Equal(t, "admin", entries[0].ActorUsername)
+	require.Equal(t, uuid.NullUUID{UUID: adminUserID, Valid: true}, entries[0].ActorUserID)
+	require.NotEmpty(t, entries[0].RequestID)
+	require.NotEmpty(t, entries[0].ClientIP)
+	require.Equal(t, db.AuditChange{Before: []any{}, After: []any{"admin"}}, entries[0].Changes["roles"])
+	require.Equal(t, db.AuditActionUserCreate, entries[1].Action)
+}
+
+func TestAuditLogRequiresPermission(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	// A role that can manage users but cannot read the audit log.
+	_, err := db.CreateRole(ctx, dbconn, "support", []string{db.PermissionUsersManage})
+	require.NoError(t, err)
+
+	userID := uuid.Must(uuid.NewV7())
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := pgxutil.InsertRow(ctx, tx, "users", map[string]any{"id": userID, "username": "testuser"})
+		if err != nil {
+			return err
+		}
+		return testutil.GrantUserRole(ctx, tx, userID, "support")
+	})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+	page.HasContent("a", "Users")
+	page.DoesNotHaveContent("a", "Audit Log")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/audit", serverInstance.Server.URL))
+	page.HasContent("body", "Forbidden")
+}
@@ -2,16 +2,28 @@ package browser_test
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-rod/rod"
 	"github.com/jackc/testdb"
 	"github.com/jackc/web-starter-app/httpz"
+	"github.com/jackc/web-starter-app/lib/breachedpasswords"
+	"github.com/jackc/web-starter-app/lib/mail"
 	"github.com/jackc/web-starter-app/test/testbrowser"
+	"github.com/jackc/web-starter-app/test/testoidc"
 	"github.com/jackc/web-starter-app/test/testutil"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
@@ -56,10 +68,49 @@ func TestMain(m *testing.M) {
 }
 
 type serverInstanceT struct {
-	Server *httptest.Server
-	DB     *testdb.DB
+	Server  *httptest.Server
+	DB      *testdb.DB
+	MailDir string
+	IdP     *testoidc.Provider
+
+	handler                  atomic.Pointer[http.Handler]
+	newHandler               func(csrfKeys, cookieAuthenticationKeys, cookieEncryptionKeys [][]byte) (http.Handler, error)
+	csrfKeys                 [][]byte
+	cookieAuthenticationKeys [][]byte
+	cookieEncryptionKeys     [][]byte
 }
 
+func (si *serverInstanceT) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*si.handler.Load()).ServeHTTP(w, r)
+}
+
+// RotateKeys replaces the server's handler with one using a new key generation. At most maxGenerations key
+// generations are kept.
+func (si *serverInstanceT) RotateKeys(t *testing.T, maxGenerations int) {
+	newKey := func(n int) []byte {
+		key := make([]byte, n)
+		_, err := rand.Read(key)
+		require.NoError(t, err)
+		return key
+	}
+
+	keep := func(keys [][]byte, newKey []byte) [][]byte {
+		keys = append([][]byte{newKey}, keys...)
+		return keys[:min(len(keys), maxGenerations)]
+	}
+
+	si.csrfKeys = keep(si.csrfKeys, newKey(64))
+	si.cookieAuthenticationKeys = keep(si.cookieAuthenticationKeys, newKey(64))
+	si.cookieEncryptionKeys = keep(si.cookieEncryptionKeys, newKey(32))
+
+	handler, err := si.newHandler(si.csrfKeys, si.cookieAuthenticationKeys, si.cookieEncryptionKeys)
+	require.NoError(t, err)
+	si.handler.Store(&handler)
+}
+
+// breachedPassword is the only password in the breached passwords list used by the test server.
+const breachedPassword = "breached password"
+
 func startServer(t *testing.T) *serverInstanceT {
 	ctx := context.Background()
 	tdb := TestDBManager.AcquireDB(t, ctx)
@@ -68,30 +119,89 @@ func startServer(t *testing.T) *serverInstanceT {
 	logger := zerolog.New(logWriter).With().Timestamp().Logger()
 
 	dbpool := tdb.PoolConnect(t, ctx)
-	csrfKey := make([]byte, 64)
-	cookieAuthenticationKey := make([]byte, 64)
-	cookieEncryptionKey := make([]byte, 32)
-
-	handler, err := httpz.NewHandler(
-		dbpool,
-		&logger,
-		csrfKey,
-		false,
-		cookieAuthenticationKey,
-		cookieEncryptionKey,
-		nil, // nil manifest means that the vite server must be running
-	)
+	// The server is accessed through localhost instead of 127.0.0.1 because WebAuthn does not allow an IP address as the
+	// relying party ID.
+	server := httptest.NewUnstartedServer(nil)
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
 	require.NoError(t, err)
+	serverURL := fmt.Sprintf("http://localhost:%s", port)
 
-	server := httptest.NewServer(handler)
+	mailDir := t.TempDir()
+	idp := testoidc.NewProvider(t)
+
+	breachedPasswordDigest := sha1.Sum([]byte(breachedPassword))
+	breachedPasswordsPath := filepath.Join(t.TempDir(), "breached_passwords.txt")
+	err = os.WriteFile(breachedPasswordsPath, []byte(strings.ToUpper(hex.EncodeToString(breachedPasswordDigest[:]))+":1\n"), 0o644)
+	require.NoError(t, err)
+	breachedPasswords, err := breachedpasswords.Open(breachedPasswordsPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { breachedPasswords.Close() })
+
+	loginSessionCache := httpz.NewLoginSessionCache(5*time.Minute, 5*time.Second)
+	listenCtx, cancelListen := context.WithCancel(ctx)
+	listenDone := make(chan struct{})
+	go func() {
+		defer close(listenDone)
+		loginSessionCache.Listen(listenCtx, dbpool)
+	}()
 	t.Cleanup(func() {
-		server.Close()
+		cancelListen()
+		<-listenDone
 	})
 
 	instance := &serverInstanceT{
-		Server: server,
-		DB:     tdb,
+		Server:                   server,
+		DB:                       tdb,
+		MailDir:                  mailDir,
+		IdP:                      idp,
+		csrfKeys:                 [][]byte{make([]byte, 64)},
+		cookieAuthenticationKeys: [][]byte{make([]byte, 64)},
+		cookieEncryptionKeys:     [][]byte{make([]byte, 32)},
+	}
+	instance.newHandler = func(csrfKeys, cookieAuthenticationKeys, cookieEncryptionKeys [][]byte) (http.Handler, error) {
+		return httpz.NewHandler(&httpz.HandlerConfig{
+			DBPool:                            dbpool,
+			Logger:                            &logger,
+			CSRFKeys:                          csrfKeys,
+			CookieAuthenticationKeys:          cookieAuthenticationKeys,
+			CookieEncryptionKeys:              cookieEncryptionKeys,
+			LoginSessionIdleTimeout:           24 * time.Hour,
+			LoginSessionMaxLifetime:           30 * 24 * time.Hour,
+			PersistentLoginSessionIdleTimeout: 30 * 24 * time.Hour,
+			PersistentLoginSessionMaxLifetime: 90 * 24 * time.Hour,
+			WebAuthnRPID:                      "localhost",
+			WebAuthnRPOrigins:                 []string{serverURL},
+			Mailer:                            &mail.FileMailer{Dir: mailDir},
+			MailFrom:                          "web-starter-app <noreply@localhost>",
+			BaseURL:                           serverURL,
+			RegistrationEnabled:               true,
+			OIDCConfig: &httpz.OIDCConfig{
+				IssuerURL:     idp.Issuer(),
+				ClientID:      idp.ClientID,
+				ClientSecret:  idp.ClientSecret,
+				DisplayName:   "Corporate SSO",
+				Scopes:        []string{"openid", "profile", "email"},
+				UsernameClaim: "preferred_username",
+				EmailClaim:    "email",
+				AutoProvision: true,
+				LinkByEmail:   true,
+			},
+			PasswordPolicy:    &httpz.PasswordPolicy{MinLength: 8, BreachedPasswords: breachedPasswords},
+			LoginSessionCache: loginSessionCache,
+			AssetManifest:     nil, // nil manifest means that the vite server must be running
+		})
 	}
 
+	handler, err := instance.newHandler(instance.csrfKeys, instance.cookieAuthenticationKeys, instance.cookieEncryptionKeys)
+	require.NoError(t, err)
+	instance.handler.Store(&handler)
+
+	server.Config.Handler = instance
+	server.Start()
+	server.URL = serverURL
+	t.Cleanup(func() {
+		server.Close()
+	})
+
 	return instance
 }
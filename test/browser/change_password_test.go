@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgxutil"
@@ -71,3 +72,94 @@ func TestChangePasswordFailure(t *testing.T) {
 
 	page.HasContent("body", "Invalid password")
 }
+
+func TestChangePasswordRevokesOtherLoginSessions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	otherLoginSessionID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "login_sessions", map[string]any{
+		"id":                            otherLoginSessionID,
+		"user_id":                       userID,
+		"login_time":                    time.Now(),
+		"login_request_id":              "other-request-id",
+		"approximate_last_request_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Change Password")
+	page.FillIn("Current Password", "password")
+	page.FillIn("New Password", "newpassword")
+	page.ClickOn("Save")
+
+	// The current session is kept.
+	page.HasContent("div", "Hello, testuser!")
+
+	var otherExists bool
+	err = dbconn.QueryRow(ctx, "select exists(select 1 from login_sessions where id = $1)", otherLoginSessionID).Scan(&otherExists)
+	require.NoError(t, err)
+	require.False(t, otherExists)
+
+	var sessionCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from login_sessions where user_id = $1", userID).Scan(&sessionCount)
+	require.NoError(t, err)
+	require.Equal(t, 1, sessionCount)
+}
+
+func TestChangePasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Change Password")
+	page.FillIn("Current Password", "password")
+	page.FillIn("New Password", "a")
+	page.ClickOn("Save")
+	page.HasContent("li", "Password must be at least 8 characters")
+
+	page.FillIn("Current Password", "password")
+	page.FillIn("New Password", "my TestUser password")
+	page.ClickOn("Save")
+	page.HasContent("li", "Password must not contain your username")
+
+	page.FillIn("Current Password", "password")
+	page.FillIn("New Password", breachedPassword)
+	page.ClickOn("Save")
+	page.HasContent("li", "Password has appeared in a data breach")
+
+	err = db.ValidateUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+}
@@ -0,0 +1,88 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistanceUnit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+	page.HasContent("div", "Hello, testuser!")
+
+	page.ClickOn("Preferences")
+	page.MustElement("select[name=distanceUnit]").MustSelect("kilometers")
+	page.ClickOn("Save")
+
+	// A bare number is in the user's unit.
+	page.ClickOn("New walk")
+	page.HasContent("label", "Distance in kilometers")
+	page.FillIn("Duration", "1h")
+	page.FillIn("Distance", "8.04672")
+	page.ClickOn("Save")
+	page.HasContent("td", "8.05 km")
+
+	// A unit in the input overrides the user's unit.
+	page.ClickOn("New walk")
+	page.FillIn("Duration", "30m")
+	page.FillIn("Distance", "3.1 mi")
+	page.ClickOn("Save")
+	page.HasContent("td", "4.99 km")
+
+	distances, err := pgxutil.Select(ctx, dbconn, "select distance_in_miles from walks where user_id = $1 order by distance_in_miles", []any{userID}, pgx.RowTo[decimal.Decimal])
+	require.NoError(t, err)
+	require.Len(t, distances, 2)
+	require.True(t, decimal.RequireFromString("3.1").Equal(distances[0]), distances[0].String())
+	require.True(t, decimal.RequireFromString("5").Equal(distances[1]), distances[1].String())
+
+	walkIDs, err := pgxutil.Select(ctx, dbconn, "select id from walks where user_id = $1 order by distance_in_miles", []any{userID}, pgx.RowTo[uuid.UUID])
+	require.NoError(t, err)
+
+	// The edit form shows the distance rounded. Saving it unchanged keeps the exact distance.
+	page.MustNavigate(fmt.Sprintf("%s/walks/%s/edit", serverInstance.Server.URL, walkIDs[0]))
+	require.Equal(t, "4.99", page.MustElement("input[name=distance]").MustProperty("value").String())
+	page.ClickOn("Save")
+	page.HasContent("td", "4.99 km")
+
+	var distanceInMiles decimal.Decimal
+	err = dbconn.QueryRow(ctx, "select distance_in_miles from walks where id = $1", walkIDs[0]).Scan(&distanceInMiles)
+	require.NoError(t, err)
+	require.True(t, decimal.RequireFromString("3.1").Equal(distanceInMiles), distanceInMiles.String())
+
+	page.ClickOn("Preferences")
+	page.MustElement("select[name=distanceUnit]").MustSelect("miles")
+	page.ClickOn("Save")
+	page.HasContent("td", "5 mi")
+	page.HasContent("td", "3.1 mi")
+
+	page.ClickOn("New walk")
+	page.FillIn("Duration", "10m")
+	page.FillIn("Distance", "furlong")
+	page.ClickOn("Save")
+	page.HasContent("li", "Invalid distance")
+}
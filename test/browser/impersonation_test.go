@@ -0,0 +1,123 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/test/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImpersonation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	adminUserID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": adminUserID, "username": "admin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, adminUserID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, adminUserID, "password")
+	require.NoError(t, err)
+
+	userID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "admin")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, admin!")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s", serverInstance.Server.URL, userID))
+	page.ClickOn("Impersonate")
+
+	page.HasContent("div", "Hello, testuser!")
+	page.HasContent("#impersonation-banner", "admin is impersonating testuser.")
+
+	// The banner persists across pages.
+	page.MustNavigate(fmt.Sprintf("%s/walks/new", serverInstance.Server.URL))
+	page.HasContent("#impersonation-banner", "admin is impersonating testuser.")
+
+	// Actions that change the user's credentials are blocked.
+	page.MustNavigate(fmt.Sprintf("%s/change_password", serverInstance.Server.URL))
+	page.HasContent("div", "Not allowed while impersonating")
+
+	// System pages are not available while impersonating a user without permissions.
+	page.MustNavigate(fmt.Sprintf("%s/system/users", serverInstance.Server.URL))
+	page.DoesNotHaveContent("a", "New User")
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Stop impersonating")
+
+	page.HasContent("div", "Impersonations")
+	page.HasContent("td", "admin")
+	page.DoesNotHaveContent("#impersonation-banner", "impersonating")
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("div", "Hello, admin!")
+
+	var impersonatorUserID uuid.UUID
+	var endTime *time.Time
+	err = dbconn.QueryRow(ctx, "select impersonator_user_id, end_time from impersonations where impersonated_user_id = $1", userID).Scan(&impersonatorUserID, &endTime)
+	require.NoError(t, err)
+	require.Equal(t, adminUserID, impersonatorUserID)
+	require.NotNil(t, endTime)
+
+	var loginSessionCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from login_sessions where user_id = $1", userID).Scan(&loginSessionCount)
+	require.NoError(t, err)
+	require.Equal(t, 0, loginSessionCount)
+}
+
+func TestImpersonationOfUserWithRoleIsNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	adminUserID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": adminUserID, "username": "admin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, adminUserID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, adminUserID, "password")
+	require.NoError(t, err)
+
+	otherAdminUserID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": otherAdminUserID, "username": "otheradmin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, otherAdminUserID, "admin")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "admin")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, admin!")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s", serverInstance.Server.URL, otherAdminUserID))
+	page.HasContent("dd", "otheradmin")
+	page.DoesNotHaveContent("button", "Impersonate")
+}
@@ -0,0 +1,90 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRotationKeepsLoginSession(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	originalCookie := findCookie(page.MustCookies(), "web-starter-app-session")
+	require.NotNil(t, originalCookie)
+
+	serverInstance.RotateKeys(t, 2)
+
+	// The cookie encoded with the older key is still accepted and is reissued with the newest key.
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("div", "Hello, testuser!")
+
+	reissuedCookie := findCookie(page.MustCookies(), "web-starter-app-session")
+	require.NotNil(t, reissuedCookie)
+	require.NotEqual(t, originalCookie.Value, reissuedCookie.Value)
+
+	// The original key can be retired without logging the user out.
+	serverInstance.RotateKeys(t, 1)
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("div", "Hello, testuser!")
+}
+
+func TestKeyRotationKeepsOpenFormsWorking(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Change Password")
+	page.FillIn("Current Password", "password")
+	page.FillIn("New Password", "newpassword")
+
+	serverInstance.RotateKeys(t, 2)
+
+	page.ClickOn("Save")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	err = db.ValidateUserPassword(ctx, dbconn, userID, "newpassword")
+	require.NoError(t, err)
+}
@@ -0,0 +1,307 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginSessionIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	_, err = dbconn.Exec(ctx, "update login_sessions set approximate_last_request_time = now() - '25 hours'::interval where user_id = $1", userID)
+	require.NoError(t, err)
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("button", "Login")
+
+	var sessionCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from login_sessions where user_id = $1", userID).Scan(&sessionCount)
+	require.NoError(t, err)
+	require.Equal(t, 0, sessionCount)
+}
+
+func TestLoginSessionMaxLifetime(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	// The session is still active but was created longer ago than the maximum lifetime.
+	_, err = dbconn.Exec(ctx, "update login_sessions set login_time = now() - '31 days'::interval where user_id = $1", userID)
+	require.NoError(t, err)
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("button", "Login")
+}
+
+func TestLogoutEndsLoginSessionImmediately(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	cookie := findCookie(page.MustCookies(), "web-starter-app-session")
+	require.NotNil(t, cookie)
+
+	page.ClickOn("Logout")
+	page.HasContent("button", "Login")
+	require.Nil(t, findCookie(page.MustCookies(), "web-starter-app-session"))
+
+	// A copy of the cookie taken before logging out no longer works even if the cache notification has not arrived.
+	req, err := http.NewRequest("GET", serverInstance.Server.URL, nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	response, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NotContains(t, string(body), "Hello, testuser!")
+}
+
+func TestLoginSessionsRevokeOne(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	otherLoginSessionID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "login_sessions", map[string]any{
+		"id":                            otherLoginSessionID,
+		"user_id":                       userID,
+		"user_agent":                    "Mozilla/5.0 (X11; Linux x86_64; rv:133.0) Gecko/20100101 Firefox/133.0",
+		"login_time":                    time.Now(),
+		"login_request_id":              "other-request-id",
+		"approximate_last_request_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Sessions")
+	page.HasContent("td", "This device")
+	page.HasContent("td", "Firefox 133 on Linux")
+	page.HasContent("td", "other-request-id")
+
+	page.ClickOn("Revoke")
+	page.DoesNotHaveContent("td", "other-request-id")
+	page.HasContent("td", "This device")
+
+	var otherExists bool
+	err = dbconn.QueryRow(ctx, "select exists(select 1 from login_sessions where id = $1)", otherLoginSessionID).Scan(&otherExists)
+	require.NoError(t, err)
+	require.False(t, otherExists)
+}
+
+func TestLoginSessionsHidesExpired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	for _, s := range []struct {
+		loginRequestID             string
+		persistent                 bool
+		loginTime                  time.Time
+		approximateLastRequestTime time.Time
+	}{
+		{"idle-request-id", false, time.Now().Add(-72 * time.Hour), time.Now().Add(-48 * time.Hour)},
+		{"remembered-request-id", true, time.Now().Add(-72 * time.Hour), time.Now().Add(-48 * time.Hour)},
+		{"old-request-id", true, time.Now().Add(-100 * 24 * time.Hour), time.Now()},
+	} {
+		err = pgxutil.InsertRow(ctx, dbconn, "login_sessions", map[string]any{
+			"id":                            uuid.Must(uuid.NewV7()),
+			"user_id":                       userID,
+			"persistent":                    s.persistent,
+			"login_time":                    s.loginTime,
+			"login_request_id":              s.loginRequestID,
+			"approximate_last_request_time": s.approximateLastRequestTime,
+		})
+		require.NoError(t, err)
+	}
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Sessions")
+	page.HasContent("td", "This device")
+	page.HasContent("td", "remembered-request-id")
+	page.DoesNotHaveContent("td", "idle-request-id")
+	page.DoesNotHaveContent("td", "old-request-id")
+}
+
+func TestLoginSessionsSignOutEverywhereElse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		err = pgxutil.InsertRow(ctx, dbconn, "login_sessions", map[string]any{
+			"id":                            uuid.Must(uuid.NewV7()),
+			"user_id":                       userID,
+			"login_time":                    time.Now(),
+			"login_request_id":              fmt.Sprintf("other-request-id-%d", i),
+			"approximate_last_request_time": time.Now(),
+		})
+		require.NoError(t, err)
+	}
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Sessions")
+	page.ClickOn("Sign out everywhere else")
+	page.DoesNotHaveContent("td", "other-request-id")
+	page.HasContent("td", "This device")
+
+	var sessionCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from login_sessions where user_id = $1", userID).Scan(&sessionCount)
+	require.NoError(t, err)
+	require.Equal(t, 1, sessionCount)
+}
+
+func TestLoginSessionCacheInvalidation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	// Changes made directly in the database are seen by the cached login session.
+	_, err = dbconn.Exec(ctx, "update users set username = 'renameduser' where id = $1", userID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		page.MustNavigate(serverInstance.Server.URL)
+		found, _, err := page.HasR("div", "Hello, renameduser!")
+		require.NoError(t, err)
+		return found
+	}, 2*time.Second, 100*time.Millisecond)
+
+	_, err = dbconn.Exec(ctx, "delete from login_sessions where user_id = $1", userID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		page.MustNavigate(serverInstance.Server.URL)
+		found, _, err := page.HasR("button", "Login")
+		require.NoError(t, err)
+		return found
+	}, 2*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,178 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/test/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginThrottleRecordsFailures(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	for _, username := range []string{"testuser", "nosuchuser"} {
+		page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+		page.FillIn("input[name=username]", username)
+		page.FillIn("input[name=password]", "wrongpassword")
+		page.ClickOn("Login")
+
+		page.HasContent("body", "Invalid username or password")
+	}
+
+	var failureCount int32
+	err = dbconn.QueryRow(ctx, "select failure_count from login_throttles where kind = 'username' and key = 'testuser'").Scan(&failureCount)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, failureCount)
+
+	err = dbconn.QueryRow(ctx, "select failure_count from login_throttles where kind = 'username' and key = 'nosuchuser'").Scan(&failureCount)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, failureCount)
+
+	err = dbconn.QueryRow(ctx, "select failure_count from login_throttles where kind = 'ip'").Scan(&failureCount)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, failureCount)
+}
+
+func TestLoginThrottleRefusesLockedUser(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	err = pgxutil.InsertRow(ctx, dbconn, "login_throttles", map[string]any{
+		"kind":              "username",
+		"key":               "testuser",
+		"failure_count":     10,
+		"last_failure_time": time.Now(),
+		"locked_until":      time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("body", "Too many failed login attempts. Try again later.")
+	page.DoesNotHaveContent("div", "Hello, testuser!")
+}
+
+func TestLoginThrottleAdminUnlocks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	adminUserID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": adminUserID, "username": "admin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, adminUserID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, adminUserID, "password")
+	require.NoError(t, err)
+
+	lockedUserID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": lockedUserID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = pgxutil.InsertRow(ctx, dbconn, "login_throttles", map[string]any{
+		"kind":              "username",
+		"key":               "testuser",
+		"failure_count":     10,
+		"last_failure_time": time.Now(),
+		"locked_until":      time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "admin")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, admin!")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s", serverInstance.Server.URL, lockedUserID))
+	page.HasContent("dt", "Locked Until")
+	page.ClickOn("Unlock")
+	page.DoesNotHaveContent("dt", "Locked Until")
+
+	var throttleExists bool
+	err = dbconn.QueryRow(ctx, "select exists(select 1 from login_throttles where kind = 'username' and key = 'testuser')").Scan(&throttleExists)
+	require.NoError(t, err)
+	require.False(t, throttleExists)
+}
+
+func TestLoginThrottleAllowsKnownDevice(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	login := func() {
+		page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+		page.FillIn("input[name=username]", "testuser")
+		page.FillIn("input[name=password]", "password")
+		page.ClickOn("Login")
+	}
+
+	login()
+	page.HasContent("div", "Hello, testuser!")
+	page.ClickOn("Logout")
+
+	// Someone else guessing the password locks the username.
+	err = pgxutil.InsertRow(ctx, dbconn, "login_throttles", map[string]any{
+		"kind":              "username",
+		"key":               "testuser",
+		"failure_count":     10,
+		"last_failure_time": time.Now(),
+		"locked_until":      time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	// The lock does not apply to a browser the user has logged in with before.
+	login()
+	page.HasContent("div", "Hello, testuser!")
+}
@@ -0,0 +1,80 @@
+package browser_test
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type sentEmail struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// sentEmails returns the messages written by the server's mail.FileMailer in the order they were sent.
+func sentEmails(t *testing.T, serverInstance *serverInstanceT) []*sentEmail {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(serverInstance.MailDir, "*.eml"))
+	require.NoError(t, err)
+
+	emails := make([]*sentEmail, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+
+		msg, err := netmail.ReadMessage(f)
+		require.NoError(t, err)
+
+		email := &sentEmail{To: msg.Header.Get("To")}
+		email.Subject, err = (&mime.WordDecoder{}).DecodeHeader(msg.Header.Get("Subject"))
+		require.NoError(t, err)
+
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		mpr := multipart.NewReader(msg.Body, params["boundary"])
+		for {
+			part, err := mpr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(part)
+			require.NoError(t, err)
+
+			switch part.Header.Get("Content-Type") {
+			case "text/plain; charset=utf-8":
+				email.TextBody = string(body)
+			case "text/html; charset=utf-8":
+				email.HTMLBody = string(body)
+			}
+		}
+
+		emails = append(emails, email)
+	}
+
+	return emails
+}
+
+var urlRegexp = regexp.MustCompile(`https?://\S+`)
+
+// findURL returns the first URL in s.
+func findURL(t *testing.T, s string) string {
+	t.Helper()
+
+	url := urlRegexp.FindString(s)
+	require.NotEmpty(t, url, "no URL found")
+	return url
+}
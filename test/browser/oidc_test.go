@@ -0,0 +1,159 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	serverInstance.IdP.SetClaims(map[string]any{
+		"sub":                "employee-1",
+		"preferred_username": "ssouser",
+		"email":              "ssouser@example.com",
+		"email_verified":     true,
+	})
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.ClickOn("Sign in with Corporate SSO")
+
+	page.HasContent("div", "Hello, ssouser!")
+
+	var email string
+	var emailVerified bool
+	err := dbconn.QueryRow(ctx,
+		`select users.email, users.email_verified_time is not null
+from user_identities
+	join users on user_identities.user_id = users.id
+where user_identities.issuer = $1 and user_identities.subject = $2`,
+		serverInstance.IdP.Issuer(), "employee-1",
+	).Scan(&email, &emailVerified)
+	require.NoError(t, err)
+	require.Equal(t, "ssouser@example.com", email)
+	require.True(t, emailVerified)
+
+	// Logging in again uses the linked identity even if the username claim changes.
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Logout")
+
+	serverInstance.IdP.SetClaims(map[string]any{
+		"sub":                "employee-1",
+		"preferred_username": "renamed",
+	})
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.ClickOn("Sign in with Corporate SSO")
+
+	page.HasContent("div", "Hello, ssouser!")
+
+	var userCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from users").Scan(&userCount)
+	require.NoError(t, err)
+	require.Equal(t, 1, userCount)
+}
+
+func TestOIDCLoginLinksExistingUserByVerifiedEmail(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{
+		"id":                  userID,
+		"username":            "testuser",
+		"email":               "testuser@example.com",
+		"email_verified_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	serverInstance.IdP.SetClaims(map[string]any{
+		"sub":                "employee-1",
+		"preferred_username": "tuser",
+		"email":              "TestUser@example.com",
+		"email_verified":     true,
+	})
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.ClickOn("Sign in with Corporate SSO")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	var linkedUserID uuid.UUID
+	err = dbconn.QueryRow(ctx, "select user_id from user_identities where subject = $1", "employee-1").Scan(&linkedUserID)
+	require.NoError(t, err)
+	require.Equal(t, userID, linkedUserID)
+}
+
+func TestOIDCLoginDoesNotLinkUnverifiedEmail(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{
+		"id":                  userID,
+		"username":            "testuser",
+		"email":               "testuser@example.com",
+		"email_verified_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	serverInstance.IdP.SetClaims(map[string]any{
+		"sub":                "employee-1",
+		"preferred_username": "ssouser",
+		"email":              "testuser@example.com",
+		"email_verified":     false,
+	})
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.ClickOn("Sign in with Corporate SSO")
+
+	page.HasContent("div", "Hello, ssouser!")
+
+	var linkedUserID uuid.UUID
+	err = dbconn.QueryRow(ctx, "select user_id from user_identities where subject = $1", "employee-1").Scan(&linkedUserID)
+	require.NoError(t, err)
+	require.NotEqual(t, userID, linkedUserID)
+}
+
+func TestOIDCLoginUsernameTaken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": uuid.Must(uuid.NewV7()), "username": "ssouser"})
+	require.NoError(t, err)
+
+	serverInstance.IdP.SetClaims(map[string]any{
+		"sub":                "employee-1",
+		"preferred_username": "ssouser",
+	})
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.ClickOn("Sign in with Corporate SSO")
+
+	page.HasContent("li", "Unable to create an account: Username is already taken")
+	page.DoesNotHaveContent("div", "Hello, ssouser!")
+}
@@ -0,0 +1,304 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/test/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrganizations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	for _, username := range []string{"alice", "bob"} {
+		userID := uuid.Must(uuid.NewV7())
+		err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": username})
+		require.NoError(t, err)
+
+		err = db.SetUserPassword(ctx, dbconn, userID, "password")
+		require.NoError(t, err)
+	}
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	login := func(username string) {
+		page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+		page.FillIn("input[name=username]", username)
+		page.FillIn("input[name=password]", "password")
+		page.ClickOn("Login")
+		page.HasContent("div", fmt.Sprintf("Hello, %s!", username))
+	}
+
+	login("alice")
+	page.ClickOn("Organizations")
+	page.ClickOn("New Organization")
+	page.FillIn("Name", "Walkers")
+	page.ClickOn("Save")
+	page.HasContent("td", "alice")
+
+	page.ClickOn("Create Invitation Link")
+	invitationURL := page.MustElement("#newInvitationURL code").MustText()
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Logout")
+
+	// Bob joins and logs a walk for the team.
+	login("bob")
+	page.MustNavigate(invitationURL)
+	page.HasContent("div", "You have been invited to join Walkers.")
+	page.ClickOn("Join")
+	page.HasContent("div", "Walkers walk log")
+
+	page.ClickOn("New walk")
+	page.FillIn("Duration", "45m")
+	page.FillIn("Distance", "2.75")
+	page.ClickOn("Save")
+	page.HasContent("td", "bob")
+	page.HasContent("td", "2.75")
+
+	page.ClickOn("Logout")
+
+	// Alice sees Bob's walk in the team walk log but cannot change it.
+	login("alice")
+	page.HasContent("div", "Walkers walk log")
+	page.HasContent("td", "2.75")
+	page.ClickOn("Show")
+	page.HasContent("div", "Walked by bob")
+	page.DoesNotHaveContent("a", "Edit")
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.MustElement("select[name=organizationID]").MustSelect("Personal")
+	page.ClickOn("Switch")
+	page.DoesNotHaveContent("div", "Walkers walk log")
+	page.DoesNotHaveContent("td", "2.75")
+
+	var organizationID uuid.UUID
+	err := dbconn.QueryRow(ctx, "select id from organizations where name = 'Walkers'").Scan(&organizationID)
+	require.NoError(t, err)
+
+	// The only owner cannot leave.
+	page.MustNavigate(fmt.Sprintf("%s/organizations/%s", serverInstance.Server.URL, organizationID))
+	page.ClickOn("Leave")
+	page.HasContent("li", "An organization must have an owner")
+
+	// Removing Bob stops sharing his walks with the organization.
+	page.MustNavigate(fmt.Sprintf("%s/organizations/%s", serverInstance.Server.URL, organizationID))
+	page.ClickOn("Remove")
+	page.DoesNotHaveContent("td", "bob")
+
+	var sharedWalkCount int
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.BypassRowSecurity(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, "select count(*) from walks where organization_id = $1", organizationID).Scan(&sharedWalkCount)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, sharedWalkCount)
+}
+
+func TestOrganizationInvitationRevoked(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	ownerID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": ownerID, "username": "owner"})
+	require.NoError(t, err)
+
+	organizationID, err := db.CreateOrganization(ctx, dbconn, "Walkers", ownerID)
+	require.NoError(t, err)
+
+	token, err := db.CreateOrganizationInvitation(ctx, dbconn, organizationID)
+	require.NoError(t, err)
+
+	_, err = dbconn.Exec(ctx, "delete from organization_invitations where organization_id = $1", organizationID)
+	require.NoError(t, err)
+
+	userID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+	page.HasContent("div", "Hello, testuser!")
+
+	page.MustNavigate(fmt.Sprintf("%s/organization_invitations/%s", serverInstance.Server.URL, token))
+	page.HasContent("div", "This invitation link is invalid or has expired.")
+}
+
+func TestOrganizationManagement(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	ownerID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": ownerID, "username": "alice"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, ownerID, "password")
+	require.NoError(t, err)
+
+	memberID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": memberID, "username": "bob"})
+	require.NoError(t, err)
+
+	organizationID, err := db.CreateOrganization(ctx, dbconn, "Walkers", ownerID)
+	require.NoError(t, err)
+
+	token, err := db.CreateOrganizationInvitation(ctx, dbconn, organizationID)
+	require.NoError(t, err)
+
+	_, err = db.AcceptOrganizationInvitation(ctx, dbconn, token, memberID)
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "alice")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+	page.HasContent("div", "Hello, alice!")
+
+	page.MustNavigate(fmt.Sprintf("%s/organizations/%s", serverInstance.Server.URL, organizationID))
+	page.ClickOn("Rename")
+	page.FillIn("Name", "Hikers")
+	page.ClickOn("Save")
+	page.HasContent("div", "Hikers")
+
+	// Ownership is transferred by making Bob an owner before Alice leaves.
+	page.ClickOn("Make Owner")
+	page.ClickOn("Leave")
+	page.DoesNotHaveContent("td", "Hikers")
+
+	role, err := db.GetOrganizationRole(ctx, dbconn, organizationID, memberID)
+	require.NoError(t, err)
+	require.Equal(t, db.OrganizationRoleOwner, role)
+
+	// The last owner cannot step down.
+	err = db.SetOrganizationMemberRole(ctx, dbconn, organizationID, memberID, db.OrganizationRoleMember)
+	require.ErrorIs(t, err, db.ErrLastOrganizationOwner)
+
+	err = db.DeleteOrganization(ctx, dbconn, organizationID)
+	require.NoError(t, err)
+
+	var organizationExists bool
+	err = dbconn.QueryRow(ctx, "select exists(select 1 from organizations where id = $1)", organizationID).Scan(&organizationExists)
+	require.NoError(t, err)
+	require.False(t, organizationExists)
+}
+
+func TestOrganizationDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	ownerID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": ownerID, "username": "alice"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, ownerID, "password")
+	require.NoError(t, err)
+
+	organizationID, err := db.CreateOrganization(ctx, dbconn, "Walkers", ownerID)
+	require.NoError(t, err)
+
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, ownerID)
+		if err != nil {
+			return err
+		}
+		return pgxutil.InsertRow(ctx, tx, "walks", map[string]any{
+			"id":                uuid.Must(uuid.NewV7()),
+			"user_id":           ownerID,
+			"organization_id":   organizationID,
+			"duration":          30 * time.Minute,
+			"distance_in_miles": 1.5,
+		})
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "alice")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+	page.HasContent("div", "Walkers walk log")
+
+	page.MustNavigate(fmt.Sprintf("%s/organizations/%s", serverInstance.Server.URL, organizationID))
+	page.ClickOn("Delete Organization")
+	page.DoesNotHaveContent("td", "Walkers")
+
+	// The walk becomes a personal walk.
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("div", "Hello, alice!")
+	page.HasContent("td", "1.5")
+}
+
+func TestSystemOrganizations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	adminUserID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": adminUserID, "username": "admin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, adminUserID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, adminUserID, "password")
+	require.NoError(t, err)
+
+	ownerID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": ownerID, "username": "owner"})
+	require.NoError(t, err)
+
+	_, err = db.CreateOrganization(ctx, dbconn, "Walkers", ownerID)
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "admin")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+	page.HasContent("div", "Hello, admin!")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/organizations", serverInstance.Server.URL))
+	page.HasContent("td", "Walkers")
+	page.HasContent("td", "owner")
+
+	page.ClickOn("Members")
+	page.HasContent("div", "Members of Walkers")
+	page.HasContent("span", "owner")
+	page.DoesNotHaveContent("span", "admin")
+}
@@ -0,0 +1,70 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+	page.AddVirtualAuthenticator()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Passkeys")
+	page.FillIn("Passkey Name", "Test Authenticator")
+	page.ClickOn("Add a passkey")
+	page.HasContent("td", "Test Authenticator")
+
+	credentials, err := db.GetWebAuthnCredentials(ctx, dbconn, userID)
+	require.NoError(t, err)
+	require.Len(t, credentials, 1)
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Logout")
+
+	page.ClickOn("Sign in with a passkey")
+	page.HasContent("div", "Hello, testuser!")
+
+	var lastUsed bool
+	err = dbconn.QueryRow(ctx, "select last_used_time is not null from webauthn_credentials where user_id = $1", userID).Scan(&lastUsed)
+	require.NoError(t, err)
+	require.True(t, lastUsed)
+}
+
+func TestPasskeyLoginWithoutRegisteredPasskey(t *testing.T) {
+	t.Parallel()
+
+	serverInstance := startServer(t)
+
+	page := TestBrowserManager.Acquire(t).Page()
+	page.AddVirtualAuthenticator()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	// The virtual authenticator has no credentials so the browser rejects the request.
+	page.ClickOn("Sign in with a passkey")
+	page.HasContent("#passkeyErrors li", ".")
+}
@@ -0,0 +1,176 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{
+		"id":                  userID,
+		"username":            "testuser",
+		"email":               "testuser@example.com",
+		"email_verified_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.ClickOn("Forgot password")
+
+	page.FillIn("input[name=email]", "TestUser@example.com")
+	page.ClickOn("Send Reset Link")
+	page.HasContent("p", "If an account exists for that email address")
+
+	// The email is sent in the background.
+	var emails []*sentEmail
+	require.Eventually(t, func() bool {
+		emails = sentEmails(t, serverInstance)
+		return len(emails) == 1
+	}, 2*time.Second, 50*time.Millisecond)
+	require.Equal(t, "testuser@example.com", emails[0].To)
+	require.Contains(t, emails[0].HTMLBody, "Reset your password")
+	resetURL := findURL(t, emails[0].TextBody)
+
+	page.MustNavigate(resetURL)
+	page.HasContent("p", "Choose a new password for testuser.")
+	page.FillIn("input[name=newPassword]", "new password")
+	page.ClickOn("Reset Password")
+	page.HasContent("p", "Your password has been reset.")
+
+	err = db.ValidateUserPassword(ctx, dbconn, userID, "new password")
+	require.NoError(t, err)
+
+	// The token is single use.
+	page.MustNavigate(resetURL)
+	page.HasContent("p", "This password reset link is invalid or has expired.")
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "new password")
+	page.ClickOn("Login")
+	page.HasContent("div", "Hello, testuser!")
+}
+
+func TestPasswordResetUnknownEmail(t *testing.T) {
+	t.Parallel()
+
+	serverInstance := startServer(t)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/forgot_password", serverInstance.Server.URL))
+
+	page.FillIn("input[name=email]", "nobody@example.com")
+	page.ClickOn("Send Reset Link")
+	page.HasContent("p", "If an account exists for that email address")
+
+	require.Empty(t, sentEmails(t, serverInstance))
+}
+
+func TestPasswordResetUnverifiedEmail(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": uuid.Must(uuid.NewV7()), "username": "testuser", "email": "testuser@example.com"})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/forgot_password", serverInstance.Server.URL))
+
+	page.FillIn("input[name=email]", "testuser@example.com")
+	page.ClickOn("Send Reset Link")
+	page.HasContent("p", "If an account exists for that email address")
+
+	require.Empty(t, sentEmails(t, serverInstance))
+}
+
+func TestPasswordResetThrottle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{
+		"id":                  uuid.Must(uuid.NewV7()),
+		"username":            "testuser",
+		"email":               "testuser@example.com",
+		"email_verified_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	requestReset := func(email string) {
+		page.MustNavigate(fmt.Sprintf("%s/forgot_password", serverInstance.Server.URL))
+		page.FillIn("input[name=email]", email)
+		page.ClickOn("Send Reset Link")
+	}
+
+	for range 3 {
+		requestReset("testuser@example.com")
+		page.HasContent("p", "If an account exists for that email address")
+	}
+
+	requestReset("TestUser@example.com")
+	page.HasContent("li", "Too many password reset requests")
+
+	// Email addresses without accounts are limited the same way so the limit does not reveal which have accounts.
+	for range 3 {
+		_, err := db.RecordPasswordResetRequest(ctx, dbconn, "nobody@example.com", "192.0.2.1")
+		require.NoError(t, err)
+	}
+	requestReset("nobody@example.com")
+	page.HasContent("li", "Too many password reset requests")
+
+	require.Eventually(t, func() bool {
+		return len(sentEmails(t, serverInstance)) == 3
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestPasswordResetExpiredToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{
+		"id":                  userID,
+		"username":            "testuser",
+		"email":               "testuser@example.com",
+		"email_verified_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	token, err := db.CreatePasswordResetToken(ctx, dbconn, userID)
+	require.NoError(t, err)
+
+	_, err = dbconn.Exec(ctx, "update password_reset_tokens set expiration_time = now() - '1 minute'::interval where user_id = $1", userID)
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/reset_password?token=%s", serverInstance.Server.URL, token))
+	page.HasContent("p", "This password reset link is invalid or has expired.")
+}
@@ -0,0 +1,129 @@
+package browser_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestPasswordLoginUpgradesImportedHash(t *testing.T) {
+	t.Parallel()
+
+	salt := []byte("0123456789abcdef")
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	scryptDigest, err := scrypt.Key([]byte("password"), salt, 1<<10, 8, 1, 32)
+	require.NoError(t, err)
+
+	weakArgon2Digest := argon2.IDKey([]byte("password"), salt, 1, 8*1024, 1, 32)
+
+	for _, tt := range []struct {
+		name string
+		hash string
+	}{
+		{
+			name: "bcrypt",
+			hash: string(bcryptHash),
+		},
+		{
+			name: "scrypt",
+			hash: fmt.Sprintf("$scrypt$ln=10,r=8,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(scryptDigest)),
+		},
+		{
+			name: "weak Argon2id",
+			hash: fmt.Sprintf("$argon2id$v=19$m=8192,t=1,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(weakArgon2Digest)),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			serverInstance := startServer(t)
+			dbconn := serverInstance.DB.Connect(t, ctx)
+			userID := uuid.Must(uuid.NewV7())
+			err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+			require.NoError(t, err)
+
+			err = db.ImportUserPasswordHash(ctx, dbconn, userID, tt.hash)
+			require.NoError(t, err)
+
+			page := TestBrowserManager.Acquire(t).Page()
+
+			page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+			page.FillIn("input[name=username]", "testuser")
+			page.FillIn("input[name=password]", "password")
+			page.ClickOn("Login")
+
+			page.HasContent("div", "Hello, testuser!")
+
+			var algorithm string
+			var minMemory uint32
+			err = dbconn.QueryRow(ctx, "select algorithm, min_memory from user_passwords where user_id = $1", userID).Scan(&algorithm, &minMemory)
+			require.NoError(t, err)
+			require.Equal(t, "Argon2id", algorithm)
+			require.Equal(t, db.DefaultArgon2Params.MemoryKiB, minMemory)
+
+			err = db.ValidateUserPassword(ctx, dbconn, userID, "password")
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestImportUserPasswordHashRejectsUnsupportedFormat(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.ImportUserPasswordHash(ctx, dbconn, userID, "5f4dcc3b5aa765d61d8327deb882cf99")
+	require.ErrorIs(t, err, db.ErrPasswordHashUnsupported)
+}
+
+func TestImportUserPasswordHashRejectsExcessiveCost(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	digest := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+	for _, tt := range []struct {
+		name string
+		hash string
+	}{
+		{name: "bcrypt cost", hash: "$2a$17$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW"},
+		{name: "scrypt N", hash: fmt.Sprintf("$scrypt$ln=21,r=1,p=1$%s$%s", salt, digest)},
+		{name: "scrypt r", hash: fmt.Sprintf("$scrypt$ln=10,r=33,p=1$%s$%s", salt, digest)},
+		{name: "scrypt p", hash: fmt.Sprintf("$scrypt$ln=10,r=8,p=17$%s$%s", salt, digest)},
+		{name: "scrypt memory", hash: fmt.Sprintf("$scrypt$ln=20,r=16,p=1$%s$%s", salt, digest)},
+		{name: "Argon2id m", hash: fmt.Sprintf("$argon2id$v=19$m=1048577,t=1,p=1$%s$%s", salt, digest)},
+		{name: "Argon2id t", hash: fmt.Sprintf("$argon2id$v=19$m=8192,t=11,p=1$%s$%s", salt, digest)},
+		{name: "Argon2id p", hash: fmt.Sprintf("$argon2id$v=19$m=8192,t=1,p=17$%s$%s", salt, digest)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.ImportUserPasswordHash(ctx, dbconn, userID, tt.hash)
+			require.ErrorIs(t, err, db.ErrPasswordHashUnsupported)
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrationWithEmailVerification(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.ClickOn("Create an account")
+
+	page.FillIn("Username", "newuser")
+	page.FillIn("Email", "newuser@example.com")
+	page.FillIn("Password", "password")
+	page.ClickOn("Create Account")
+
+	page.HasContent("p", "We sent a confirmation link to newuser@example.com.")
+
+	// Unverified users are limited to the verification page.
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("p", "We sent a confirmation link to newuser@example.com.")
+	page.DoesNotHaveContent("div", "Hello, newuser!")
+
+	emails := sentEmails(t, serverInstance)
+	require.Len(t, emails, 1)
+	require.Equal(t, "newuser@example.com", emails[0].To)
+
+	page.MustNavigate(findURL(t, emails[0].TextBody))
+	page.HasContent("p", "Your email address has been confirmed.")
+
+	page.ClickOn("Continue")
+	page.HasContent("div", "Hello, newuser!")
+
+	var emailVerified bool
+	err := dbconn.QueryRow(ctx, "select email_verified_time is not null from users where username = 'newuser'").Scan(&emailVerified)
+	require.NoError(t, err)
+	require.True(t, emailVerified)
+}
+
+func TestRegistrationResendVerification(t *testing.T) {
+	t.Parallel()
+
+	serverInstance := startServer(t)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/register", serverInstance.Server.URL))
+
+	page.FillIn("Username", "newuser")
+	page.FillIn("Email", "newuser@example.com")
+	page.FillIn("Password", "password")
+	page.ClickOn("Create Account")
+
+	page.ClickOn("Resend Confirmation Email")
+	page.HasContent("p", "We sent another confirmation link to newuser@example.com.")
+
+	emails := sentEmails(t, serverInstance)
+	require.Len(t, emails, 2)
+
+	// Either link confirms the address.
+	page.MustNavigate(findURL(t, emails[0].TextBody))
+	page.HasContent("p", "Your email address has been confirmed.")
+}
+
+func TestRegistrationValidatesUniqueness(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": uuid.Must(uuid.NewV7()), "username": "testuser", "email": "testuser@example.com"})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/register", serverInstance.Server.URL))
+
+	page.FillIn("Username", "testuser")
+	page.FillIn("Email", "other@example.com")
+	page.FillIn("Password", "password")
+	page.ClickOn("Create Account")
+
+	page.HasContent("li", "Username is already taken")
+	require.Empty(t, sentEmails(t, serverInstance))
+}
+
+func TestRegistrationDoesNotRevealRegisteredEmail(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{
+		"id":                  uuid.Must(uuid.NewV7()),
+		"username":            "testuser",
+		"email":               "testuser@example.com",
+		"email_verified_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/register", serverInstance.Server.URL))
+
+	page.FillIn("Username", "newuser")
+	page.FillIn("Email", "TestUser@example.com")
+	page.FillIn("Password", "password")
+	page.ClickOn("Create Account")
+
+	// The response is the same as for an address without an account. The owner is told instead.
+	page.HasContent("p", "We sent a confirmation link to TestUser@example.com.")
+
+	emails := sentEmails(t, serverInstance)
+	require.Len(t, emails, 1)
+	require.Equal(t, "testuser@example.com", emails[0].To)
+	require.Equal(t, "Your email address is already registered", emails[0].Subject)
+	require.NotContains(t, emails[0].TextBody, "verify_email")
+}
+
+func TestRegistrationDoesNotReserveUnverifiedEmail(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": uuid.Must(uuid.NewV7()), "username": "squatter", "email": "newuser@example.com"})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/register", serverInstance.Server.URL))
+
+	page.FillIn("Username", "newuser")
+	page.FillIn("Email", "newuser@example.com")
+	page.FillIn("Password", "password")
+	page.ClickOn("Create Account")
+
+	page.HasContent("p", "We sent a confirmation link to newuser@example.com.")
+
+	emails := sentEmails(t, serverInstance)
+	require.Len(t, emails, 1)
+
+	page.MustNavigate(findURL(t, emails[0].TextBody))
+	page.HasContent("p", "Your email address has been confirmed.")
+}
+
+func TestRegistrationPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	serverInstance := startServer(t)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/register", serverInstance.Server.URL))
+	page.FillIn("Username", "newuser")
+	page.FillIn("Email", "newuser@example.com")
+	page.FillIn("Password", breachedPassword)
+	page.ClickOn("Create Account")
+
+	page.HasContent("li", "Password has appeared in a data breach")
+}
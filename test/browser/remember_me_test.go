@@ -0,0 +1,143 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func findCookie(cookies []*proto.NetworkCookie, name string) *proto.NetworkCookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestLoginWithoutRememberMeUsesBrowserSessionCookie(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	cookie := findCookie(page.MustCookies(), "web-starter-app-session")
+	require.NotNil(t, cookie)
+	require.True(t, cookie.Session)
+
+	var persistent bool
+	err = dbconn.QueryRow(ctx, "select persistent from login_sessions where user_id = $1", userID).Scan(&persistent)
+	require.NoError(t, err)
+	require.False(t, persistent)
+}
+
+func TestLoginWithRememberMe(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.MustElement("input#rememberMe").MustClick()
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	cookie := findCookie(page.MustCookies(), "web-starter-app-session")
+	require.NotNil(t, cookie)
+	require.False(t, cookie.Session)
+	require.Greater(t, cookie.Expires.Time(), time.Now().Add(89*24*time.Hour))
+
+	// A remembered session is not subject to the shorter browser session idle timeout.
+	_, err = dbconn.Exec(ctx, "update login_sessions set approximate_last_request_time = now() - '25 hours'::interval where user_id = $1", userID)
+	require.NoError(t, err)
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("div", "Hello, testuser!")
+
+	// But it still has its own idle timeout.
+	_, err = dbconn.Exec(ctx, "update login_sessions set approximate_last_request_time = now() - '31 days'::interval where user_id = $1", userID)
+	require.NoError(t, err)
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.HasContent("button", "Login")
+}
+
+func TestLoginSessionsForgetRememberedDevices(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	for _, persistent := range []bool{true, false} {
+		err = pgxutil.InsertRow(ctx, dbconn, "login_sessions", map[string]any{
+			"id":                            uuid.Must(uuid.NewV7()),
+			"user_id":                       userID,
+			"login_time":                    time.Now(),
+			"login_request_id":              fmt.Sprintf("other-request-id-%t", persistent),
+			"approximate_last_request_time": time.Now(),
+			"persistent":                    persistent,
+		})
+		require.NoError(t, err)
+	}
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Sessions")
+	page.HasContent("td", "Remembered")
+	page.HasContent("td", "other-request-id-true")
+
+	page.ClickOn("Forget other remembered devices")
+	page.DoesNotHaveContent("td", "other-request-id-true")
+	page.HasContent("td", "other-request-id-false")
+	page.HasContent("td", "This device")
+}
@@ -0,0 +1,118 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/test/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	adminUserID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": adminUserID, "username": "admin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, adminUserID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, adminUserID, "password")
+	require.NoError(t, err)
+
+	userID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "admin")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, admin!")
+	page.ClickOn("Roles")
+	page.ClickOn("New Role")
+
+	page.FillIn("Name", "support")
+	page.MustElement(`input[value="users.manage"]`).MustClick()
+	page.ClickOn("Save")
+
+	page.HasContent("td", "support")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s/edit", serverInstance.Server.URL, userID))
+	page.ElementByLabel("support").MustClick()
+	page.ClickOn("Save")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s", serverInstance.Server.URL, userID))
+	page.HasContent("dd", "support")
+	page.DoesNotHaveContent("button", "Impersonate")
+
+	// The last user that can manage roles cannot remove their own role.
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s/edit", serverInstance.Server.URL, adminUserID))
+	page.ElementByLabel("admin").MustClick()
+	page.ClickOn("Save")
+	page.HasContent("li", "At least one user must be able to manage roles")
+
+	// The user's new role grants access to the users pages but not the roles pages.
+	userPage := TestBrowserManager.Acquire(t).Page()
+
+	userPage.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	userPage.FillIn("input[name=username]", "testuser")
+	userPage.FillIn("input[name=password]", "password")
+	userPage.ClickOn("Login")
+
+	userPage.HasContent("div", "Hello, testuser!")
+	userPage.HasContent("a", "Users")
+	userPage.DoesNotHaveContent("a", "Roles")
+
+	userPage.ClickOn("Users")
+	userPage.HasContent("a", "New User")
+
+	userPage.MustNavigate(fmt.Sprintf("%s/system/roles", serverInstance.Server.URL))
+	userPage.HasContent("body", "Forbidden")
+}
+
+func TestSystemPagesRequirePermission(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+	page.DoesNotHaveContent("a", "Users")
+	page.DoesNotHaveContent("a", "Roles")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users", serverInstance.Server.URL))
+	page.HasContent("body", "Forbidden")
+	page.DoesNotHaveContent("a", "New User")
+}
@@ -0,0 +1,104 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalksRowSecurity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	otherUserID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": otherUserID, "username": "otheruser"})
+	require.NoError(t, err)
+
+	otherWalkID := uuid.Must(uuid.NewV7())
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, otherUserID)
+		if err != nil {
+			return err
+		}
+		return pgxutil.InsertRow(ctx, tx, "walks", map[string]any{
+			"id":                otherWalkID,
+			"user_id":           otherUserID,
+			"duration":          time.Hour,
+			"distance_in_miles": 7.25,
+		})
+	})
+	require.NoError(t, err)
+
+	// Queries without a user_id condition only see the current user's walks.
+	countWalks := func(setup func(tx pgx.Tx) error) int {
+		var count int
+		err := pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+			err := setup(tx)
+			if err != nil {
+				return err
+			}
+			return tx.QueryRow(ctx, "select count(*) from walks").Scan(&count)
+		})
+		require.NoError(t, err)
+		return count
+	}
+	require.Equal(t, 0, countWalks(func(tx pgx.Tx) error { return db.SetCurrentUserID(ctx, tx, userID) }))
+	require.Equal(t, 1, countWalks(func(tx pgx.Tx) error { return db.SetCurrentUserID(ctx, tx, otherUserID) }))
+	require.Equal(t, 0, countWalks(func(tx pgx.Tx) error { return nil }))
+	require.Equal(t, 1, countWalks(func(tx pgx.Tx) error { return db.BypassRowSecurity(ctx, tx) }))
+
+	// A walk cannot be written for another user.
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		return pgxutil.InsertRow(ctx, tx, "walks", map[string]any{
+			"id":                uuid.Must(uuid.NewV7()),
+			"user_id":           otherUserID,
+			"duration":          time.Hour,
+			"distance_in_miles": 1,
+		})
+	})
+	require.ErrorContains(t, err, "row-level security")
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, testuser!")
+	page.DoesNotHaveContent("td", "7.25")
+
+	page.MustNavigate(fmt.Sprintf("%s/walks/%s", serverInstance.Server.URL, otherWalkID))
+	page.HasContent("body", "Not Found")
+
+	page.MustNavigate(fmt.Sprintf("%s/walks/%s/edit", serverInstance.Server.URL, otherWalkID))
+	page.HasContent("body", "Not Found")
+
+	page.MustNavigate(fmt.Sprintf("%s/walks/new", serverInstance.Server.URL))
+	page.FillIn("Duration", "30m")
+	page.FillIn("Distance", "1.5")
+	page.ClickOn("Save")
+	page.HasContent("td", "1.5")
+}
@@ -0,0 +1,176 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShareLinks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	login := func() {
+		page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+		page.FillIn("input[name=username]", "testuser")
+		page.FillIn("input[name=password]", "password")
+		page.ClickOn("Login")
+		page.HasContent("div", "Hello, testuser!")
+	}
+
+	login()
+	page.ClickOn("New walk")
+	page.FillIn("Duration", "1h")
+	page.FillIn("Distance", "3.5")
+	page.ClickOn("Save")
+	page.ClickOn("Show")
+	page.ClickOn("Share")
+	walkURL := page.MustElement("#newShareURL code").MustText()
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Share walk log")
+	logURL := page.MustElement("#newShareURL code").MustText()
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Logout")
+
+	// A walk shared with an organization is not part of the user's personal walk log.
+	organizationID, err := db.CreateOrganization(ctx, dbconn, "Walkers", userID)
+	require.NoError(t, err)
+
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		return pgxutil.InsertRow(ctx, tx, "walks", map[string]any{
+			"id":                uuid.Must(uuid.NewV7()),
+			"user_id":           userID,
+			"organization_id":   organizationID,
+			"duration":          30 * time.Minute,
+			"distance_in_miles": 7.25,
+		})
+	})
+	require.NoError(t, err)
+
+	// Share links work without logging in and are read-only.
+	page.MustNavigate(walkURL)
+	page.HasContent("div", "Walked by testuser")
+	page.HasContent("body", "3.5")
+	page.DoesNotHaveContent("a", "Edit")
+
+	page.MustNavigate(walkURL)
+	page.HasContent("div", "Walked by testuser")
+
+	page.MustNavigate(logURL)
+	page.HasContent("div", "testuser's walk log")
+	page.HasContent("td", "3.5")
+	page.DoesNotHaveContent("a", "Show")
+	page.DoesNotHaveContent("td", "7.25")
+
+	var viewCounts []int64
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		viewCounts, err = pgxutil.Select(ctx, tx, "select view_count from share_links order by walk_id nulls first", nil, pgx.RowTo[int64])
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{1, 2}, viewCounts)
+
+	// Share links are protected by row-level security.
+	var visibleCount int64
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, uuid.Must(uuid.NewV7()))
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, "select count(*) from share_links").Scan(&visibleCount)
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 0, visibleCount)
+
+	// The token in the URL is not sent to other sites.
+	response, err := http.Get(logURL)
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, "no-referrer", response.Header.Get("Referrer-Policy"))
+
+	// Revoked links stop working.
+	login()
+	page.ClickOn("Stop sharing walk log")
+	page.MustNavigate(fmt.Sprintf("%s/share_links", serverInstance.Server.URL))
+	page.HasContent("td", "Walk finished")
+	page.ClickOn("Revoke")
+	page.DoesNotHaveContent("td", "Walk finished")
+
+	page.MustNavigate(logURL)
+	page.HasContent("div", "This share link is invalid or has expired.")
+	page.MustNavigate(walkURL)
+	page.HasContent("div", "This share link is invalid or has expired.")
+}
+
+func TestShareLinkExpires(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	var token string
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		token, err = db.CreateShareLink(ctx, tx, userID, uuid.NullUUID{}, time.Hour)
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = db.ViewShareLink(ctx, dbconn, token)
+	require.NoError(t, err)
+
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "update share_links set expiration_time = now() - interval '1 second'")
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = db.ViewShareLink(ctx, dbconn, token)
+	require.ErrorIs(t, err, db.ErrShareLinkInvalid)
+
+	page := TestBrowserManager.Acquire(t).Page()
+	page.MustNavigate(fmt.Sprintf("%s/shared/%s", serverInstance.Server.URL, token))
+	page.HasContent("div", "This share link is invalid or has expired.")
+}
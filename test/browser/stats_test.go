@@ -0,0 +1,61 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+	page.HasContent("div", "Hello, testuser!")
+
+	// Statistics are shown before any walks are logged.
+	page.ClickOn("Statistics")
+	page.HasContent("div", "Statistics")
+	page.HasContent("dd", "^0$")
+
+	for _, walk := range []struct{ duration, distance string }{{"1h", "3"}, {"30m", "2"}} {
+		page.MustNavigate(serverInstance.Server.URL)
+		page.ClickOn("New walk")
+		page.FillIn("Duration", walk.duration)
+		page.FillIn("Distance", walk.distance)
+		page.ClickOn("Save")
+	}
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Statistics")
+	page.HasContent("dd", "^2$")
+	page.HasContent("dd", "5 mi")
+	page.HasContent("dd", "1h30m0s")
+	page.HasContent("dd", "18:00 per mi")
+	page.HasContent("dd", "3 mi in 1h0m0s")
+	require.Len(t, page.MustElements("svg rect.stats-bar"), 24)
+
+	page.ClickOn("Yearly")
+	page.HasContent("#stats-period strong", "Yearly")
+	require.Len(t, page.MustElements("svg rect.stats-bar"), 10)
+}
@@ -0,0 +1,80 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemporaryPasswordMustBeChanged(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.ResetUserPassword(ctx, dbconn, userID, "temporary", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "temporary")
+	page.ClickOn("Login")
+
+	page.HasContent("#passwordChangeRequired", "Choose a new password to continue.")
+
+	// Every other page redirects back to change password.
+	page.MustNavigate(fmt.Sprintf("%s/walks/new", serverInstance.Server.URL))
+	page.HasContent("#passwordChangeRequired", "Choose a new password to continue.")
+
+	page.FillIn("Current Password", "temporary")
+	page.FillIn("New Password", "newpassword")
+	page.ClickOn("Save")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	page.MustNavigate(fmt.Sprintf("%s/walks/new", serverInstance.Server.URL))
+	page.DoesNotHaveContent("#passwordChangeRequired", "Choose a new password")
+
+	var temporary bool
+	err = dbconn.QueryRow(ctx, "select temporary_expire_time is not null from user_passwords where user_id = $1", userID).Scan(&temporary)
+	require.NoError(t, err)
+	require.False(t, temporary)
+}
+
+func TestTemporaryPasswordExpires(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.ResetUserPassword(ctx, dbconn, userID, "temporary", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "temporary")
+	page.ClickOn("Login")
+
+	page.HasContent("body", "Your temporary password has expired.")
+	page.DoesNotHaveContent("div", "Hello, testuser!")
+}
@@ -0,0 +1,153 @@
+package browser_test
+
+import (
+	"context"
+	"encoding/base32"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/lib/totp"
+	"github.com/jackc/web-starter-app/test/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactorSetupAndLoginWithRecoveryCode(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.ClickOn("Two-Factor Authentication")
+	page.ClickOn("Set up two-factor authentication")
+
+	encodedSecret := page.MustElement("#totpSecret").MustText()
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encodedSecret)
+	require.NoError(t, err)
+
+	page.FillIn("Authentication Code", totp.Code(secret, time.Now()))
+	page.ClickOn("Verify")
+
+	page.HasContent("div", "Recovery Codes")
+	recoveryCode := page.MustElement("li code").MustText()
+	require.Regexp(t, `^([2-9a-z]{4}-){6}[2-9a-z]{4}$`, recoveryCode)
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Logout")
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.FillIn("Authentication Code", recoveryCode)
+	page.ClickOn("Verify")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	var unusedRecoveryCodeCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from user_recovery_codes where user_id = $1 and used_time is null", userID).Scan(&unusedRecoveryCodeCount)
+	require.NoError(t, err)
+	require.Equal(t, 9, unusedRecoveryCodeCount)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	secret, err := db.StartUserTwoFactorSetup(ctx, dbconn, userID)
+	require.NoError(t, err)
+	_, err = db.EnableUserTwoFactor(ctx, dbconn, userID, totp.Code(secret, time.Now()))
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.FillIn("Authentication Code", "000000")
+	page.ClickOn("Verify")
+	page.HasContent("li", "Invalid code")
+
+	var sessionCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from login_sessions where user_id = $1", userID).Scan(&sessionCount)
+	require.NoError(t, err)
+	require.Equal(t, 0, sessionCount)
+
+	// The code for the current step was consumed when two-factor authentication was enabled. Use the next step's code
+	// which is accepted due to allowed clock skew.
+	page.FillIn("Authentication Code", totp.CodeAtStep(secret, totp.Step(time.Now())+1))
+	page.ClickOn("Verify")
+
+	page.HasContent("div", "Hello, testuser!")
+}
+
+func TestTwoFactorRequiredForcesSetupAtLogin(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	userID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser", "two_factor_required": true})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, userID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	encodedSecret := page.MustElement("#totpSecret").MustText()
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encodedSecret)
+	require.NoError(t, err)
+
+	page.FillIn("Authentication Code", totp.Code(secret, time.Now()))
+	page.ClickOn("Verify")
+
+	page.HasContent("div", "Recovery Codes")
+	page.ClickOn("Continue")
+
+	page.HasContent("div", "Hello, testuser!")
+
+	twoFactorEnabled, err := db.UserTwoFactorEnabled(ctx, dbconn, userID)
+	require.NoError(t, err)
+	require.True(t, twoFactorEnabled)
+}
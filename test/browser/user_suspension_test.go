@@ -0,0 +1,162 @@
+package browser_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
+	"github.com/jackc/web-starter-app/db"
+	"github.com/jackc/web-starter-app/test/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserSuspension(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	adminUserID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": adminUserID, "username": "admin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, adminUserID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, adminUserID, "password")
+	require.NoError(t, err)
+
+	userID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	err = pgxutil.InsertRow(ctx, dbconn, "login_sessions", map[string]any{
+		"id":                            uuid.Must(uuid.NewV7()),
+		"user_id":                       userID,
+		"user_agent":                    "Mozilla/5.0 (X11; Linux x86_64; rv:133.0) Gecko/20100101 Firefox/133.0",
+		"login_time":                    time.Now(),
+		"login_request_id":              "other-request-id",
+		"approximate_last_request_time": time.Now(),
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "admin")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, admin!")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s", serverInstance.Server.URL, userID))
+	page.ClickOn("Suspend")
+	page.HasContent("dd", "Suspended")
+
+	var loginSessionCount int
+	err = dbconn.QueryRow(ctx, "select count(*) from login_sessions where user_id = $1", userID).Scan(&loginSessionCount)
+	require.NoError(t, err)
+	require.Equal(t, 0, loginSessionCount)
+
+	user, err := db.GetUserByID(ctx, dbconn, userID)
+	require.NoError(t, err)
+	require.True(t, user.Disabled)
+
+	page.MustNavigate(serverInstance.Server.URL)
+	page.ClickOn("Logout")
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+	page.HasContent("body", "This account has been suspended.")
+
+	err = db.ReactivateUser(ctx, dbconn, userID)
+	require.NoError(t, err)
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+	page.FillIn("input[name=username]", "testuser")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+	page.HasContent("div", "Hello, testuser!")
+}
+
+func TestUserPermanentDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	serverInstance := startServer(t)
+	dbconn := serverInstance.DB.Connect(t, ctx)
+	adminUserID := uuid.Must(uuid.NewV7())
+	err := pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": adminUserID, "username": "admin"})
+	require.NoError(t, err)
+
+	err = testutil.GrantUserRole(ctx, dbconn, adminUserID, "admin")
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, adminUserID, "password")
+	require.NoError(t, err)
+
+	userID := uuid.Must(uuid.NewV7())
+	err = pgxutil.InsertRow(ctx, dbconn, "users", map[string]any{"id": userID, "username": "testuser"})
+	require.NoError(t, err)
+
+	err = db.SetUserPassword(ctx, dbconn, userID, "password")
+	require.NoError(t, err)
+
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.SetCurrentUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		return pgxutil.InsertRow(ctx, tx, "walks", map[string]any{
+			"id":                uuid.Must(uuid.NewV7()),
+			"user_id":           userID,
+			"duration":          time.Hour,
+			"distance_in_miles": 3,
+		})
+	})
+	require.NoError(t, err)
+
+	page := TestBrowserManager.Acquire(t).Page()
+
+	page.MustNavigate(fmt.Sprintf("%s/login", serverInstance.Server.URL))
+
+	page.FillIn("input[name=username]", "admin")
+	page.FillIn("input[name=password]", "password")
+	page.ClickOn("Login")
+
+	page.HasContent("div", "Hello, admin!")
+
+	// An administrator cannot suspend or delete themselves.
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s", serverInstance.Server.URL, adminUserID))
+	page.ClickOn("Suspend")
+	page.HasContent("li", "You cannot suspend yourself")
+	page.ClickOn("Permanently Delete")
+	page.HasContent("li", "You cannot delete yourself")
+
+	page.MustNavigate(fmt.Sprintf("%s/system/users/%s", serverInstance.Server.URL, userID))
+	page.ClickOn("Permanently Delete")
+	page.HasContent("a", "New User")
+	page.DoesNotHaveContent("span", "testuser")
+
+	var userCount, walkCount int
+	err = pgx.BeginFunc(ctx, dbconn, func(tx pgx.Tx) error {
+		err := db.BypassRowSecurity(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, "select (select count(*) from users where id = $1), (select count(*) from walks where user_id = $1)", userID).Scan(&userCount, &walkCount)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, userCount)
+	require.Equal(t, 0, walkCount)
+}
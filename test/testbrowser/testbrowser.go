@@ -154,6 +154,31 @@ func (p *Page) AcceptDialog(fn func()) {
 	}
 }
 
+// AddVirtualAuthenticator adds a WebAuthn virtual authenticator to the page. It behaves like a platform authenticator
+// that supports passkeys and automatically approves user presence and verification.
+func (p *Page) AddVirtualAuthenticator() {
+	p.t.Helper()
+
+	err := proto.WebAuthnEnable{}.Call(p.Page)
+	if err != nil {
+		p.t.Fatalf("failed to enable WebAuthn: %v", err)
+	}
+
+	_, err = proto.WebAuthnAddVirtualAuthenticator{
+		Options: &proto.WebAuthnVirtualAuthenticatorOptions{
+			Protocol:                    proto.WebAuthnAuthenticatorProtocolCtap2,
+			Transport:                   proto.WebAuthnAuthenticatorTransportInternal,
+			HasResidentKey:              true,
+			HasUserVerification:         true,
+			IsUserVerified:              true,
+			AutomaticPresenceSimulation: true,
+		},
+	}.Call(p.Page)
+	if err != nil {
+		p.t.Fatalf("failed to add virtual authenticator: %v", err)
+	}
+}
+
 func (p *Page) HasContent(selector, jsRegex string) {
 	p.t.Helper()
 
@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gofrs/uuid/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgxutil"
 	"github.com/jackc/testdb"
 )
 
@@ -22,8 +24,16 @@ func InitTestDBManager(*testing.M) *testdb.Manager {
 
 	manager := &testdb.Manager{
 		ResetDB: func(ctx context.Context, conn *pgx.Conn) error {
-			_, err := conn.Exec(ctx, `select pgundolog.undo()`)
-			return err
+			// Undoing changes to tables protected by row-level security requires access to the rows of every user.
+			return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, `select set_config('app.bypass_row_security', 'on', true)`)
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.Exec(ctx, `select pgundolog.undo()`)
+				return err
+			})
 		},
 		MakeConnConfig: func(t testing.TB, connConfig *pgx.ConnConfig) *pgx.ConnConfig {
 			newConnConfig := testConnConfig.Copy()
@@ -42,3 +52,16 @@ func InitTestDBManager(*testing.M) *testdb.Manager {
 
 	return manager
 }
+
+// GrantUserRole adds the role named roleName to userID. It is only for setting up tests. The application changes roles
+// with db.SetUserRoles, which refuses to remove the last user that can manage roles.
+func GrantUserRole(ctx context.Context, db pgxutil.DB, userID uuid.UUID, roleName string) error {
+	_, err := pgxutil.ExecRow(ctx, db,
+		`insert into user_roles (user_id, role_id)
+select $1, id
+from roles
+where name = $2`,
+		userID, roleName,
+	)
+	return err
+}
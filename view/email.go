@@ -0,0 +1,18 @@
+package view
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/a-h/templ"
+)
+
+// textLines renders lines separated by newlines without HTML escaping. templ collapses whitespace and escapes text so
+// plain text email bodies are written with it.
+func textLines(lines ...string) templ.Component {
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+		return err
+	})
+}
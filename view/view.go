@@ -4,13 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+
+	"github.com/jackc/web-starter-app/lib/distance"
+	"github.com/shopspring/decimal"
 )
 
 const EnvironmentCtxKey = "view.Environment"
 
 type Environment struct {
-	AssetManifest map[string]string
-	ViteHotReload bool
+	AssetManifest       map[string]string
+	ViteHotReload       bool
+	RegistrationEnabled bool
+
+	// OIDCDisplayName is the name of the single sign-on identity provider. It is empty if single sign-on is not
+	// configured.
+	OIDCDisplayName string
 }
 
 func assetPath(ctx context.Context, name string) (string, error) {
@@ -33,6 +42,16 @@ func viteHotReload(ctx context.Context) bool {
 	return env.ViteHotReload
 }
 
+func registrationEnabled(ctx context.Context) bool {
+	env := ctx.Value(EnvironmentCtxKey).(*Environment)
+	return env.RegistrationEnabled
+}
+
+func oidcDisplayName(ctx context.Context) string {
+	env := ctx.Value(EnvironmentCtxKey).(*Environment)
+	return env.OIDCDisplayName
+}
+
 func csrfToken(ctx context.Context) (string, error) {
 	token, ok := ctx.Value("gorilla.csrf.Token").(string)
 	if !ok {
@@ -40,3 +59,65 @@ func csrfToken(ctx context.Context) (string, error) {
 	}
 	return token, nil
 }
+
+const ImpersonationCtxKey = "view.Impersonation"
+
+// Impersonation describes the impersonation in progress for the current request.
+type Impersonation struct {
+	ImpersonatorUsername string
+	ImpersonatedUsername string
+}
+
+// impersonation returns the impersonation in progress or nil if the current request is not impersonating a user.
+func impersonation(ctx context.Context) *Impersonation {
+	impersonation, _ := ctx.Value(ImpersonationCtxKey).(*Impersonation)
+	return impersonation
+}
+
+const PermissionsCtxKey = "view.Permissions"
+
+// hasPermission returns true if the current user has permission. It is used to hide links to pages the current user
+// cannot use.
+func hasPermission(ctx context.Context, permission string) bool {
+	permissions, _ := ctx.Value(PermissionsCtxKey).([]string)
+	return slices.Contains(permissions, permission)
+}
+
+const OrganizationSwitcherCtxKey = "view.OrganizationSwitcher"
+
+// OrganizationSwitcher lists the organizations the current user can switch between.
+type OrganizationSwitcher struct {
+	Organizations []SwitcherOrganization
+
+	// CurrentOrganizationID is empty when the user is working with their personal walk log.
+	CurrentOrganizationID string
+}
+
+type SwitcherOrganization struct {
+	ID   string
+	Name string
+}
+
+// organizationSwitcher returns the organization switcher for the current user or nil if they are not a member of any
+// organization.
+func organizationSwitcher(ctx context.Context) *OrganizationSwitcher {
+	switcher, _ := ctx.Value(OrganizationSwitcherCtxKey).(*OrganizationSwitcher)
+	return switcher
+}
+
+const DistanceUnitCtxKey = "view.DistanceUnit"
+
+// distanceUnit returns the unit distances are shown in. It is miles when no unit has been chosen.
+func distanceUnit(ctx context.Context) distance.Unit {
+	unit, _ := ctx.Value(DistanceUnitCtxKey).(distance.Unit)
+	if !unit.Valid() {
+		return distance.Miles
+	}
+	return unit
+}
+
+// formatDistance formats miles in the current unit rounded for display such as "5 km".
+func formatDistance(ctx context.Context, miles decimal.Decimal) string {
+	unit := distanceUnit(ctx)
+	return unit.FromMilesForDisplay(miles).String() + " " + string(unit)
+}